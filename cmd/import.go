@@ -54,6 +54,7 @@ type ImportOptions struct {
 	NoSort        bool
 	RetryCount    int
 	RetrySleepMs  int
+	OpenTofu      bool
 }
 
 const DefaultPathPattern = "{output}/{provider}/{service}/"
@@ -255,11 +256,18 @@ func printService(provider terraformutils.ProviderGenerator, serviceName string,
 	log.Println(provider.GetName() + " save " + serviceName)
 	// Print HCL files for Resources
 	path := Path(options.PathPattern, provider.GetName(), serviceName, options.PathOutput)
-	err := terraformoutput.OutputHclFiles(resources, provider, path, serviceName, options.Compact, options.Output, !options.NoSort)
+	registryHost := ""
+	if options.OpenTofu {
+		registryHost = terraformutils.OpenTofuRegistryHost
+	}
+	err := terraformoutput.OutputHclFilesForRegistry(resources, provider, path, serviceName, options.Compact, options.Output, !options.NoSort, registryHost)
 	if err != nil {
 		return err
 	}
-	tfStateFile, err := terraformutils.PrintTfState(resources)
+	tfStateFile, err := terraformutils.PrintTfStateWithOptions(resources, terraformutils.TfStateOptions{
+		RegistryHost:   registryHost,
+		ProviderSource: terraformoutput.ProviderSource(provider),
+	})
 	if err != nil {
 		return err
 	}
@@ -410,4 +418,5 @@ func baseProviderFlags(flag *pflag.FlagSet, options *ImportOptions, sampleRes, s
 	flag.StringVarP(&options.Output, "output", "O", "hcl", "output format hcl or json")
 	flag.IntVarP(&options.RetryCount, "retry-number", "n", 5, "number of retries to perform when refresh fails")
 	flag.IntVarP(&options.RetrySleepMs, "retry-sleep-ms", "m", 300, "time in ms to sleep between retries")
+	flag.BoolVar(&options.OpenTofu, "opentofu", false, "address providers on the OpenTofu registry")
 }
@@ -0,0 +1,252 @@
+// Copyright 2018 The Terraformer Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package terraformutils
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/terraformer/terraformutils/providerwrapper"
+	"github.com/GoogleCloudPlatform/terraformer/terraformutils/tfplugin/stoleninternal/configschema"
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/go-cty/cty/convert"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov5"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+// NewAttributeOutput builds an output exposing the attribute of r at path,
+// in the dotted form used by flatmapped states, e.g.
+// network_interface.0.access_config.0.nat_ip. The output is sensitive when
+// the provider schema marks the attribute as such. Primitive values become
+// string outputs, and lists, sets and maps of primitives list and map
+// outputs. Add it to r.Outputs to have it written to the state.
+func NewAttributeOutput(r Resource, path string, provider *providerwrapper.ProviderWrapper) (*terraform.OutputState, error) {
+	schema, err := provider.GetSchema()
+	if err != nil {
+		return nil, err
+	}
+	resourceSchema, ok := schema.ResourceSchemas[r.InstanceInfo.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown resource type %q", r.InstanceInfo.Type)
+	}
+	block := resourceSchema.Block
+	val, err := r.InstanceState.AttrsAsObjectValue(configschema.WrapBlock(block).ImpliedType())
+	if err != nil {
+		return nil, err
+	}
+	sensitive := false
+	for _, step := range strings.Split(path, ".") {
+		if !val.IsKnown() || val.IsNull() {
+			return nil, fmt.Errorf("%s of %s is null", path, r.InstanceInfo.Id)
+		}
+		if block != nil && val.Type().IsObjectType() {
+			var found bool
+			block, sensitive, found = outputSchemaStep(block, step)
+			if !found {
+				return nil, fmt.Errorf("%s has no attribute %q", r.InstanceInfo.Type, path)
+			}
+			val = val.GetAttr(step)
+			continue
+		}
+		val, err = outputValueStep(val, step)
+		if err != nil {
+			return nil, fmt.Errorf("%s of %s: %w", path, r.InstanceInfo.Id, err)
+		}
+	}
+	if !val.IsWhollyKnown() || val.IsNull() {
+		return nil, fmt.Errorf("%s of %s is null", path, r.InstanceInfo.Id)
+	}
+	output, err := valueOutput(val, sensitive)
+	if err != nil {
+		return nil, fmt.Errorf("%s of %s: %w", path, r.InstanceInfo.Id, err)
+	}
+	return output, nil
+}
+
+// valueOutput builds an output of val typed after its cty type, the schema
+// type of the attribute it was read from.
+func valueOutput(val cty.Value, sensitive bool) (*terraform.OutputState, error) {
+	var err error
+	output := &terraform.OutputState{Sensitive: sensitive}
+	ty := val.Type()
+	switch {
+	case ty.IsPrimitiveType():
+		output.Type = "string"
+		output.Value, err = outputString(val)
+	case ty.IsListType() || ty.IsSetType() || ty.IsTupleType():
+		list := []interface{}{}
+		for _, elem := range val.AsValueSlice() {
+			s, elemErr := outputString(elem)
+			if elemErr != nil {
+				err = elemErr
+				break
+			}
+			list = append(list, s)
+		}
+		output.Type, output.Value = "list", list
+	case ty.IsMapType() || ty.IsObjectType():
+		m := map[string]interface{}{}
+		for key, elem := range val.AsValueMap() {
+			s, elemErr := outputString(elem)
+			if elemErr != nil {
+				err = elemErr
+				break
+			}
+			m[key] = s
+		}
+		output.Type, output.Value = "map", m
+	default:
+		err = fmt.Errorf("unsupported output of %s", ty.FriendlyName())
+	}
+	if err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+// outputSchemaStep looks up name in block, returning the nested block schema
+// it names, if any, and whether it is a sensitive attribute.
+func outputSchemaStep(block *tfprotov5.SchemaBlock, name string) (*tfprotov5.SchemaBlock, bool, bool) {
+	for _, attr := range block.Attributes {
+		if attr.Name == name {
+			return nil, attr.Sensitive, true
+		}
+	}
+	for _, nested := range block.BlockTypes {
+		if nested.TypeName == name {
+			return nested.Block, false, true
+		}
+	}
+	return nil, false, false
+}
+
+// outputValueStep indexes val, a collection or an object attribute, by step.
+func outputValueStep(val cty.Value, step string) (cty.Value, error) {
+	ty := val.Type()
+	switch {
+	case ty.IsListType() || ty.IsSetType() || ty.IsTupleType():
+		idx, err := strconv.Atoi(step)
+		elems := val.AsValueSlice()
+		if err != nil || idx < 0 || idx >= len(elems) {
+			return cty.NilVal, fmt.Errorf("no element %q", step)
+		}
+		return elems[idx], nil
+	case ty.IsMapType():
+		if !val.HasIndex(cty.StringVal(step)).True() {
+			return cty.NilVal, fmt.Errorf("no key %q", step)
+		}
+		return val.Index(cty.StringVal(step)), nil
+	case ty.IsObjectType():
+		if !ty.HasAttribute(step) {
+			return cty.NilVal, fmt.Errorf("no attribute %q", step)
+		}
+		return val.GetAttr(step), nil
+	}
+	return cty.NilVal, fmt.Errorf("can't look up %q in a %s", step, ty.FriendlyName())
+}
+
+func outputString(val cty.Value) (string, error) {
+	if !val.Type().IsPrimitiveType() {
+		return "", fmt.Errorf("unsupported output of %s", val.Type().FriendlyName())
+	}
+	if val.IsNull() {
+		return "", nil
+	}
+	s, err := convert.Convert(val, cty.String)
+	if err != nil {
+		return "", err
+	}
+	return s.AsString(), nil
+}
+
+// typedOutput returns output with its Type set when it has none, e.g. an
+// output built by hand. Without a type, terraform_remote_state can't read the
+// state. A cty.Value, such as an attribute of a refreshed value, is typed
+// after its schema type like NewAttributeOutput does. Other values are typed
+// after their Go shape instead, with scalars turned into strings as version
+// 3 states only know string, list and map outputs. Outputs of values that
+// don't fit those, such as nested lists, are returned as is.
+func typedOutput(output *terraform.OutputState) *terraform.OutputState {
+	if output == nil || output.Type != "" {
+		return output
+	}
+	if val, ok := output.Value.(cty.Value); ok {
+		if !val.IsWhollyKnown() || val.IsNull() {
+			return output
+		}
+		typed, err := valueOutput(val, output.Sensitive)
+		if err != nil {
+			return output
+		}
+		return typed
+	}
+	typed := &terraform.OutputState{Sensitive: output.Sensitive}
+	switch v := output.Value.(type) {
+	case []interface{}:
+		list := make([]interface{}, 0, len(v))
+		for _, elem := range v {
+			s, ok := outputScalar(elem)
+			if !ok {
+				return output
+			}
+			list = append(list, s)
+		}
+		typed.Type, typed.Value = "list", list
+	case []string:
+		list := make([]interface{}, 0, len(v))
+		for _, elem := range v {
+			list = append(list, elem)
+		}
+		typed.Type, typed.Value = "list", list
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			s, ok := outputScalar(elem)
+			if !ok {
+				return output
+			}
+			m[key] = s
+		}
+		typed.Type, typed.Value = "map", m
+	case map[string]string:
+		m := make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			m[key] = elem
+		}
+		typed.Type, typed.Value = "map", m
+	default:
+		s, ok := outputScalar(v)
+		if !ok {
+			return output
+		}
+		typed.Type, typed.Value = "string", s
+	}
+	return typed
+}
+
+// outputScalar formats v, a string, bool or number, as an output string.
+func outputScalar(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case bool:
+		return strconv.FormatBool(v), true
+	case int, int32, int64, uint, uint32, uint64, float32, float64, json.Number:
+		return fmt.Sprint(v), true
+	}
+	return "", false
+}
@@ -0,0 +1,111 @@
+package terraformutils
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov5"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+func TestNewAttributeOutput(t *testing.T) {
+	provider := newFakeProviderWrapper(t, &fakeProviderServer{resourceSchema: &tfprotov5.SchemaBlock{
+		Attributes: []*tfprotov5.SchemaAttribute{
+			{Name: "id", Type: tftypes.String, Optional: true, Computed: true},
+			{Name: "password", Type: tftypes.String, Optional: true, Sensitive: true},
+			{Name: "zones", Type: tftypes.List{ElementType: tftypes.String}, Optional: true},
+		},
+		BlockTypes: []*tfprotov5.SchemaNestedBlock{{
+			TypeName: "network_interface",
+			Nesting:  tfprotov5.SchemaNestedBlockNestingModeList,
+			Block: &tfprotov5.SchemaBlock{
+				Attributes: []*tfprotov5.SchemaAttribute{
+					{Name: "nat_ip", Type: tftypes.String, Computed: true},
+				},
+			},
+		}},
+	}})
+	r := NewResource("vm-1", "vm", "fake_resource", "fake", map[string]string{
+		"password":                   "hunter2",
+		"zones.#":                    "2",
+		"zones.0":                    "a",
+		"zones.1":                    "b",
+		"network_interface.#":        "1",
+		"network_interface.0.nat_ip": "203.0.113.7",
+	}, nil, nil)
+
+	for _, test := range []struct {
+		path string
+		want *terraform.OutputState
+	}{
+		{"network_interface.0.nat_ip", &terraform.OutputState{Type: "string", Value: "203.0.113.7"}},
+		{"password", &terraform.OutputState{Type: "string", Value: "hunter2", Sensitive: true}},
+		{"zones", &terraform.OutputState{Type: "list", Value: []interface{}{"a", "b"}}},
+	} {
+		got, err := NewAttributeOutput(r, test.path, provider)
+		if err != nil {
+			t.Fatalf("%s: %v", test.path, err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: expected %#v, got %#v", test.path, test.want, got)
+		}
+	}
+
+	for path, wantErr := range map[string]string{
+		"missing":                    `has no attribute "missing"`,
+		"network_interface.1.nat_ip": `no element "1"`,
+	} {
+		if _, err := NewAttributeOutput(r, path, provider); err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("%s: expected error %q, got %v", path, wantErr, err)
+		}
+	}
+}
+
+func TestPrintTfStateTypesOutputs(t *testing.T) {
+	r := NewSimpleResource("vm-1", "vm", "fake_resource", "fake", []string{})
+	nested := []interface{}{[]interface{}{"a"}}
+	r.Outputs = map[string]*terraform.OutputState{
+		"name":   {Value: "web"},
+		"port":   {Value: 8080, Sensitive: true},
+		"zones":  {Value: []interface{}{"a", 2}},
+		"tags":   {Value: map[string]interface{}{"env": "prod", "public": true}},
+		"typed":  {Type: "string", Value: "kept"},
+		"nested": {Value: nested},
+		"ports":  {Value: cty.ListVal([]cty.Value{cty.NumberIntVal(80), cty.NumberIntVal(443)})},
+		"labels": {Value: cty.MapVal(map[string]cty.Value{"tier": cty.StringVal("web")})},
+		"public": {Value: cty.True},
+	}
+
+	b, err := PrintTfState([]Resource{r})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state terraform.State
+	if err := json.Unmarshal(b, &state); err != nil {
+		t.Fatal(err)
+	}
+	outputs := state.RootModule().Outputs
+	for name, want := range map[string]*terraform.OutputState{
+		"name":   {Type: "string", Value: "web"},
+		"port":   {Type: "string", Value: "8080", Sensitive: true},
+		"zones":  {Type: "list", Value: []interface{}{"a", "2"}},
+		"tags":   {Type: "map", Value: map[string]interface{}{"env": "prod", "public": "true"}},
+		"typed":  {Type: "string", Value: "kept"},
+		"nested": {Value: nested},
+		"ports":  {Type: "list", Value: []interface{}{"80", "443"}},
+		"labels": {Type: "map", Value: map[string]interface{}{"tier": "web"}},
+		"public": {Type: "string", Value: "true"},
+	} {
+		got := outputs[name]
+		if got == nil || got.Type != want.Type || got.Sensitive != want.Sensitive || !reflect.DeepEqual(got.Value, want.Value) {
+			t.Errorf("%s: expected %#v, got %#v", name, want, got)
+		}
+	}
+	if r.Outputs["name"].Type != "" {
+		t.Error("expected the outputs of the resource to be left untouched")
+	}
+}
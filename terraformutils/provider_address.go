@@ -0,0 +1,41 @@
+// Copyright 2018 The Terraformer Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package terraformutils
+
+import "strings"
+
+const (
+	// TerraformRegistryHost is the registry Terraform resolves provider sources against.
+	TerraformRegistryHost = "registry.terraform.io"
+	// OpenTofuRegistryHost is the registry OpenTofu resolves provider sources against.
+	OpenTofuRegistryHost = "registry.opentofu.org"
+)
+
+const defaultProviderNamespace = "hashicorp"
+
+// ProviderSource returns the fully qualified host/namespace/type source of a
+// provider on the given registry host. source is the provider's own source,
+// "namespace/type" or "host/namespace/type"; when empty the provider is
+// assumed to live in the hashicorp namespace.
+func ProviderSource(registryHost, providerName, source string) string {
+	if source == "" {
+		source = defaultProviderNamespace + "/" + providerName
+	}
+	parts := strings.Split(source, "/")
+	if len(parts) == 3 {
+		parts = parts[1:]
+	}
+	return registryHost + "/" + strings.Join(parts, "/")
+}
@@ -0,0 +1,69 @@
+package terraformutils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProviderSource(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"", "registry.opentofu.org/hashicorp/google"},
+		{"integrations/github", "registry.opentofu.org/integrations/github"},
+		{"registry.terraform.io/integrations/github", "registry.opentofu.org/integrations/github"},
+	}
+	for _, tt := range tests {
+		if got := ProviderSource(OpenTofuRegistryHost, "google", tt.source); got != tt.want {
+			t.Errorf("ProviderSource(%q) = %q, want %q", tt.source, got, tt.want)
+		}
+	}
+}
+
+func TestPrintTfStateOpenTofu(t *testing.T) {
+	resources := []Resource{NewSimpleResource("first", "first", "google_compute_network", "google", []string{})}
+
+	legacy, err := PrintTfState(resources)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(legacy), OpenTofuRegistryHost) {
+		t.Errorf("expected no OpenTofu address by default, got %s", legacy)
+	}
+
+	state, err := PrintTfStateWithOptions(resources, TfStateOptions{RegistryHost: OpenTofuRegistryHost})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(state), `provider[\"registry.opentofu.org/hashicorp/google\"]`) {
+		t.Errorf("expected OpenTofu provider address, got %s", state)
+	}
+}
+
+func TestPrintTfStateProviderAlias(t *testing.T) {
+	aliased := NewSimpleResource("vpc-1", "west", "aws_vpc", "aws", []string{})
+	aliased.ProviderAlias = "us_west"
+	resources := []Resource{NewSimpleResource("vpc-2", "east", "aws_vpc", "aws", []string{}), aliased}
+
+	for _, test := range []struct {
+		options TfStateOptions
+		want    map[string]string
+	}{
+		{TfStateOptions{}, map[string]string{
+			"aws_vpc.tfer--east": "provider.aws",
+			"aws_vpc.tfer--west": "provider.aws.us_west",
+		}},
+		{TfStateOptions{RegistryHost: TerraformRegistryHost}, map[string]string{
+			"aws_vpc.tfer--east": `provider["registry.terraform.io/hashicorp/aws"]`,
+			"aws_vpc.tfer--west": `provider["registry.terraform.io/hashicorp/aws"].us_west`,
+		}},
+	} {
+		state := NewTfStateWithOptions(resources, test.options)
+		for address, want := range test.want {
+			if got := state.RootModule().Resources[address].Provider; got != want {
+				t.Errorf("expected %s to use %s, got %s", address, want, got)
+			}
+		}
+	}
+}
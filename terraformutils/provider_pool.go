@@ -0,0 +1,92 @@
+// Copyright 2018 The Terraformer Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package terraformutils
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/GoogleCloudPlatform/terraformer/terraformutils/providerwrapper"
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov5"
+)
+
+// ProviderSpec describes one provider for NewProviderWrappers.
+type ProviderSpec struct {
+	Name    string
+	Config  cty.Value
+	Options []providerwrapper.ProviderOption
+	// Server, when set, is an already running provider to wrap instead of
+	// launching the plugin binary of Name.
+	Server tfprotov5.ProviderServer
+}
+
+type providerInit struct {
+	spec     ProviderSpec
+	provider *providerwrapper.ProviderWrapper
+	err      error
+}
+
+// NewProviderWrappers starts the given providers concurrently, keyed by name,
+// so their startup latencies don't add up. Providers that fail to start are
+// killed and left out, and their errors are returned together. Names must be
+// unique, nothing is started otherwise.
+func NewProviderWrappers(specs []ProviderSpec, verbose bool) (map[string]*providerwrapper.ProviderWrapper, error) {
+	inits := make([]providerInit, 0, len(specs))
+	names := map[string]bool{}
+	for _, spec := range specs {
+		if names[spec.Name] {
+			return nil, fmt.Errorf("provider %s is given more than once", spec.Name)
+		}
+		names[spec.Name] = true
+		inits = append(inits, providerInit{spec: spec})
+	}
+	// tasks never fail, errors are collected per provider instead
+	done, _ := DoWorkPooled(inits, len(inits), func(init providerInit) (*providerInit, error) {
+		if init.spec.Server != nil {
+			init.provider, init.err = providerwrapper.NewProviderWrapperFromServer(init.spec.Name, init.spec.Server, init.spec.Config, init.spec.Options...)
+		} else {
+			init.provider, init.err = providerwrapper.NewProviderWrapperWithOptions(init.spec.Name, init.spec.Config, verbose, init.spec.Options...)
+		}
+		if init.err != nil && init.provider != nil {
+			// don't leak the process of a half started provider
+			init.provider.Kill()
+			init.provider = nil
+		}
+		return &init, nil
+	})
+
+	providers := map[string]*providerwrapper.ProviderWrapper{}
+	var failed []string
+	errs := map[string]error{}
+	for _, init := range done {
+		if init.err != nil {
+			failed = append(failed, init.spec.Name)
+			errs[init.spec.Name] = init.err
+			continue
+		}
+		providers[init.spec.Name] = init.provider
+	}
+	if len(failed) == 0 {
+		return providers, nil
+	}
+	sort.Strings(failed)
+	msg := "failed to start providers:"
+	for _, name := range failed {
+		msg = fmt.Sprintf("%s\n%s: %s", msg, name, errs[name])
+	}
+	return providers, errors.New(msg)
+}
@@ -0,0 +1,72 @@
+package terraformutils
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov5"
+)
+
+func TestNewProviderWrappersStartsConcurrently(t *testing.T) {
+	// each provider only finishes configuring once both have started to
+	var started sync.WaitGroup
+	started.Add(2)
+	barrier := func(ctx context.Context, req *tfprotov5.ConfigureProviderRequest) (*tfprotov5.ConfigureProviderResponse, error) {
+		started.Done()
+		waited := make(chan struct{})
+		go func() {
+			started.Wait()
+			close(waited)
+		}()
+		select {
+		case <-waited:
+			return &tfprotov5.ConfigureProviderResponse{}, nil
+		case <-time.After(10 * time.Second):
+			return nil, errors.New("providers were started one after the other")
+		}
+	}
+	failing := func(ctx context.Context, req *tfprotov5.ConfigureProviderRequest) (*tfprotov5.ConfigureProviderResponse, error) {
+		return nil, errors.New("bad credentials")
+	}
+
+	providers, err := NewProviderWrappers([]ProviderSpec{
+		{Name: "first", Config: cty.EmptyObjectVal, Server: &fakeProviderServer{configureProvider: barrier}},
+		{Name: "second", Config: cty.EmptyObjectVal, Server: &fakeProviderServer{configureProvider: barrier}},
+		{Name: "broken", Config: cty.EmptyObjectVal, Server: &fakeProviderServer{configureProvider: failing}},
+	}, false)
+	if err == nil || !strings.Contains(err.Error(), "broken: bad credentials") {
+		t.Errorf("expected the broken provider to be reported, got %v", err)
+	}
+	if len(providers) != 2 || providers["first"] == nil || providers["second"] == nil {
+		t.Errorf("expected the two working providers, got %v", providers)
+	}
+}
+
+func TestNewProviderWrappersRejectsDuplicateNames(t *testing.T) {
+	var configured int32
+	configure := func(ctx context.Context, req *tfprotov5.ConfigureProviderRequest) (*tfprotov5.ConfigureProviderResponse, error) {
+		atomic.AddInt32(&configured, 1)
+		return &tfprotov5.ConfigureProviderResponse{}, nil
+	}
+
+	providers, err := NewProviderWrappers([]ProviderSpec{
+		{Name: "aws", Config: cty.EmptyObjectVal, Server: &fakeProviderServer{configureProvider: configure}},
+		{Name: "google", Config: cty.EmptyObjectVal, Server: &fakeProviderServer{configureProvider: configure}},
+		{Name: "aws", Config: cty.EmptyObjectVal, Server: &fakeProviderServer{configureProvider: configure}},
+	}, false)
+	if err == nil || !strings.Contains(err.Error(), "provider aws is given more than once") {
+		t.Errorf("expected the duplicate name to be rejected, got %v", err)
+	}
+	if len(providers) != 0 {
+		t.Errorf("expected no providers, got %v", providers)
+	}
+	if got := atomic.LoadInt32(&configured); got != 0 {
+		t.Errorf("expected nothing to be started, got %d providers configured", got)
+	}
+}
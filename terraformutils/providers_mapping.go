@@ -4,6 +4,7 @@ import (
 	"log"
 	"math/rand"
 	"reflect"
+	"sort"
 	"time"
 
 	"github.com/GoogleCloudPlatform/terraformer/terraformutils/providerwrapper"
@@ -72,16 +73,51 @@ func (p *ProvidersMapping) RemoveServices(services []string) {
 }
 
 func (p *ProvidersMapping) ShuffleResources() []*Resource {
-	resources := []*Resource{}
+	return p.ShuffleResourcesSeeded(time.Now().UnixNano())
+}
+
+// ShuffleResourcesSeeded shuffles like ShuffleResources but reproducibly: the
+// same seed over the same resources always yields the same order.
+func (p *ProvidersMapping) ShuffleResourcesSeeded(seed int64) []*Resource {
+	resources := make([]*Resource, 0, len(p.Resources))
 	for resource := range p.Resources {
 		resources = append(resources, resource)
 	}
-	rand.Seed(time.Now().UnixNano())
-	rand.Shuffle(len(resources), func(i, j int) { resources[i], resources[j] = resources[j], resources[i] })
+	// map iteration order is random, start from a stable order
+	sort.SliceStable(resources, func(i, j int) bool {
+		return resources[i].InstanceInfo.Id < resources[j].InstanceInfo.Id
+	})
+	r := rand.New(rand.NewSource(seed))
+	r.Shuffle(len(resources), func(i, j int) { resources[i], resources[j] = resources[j], resources[i] })
 
 	return resources
 }
 
+// PartitionResources splits the resources into the regular ones and the slow
+// ones, grouped by the provider generating them, in a single pass over the
+// mapping instead of going through a shuffled copy of every resource.
+func (p *ProvidersMapping) PartitionResources() ([]*Resource, [][]*Resource) {
+	regularResources := make([]*Resource, 0, len(p.Resources))
+	var slowProcessingResources map[ProviderGenerator][]*Resource
+	for resource := range p.Resources {
+		if !resource.SlowQueryRequired {
+			regularResources = append(regularResources, resource)
+			continue
+		}
+		if slowProcessingResources == nil {
+			slowProcessingResources = make(map[ProviderGenerator][]*Resource)
+		}
+		provider := p.resourceToProvider[resource]
+		slowProcessingResources[provider] = append(slowProcessingResources[provider], resource)
+	}
+
+	spResourcesList := make([][]*Resource, 0, len(slowProcessingResources))
+	for provider := range slowProcessingResources {
+		spResourcesList = append(spResourcesList, slowProcessingResources[provider])
+	}
+	return regularResources, spResourcesList
+}
+
 func (p *ProvidersMapping) ProcessResources(isCleanup bool) {
 	initialResources := p.resourceToProvider
 	if isCleanup && len(initialResources) > 0 {
@@ -0,0 +1,100 @@
+package terraformutils
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func newShuffleMapping(count int) *ProvidersMapping {
+	mapping := NewProvidersMapping(nil)
+	for i := 0; i < count; i++ {
+		r := NewSimpleResource(fmt.Sprintf("id-%d", i), fmt.Sprintf("name-%d", i), "fake_resource", "fake", []string{})
+		mapping.Resources[&r] = true
+	}
+	return mapping
+}
+
+func shuffledIDs(resources []*Resource) []string {
+	ids := []string{}
+	for _, r := range resources {
+		ids = append(ids, r.InstanceState.ID)
+	}
+	return ids
+}
+
+func TestShuffleResourcesSeeded(t *testing.T) {
+	first := shuffledIDs(newShuffleMapping(50).ShuffleResourcesSeeded(42))
+	second := shuffledIDs(newShuffleMapping(50).ShuffleResourcesSeeded(42))
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("same seed produced different orders\n%v\n%v", first, second)
+	}
+
+	other := shuffledIDs(newShuffleMapping(50).ShuffleResourcesSeeded(43))
+	if reflect.DeepEqual(first, other) {
+		t.Errorf("different seeds produced the same order %v", first)
+	}
+}
+
+type fakeProviderGenerator struct {
+	ProviderGenerator
+	name string
+}
+
+func newPartitionMapping(count int) *ProvidersMapping {
+	mapping := NewProvidersMapping(nil)
+	slow := []ProviderGenerator{&fakeProviderGenerator{name: "first"}, &fakeProviderGenerator{name: "second"}}
+	for i := 0; i < count; i++ {
+		r := NewSimpleResource(fmt.Sprintf("id-%d", i), fmt.Sprintf("name-%d", i), "fake_resource", "fake", []string{})
+		if i%10 == 0 {
+			r.SlowQueryRequired = true
+			mapping.resourceToProvider[&r] = slow[i%20/10]
+		}
+		mapping.Resources[&r] = true
+	}
+	return mapping
+}
+
+func TestPartitionResources(t *testing.T) {
+	mapping := newPartitionMapping(100)
+	regular, slow := mapping.PartitionResources()
+	wantRegular, wantSlow := partitionResources(mapping, mapping.ShuffleResourcesSeeded(42))
+
+	if len(regular) != len(wantRegular) {
+		t.Errorf("expected %d regular resources, got %d", len(wantRegular), len(regular))
+	}
+	for _, r := range regular {
+		if r.SlowQueryRequired {
+			t.Errorf("slow resource %s among the regular ones", r.InstanceState.ID)
+		}
+	}
+	if len(slow) != len(wantSlow) {
+		t.Fatalf("expected %d slow groups, got %d", len(wantSlow), len(slow))
+	}
+	for _, group := range slow {
+		if len(group) != 5 {
+			t.Errorf("expected 5 resources per slow group, got %d", len(group))
+		}
+		for _, r := range group {
+			if mapping.MatchProvider(r) != mapping.MatchProvider(group[0]) {
+				t.Errorf("resource %s grouped with resources of another provider", r.InstanceState.ID)
+			}
+		}
+	}
+}
+
+func BenchmarkPartitionResources(b *testing.B) {
+	mapping := newPartitionMapping(100000)
+	b.Run("shuffled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			partitionResources(mapping, mapping.ShuffleResourcesSeeded(42))
+		}
+	})
+	b.Run("streamed", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			mapping.PartitionResources()
+		}
+	})
+}
@@ -0,0 +1,158 @@
+// Copyright 2018 The Terraformer Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providerwrapper //nolint
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/go-cty/cty/convert"
+)
+
+// flatmapObjectValue rebuilds a value of the object type ty from flatmapped
+// attributes, for states InstanceState.AttrsAsObjectValue rejects, such as
+// those of old providers storing unset numbers as "" or maps with ".#"
+// counts. Element counts are taken from the keys present rather than from
+// count keys, and values that don't parse as their type are left null, so it
+// never fails.
+func flatmapObjectValue(attrs map[string]string, ty cty.Type) cty.Value {
+	return flatmapObject(attrs, "", ty)
+}
+
+func flatmapObject(attrs map[string]string, prefix string, ty cty.Type) cty.Value {
+	if len(ty.AttributeTypes()) == 0 {
+		return cty.EmptyObjectVal
+	}
+	vals := make(map[string]cty.Value, len(ty.AttributeTypes()))
+	for name, aty := range ty.AttributeTypes() {
+		vals[name] = flatmapValue(attrs, prefix+name, aty)
+	}
+	return cty.ObjectVal(vals)
+}
+
+func flatmapValue(attrs map[string]string, key string, ty cty.Type) cty.Value {
+	switch {
+	case ty.IsPrimitiveType():
+		s, ok := attrs[key]
+		if !ok {
+			return cty.NullVal(ty)
+		}
+		val, err := convert.Convert(cty.StringVal(s), ty)
+		if err != nil {
+			return cty.NullVal(ty)
+		}
+		return val
+	case ty.IsObjectType():
+		if len(flatmapSubKeys(attrs, key)) == 0 {
+			return cty.NullVal(ty)
+		}
+		return flatmapObject(attrs, key+".", ty)
+	case ty.IsListType() || ty.IsSetType():
+		indices, ok := flatmapIndices(attrs, key)
+		if !ok {
+			return cty.NullVal(ty)
+		}
+		ety := ty.ElementType()
+		if len(indices) == 0 {
+			if ty.IsListType() {
+				return cty.ListValEmpty(ety)
+			}
+			return cty.SetValEmpty(ety)
+		}
+		elems := make([]cty.Value, 0, len(indices))
+		for _, idx := range indices {
+			elems = append(elems, flatmapValue(attrs, key+"."+idx, ety))
+		}
+		if ty.IsListType() {
+			return cty.ListVal(elems)
+		}
+		return cty.SetVal(elems)
+	case ty.IsTupleType():
+		if _, ok := flatmapIndices(attrs, key); !ok {
+			return cty.NullVal(ty)
+		}
+		etys := ty.TupleElementTypes()
+		elems := make([]cty.Value, 0, len(etys))
+		for i, ety := range etys {
+			elems = append(elems, flatmapValue(attrs, key+"."+strconv.Itoa(i), ety))
+		}
+		return cty.TupleVal(elems)
+	case ty.IsMapType():
+		subKeys := flatmapSubKeys(attrs, key)
+		ety := ty.ElementType()
+		names := map[string]bool{}
+		for _, subKey := range subKeys {
+			if subKey == "%" || subKey == "#" {
+				continue
+			}
+			if !ety.IsPrimitiveType() {
+				// only primitive map keys may contain dots
+				subKey = strings.SplitN(subKey, ".", 2)[0]
+			}
+			names[subKey] = true
+		}
+		if len(names) == 0 {
+			if len(subKeys) == 0 {
+				return cty.NullVal(ty)
+			}
+			return cty.MapValEmpty(ety)
+		}
+		elems := make(map[string]cty.Value, len(names))
+		for name := range names {
+			elems[name] = flatmapValue(attrs, key+"."+name, ety)
+		}
+		return cty.MapVal(elems)
+	}
+	// dynamic values aren't flatmapped
+	return cty.NullVal(ty)
+}
+
+// flatmapSubKeys returns the keys under key, without the key prefix.
+func flatmapSubKeys(attrs map[string]string, key string) []string {
+	var subKeys []string
+	for k := range attrs {
+		if strings.HasPrefix(k, key+".") {
+			subKeys = append(subKeys, k[len(key)+1:])
+		}
+	}
+	return subKeys
+}
+
+// flatmapIndices returns the element indices present under key in numeric
+// order, and whether the collection is there at all.
+func flatmapIndices(attrs map[string]string, key string) ([]string, bool) {
+	subKeys := flatmapSubKeys(attrs, key)
+	if len(subKeys) == 0 {
+		return nil, false
+	}
+	seen := map[string]bool{}
+	var indices []string
+	for _, subKey := range subKeys {
+		idx := strings.SplitN(subKey, ".", 2)[0]
+		if _, err := strconv.Atoi(idx); err != nil || seen[idx] {
+			continue
+		}
+		seen[idx] = true
+		indices = append(indices, idx)
+	}
+	sort.Slice(indices, func(i, j int) bool {
+		a, _ := strconv.Atoi(indices[i])
+		b, _ := strconv.Atoi(indices[j])
+		return a < b
+	})
+	return indices, true
+}
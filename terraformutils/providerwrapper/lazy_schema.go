@@ -0,0 +1,64 @@
+// Copyright 2018 The Terraformer Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providerwrapper //nolint
+
+import (
+	"fmt"
+
+	"github.com/GoogleCloudPlatform/terraformer/terraformutils/tfplugin/stoleninternal/configschema"
+)
+
+// GetResourceSchemaLazy returns the wrapped schema of resourceType, caching
+// it per type so that imports of a few types of a large provider only parse
+// and wrap those. The protocol has no request for the schema of a single
+// type, so when the schema isn't cached on disk, see WithSchemaCacheDir, the
+// whole schema is fetched once as GetSchema does; otherwise only the
+// requested type is parsed from the cache.
+func (p *ProviderWrapper) GetResourceSchemaLazy(resourceType string) (*configschema.Block, error) {
+	p.lazySchemaMutex.Lock()
+	defer p.lazySchemaMutex.Unlock()
+	if block, ok := p.lazySchemas[resourceType]; ok {
+		return block, nil
+	}
+	block, err := p.loadResourceSchema(resourceType)
+	if err != nil {
+		return nil, err
+	}
+	if p.lazySchemas == nil {
+		p.lazySchemas = map[string]*configschema.Block{}
+	}
+	p.lazySchemas[resourceType] = block
+	return block, nil
+}
+
+func (p *ProviderWrapper) loadResourceSchema(resourceType string) (*configschema.Block, error) {
+	p.schemaMutex.Lock()
+	loaded := p.schema != nil
+	p.schemaMutex.Unlock()
+	if !loaded && p.schemaCacheDir != "" && p.providerFilePath != "" {
+		if cached, ok := loadCachedResourceSchema(p.schemaCacheDir, p.providerName, p.providerFilePath, resourceType); ok {
+			return configschema.WrapBlock(p.overrideResourceTypes(resourceType, cached).Block), nil
+		}
+	}
+	r, err := p.GetSchema()
+	if err != nil {
+		return nil, err
+	}
+	resourceSchema, ok := r.ResourceSchemas[resourceType]
+	if !ok {
+		return nil, fmt.Errorf("unknown resource type %q for provider %q", resourceType, p.providerName)
+	}
+	return configschema.WrapBlock(resourceSchema.Block), nil
+}
@@ -0,0 +1,68 @@
+package providerwrapper //nolint
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-go/tfprotov5"
+)
+
+func TestGetResourceSchemaLazy(t *testing.T) {
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "terraform-provider-fake_v1.0.0")
+	writeFakeBinary(t, binary)
+
+	calls := 0
+	f := &fakeProvider{getProviderSchema: func() (*tfprotov5.GetProviderSchemaResponse, error) {
+		calls++
+		return fakeSchema(map[string]*tfprotov5.Schema{
+			"fake_network": fakeResourceSchema("cidr"),
+			"fake_subnet":  fakeResourceSchema("network"),
+			"fake_address": fakeResourceSchema("ip"),
+		}), nil
+	}}
+	newWrapper := func() *ProviderWrapper {
+		p := newFakeProviderWrapper(f)
+		WithSchemaCacheDir(filepath.Join(dir, "cache"))(p)
+		p.providerFilePath = binary
+		return p
+	}
+
+	// without a cached schema, the whole schema is fetched once
+	p := newWrapper()
+	block, err := p.GetResourceSchemaLazy("fake_network")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(block.Attributes) != 2 || block.Attributes[1].Name != "cidr" {
+		t.Errorf("expected the fake_network schema, got %v", block.Attributes)
+	}
+	if _, err := p.GetResourceSchemaLazy("fake_subnet"); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Errorf("expected the schema to be fetched once, provider asked %d times", calls)
+	}
+	if _, err := p.GetResourceSchemaLazy("fake_unknown"); err == nil {
+		t.Error("expected an unknown resource type to be rejected")
+	}
+
+	// with a cached schema, only the requested type is parsed and wrapped
+	p = newWrapper()
+	block, err = p.GetResourceSchemaLazy("fake_address")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(block.Attributes) != 2 || block.Attributes[1].Name != "ip" {
+		t.Errorf("expected the cached fake_address schema, got %v", block.Attributes)
+	}
+	if again, _ := p.GetResourceSchemaLazy("fake_address"); again != block {
+		t.Error("expected the wrapped schema to be cached")
+	}
+	if calls != 1 || p.schema != nil {
+		t.Errorf("expected the full schema to be left alone, provider asked %d times", calls)
+	}
+	if _, ok := p.lazySchemas["fake_address"]; !ok || len(p.lazySchemas) != 1 {
+		t.Errorf("expected only fake_address to be wrapped, got %v", p.lazySchemas)
+	}
+}
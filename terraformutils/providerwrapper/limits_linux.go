@@ -0,0 +1,32 @@
+// Copyright 2018 The Terraformer Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package providerwrapper //nolint
+
+import (
+	"os/exec"
+	"strconv"
+)
+
+// limitCommand wraps cmd in a shell that caps the address space of the
+// provider at memoryLimit bytes with setrlimit(RLIMIT_AS) before exec'ing it,
+// since Go can't run code in the child between fork and exec.
+func limitCommand(cmd *exec.Cmd, memoryLimit int64, logger Logger) *exec.Cmd {
+	limitKiB := (memoryLimit + 1023) / 1024
+	limited := exec.Command("/bin/sh", "-c", `ulimit -v "$1" && exec "$0"`, cmd.Path, strconv.FormatInt(limitKiB, 10))
+	limited.Env = cmd.Env
+	return limited
+}
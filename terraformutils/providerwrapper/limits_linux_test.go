@@ -0,0 +1,30 @@
+//go:build linux
+
+package providerwrapper //nolint
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLimitCommandSetsMemoryLimit(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "terraform-provider-fake")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\nulimit -v\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := limitCommand(exec.Command(script), 512*1024*1024, stdLogger{})
+	if !strings.Contains(strings.Join(cmd.Args, " "), "ulimit -v") {
+		t.Errorf("expected the command to set a limit, got %v", cmd.Args)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(out)); got != "524288" {
+		t.Errorf("expected the provider to run limited to 524288 KiB, got %q", got)
+	}
+}
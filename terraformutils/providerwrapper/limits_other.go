@@ -0,0 +1,27 @@
+// Copyright 2018 The Terraformer Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+
+package providerwrapper //nolint
+
+import (
+	"os/exec"
+)
+
+// limitCommand is a no-op outside Linux, where resource limits aren't supported.
+func limitCommand(cmd *exec.Cmd, memoryLimit int64, logger Logger) *exec.Cmd {
+	logger.Warn("provider memory limits are only supported on Linux")
+	return cmd
+}
@@ -0,0 +1,56 @@
+// Copyright 2018 The Terraformer Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providerwrapper //nolint
+
+import (
+	"context"
+	"fmt"
+)
+
+// maxProviderRelaunches bounds how many times EnsureAlive relaunches a dead
+// provider over the life of a wrapper, so a provider that keeps crashing
+// fails the import instead of being relaunched forever.
+const maxProviderRelaunches = 3
+
+// EnsureAlive checks that the provider plugin process still answers and
+// relaunches and reconfigures it when it doesn't, e.g. after it was killed
+// for running out of memory during a long import. It waits for the refreshes,
+// plans and imports in flight to finish first, and holds off new ones until
+// the plugin is back. Refreshes failing on a dead plugin call it themselves.
+// Providers served in-process are always alive.
+func (p *ProviderWrapper) EnsureAlive(ctx context.Context) error {
+	p.liveness.Lock()
+	defer p.liveness.Unlock()
+	if p.alive() {
+		return nil
+	}
+	if p.relaunches >= maxProviderRelaunches {
+		return p.withPluginStderr(fmt.Errorf("provider %s died and was already relaunched %d times", p.providerName, p.relaunches))
+	}
+	p.relaunches++
+	p.log().Warn("provider %s is not running anymore, relaunching it (%d/%d)", p.providerName, p.relaunches, maxProviderRelaunches)
+	if err := p.Close(); err != nil {
+		p.log().Warn("%v", err)
+	}
+	if err := p.initProvider(ctx, p.verbose); err != nil {
+		return fmt.Errorf("relaunching provider %s: %w", p.providerName, err)
+	}
+	return nil
+}
+
+// alive tells whether the provider plugin process still answers.
+func (p *ProviderWrapper) alive() bool {
+	return p.client == nil || !p.client.Exited() && p.rpcClient != nil && p.rpcClient.Ping() == nil
+}
@@ -0,0 +1,61 @@
+// Copyright 2018 The Terraformer Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providerwrapper //nolint
+
+import "log"
+
+// Logger receives the messages of a ProviderWrapper, so library consumers can
+// route and level them, e.g. through a zap or logrus adapter. Messages are
+// formatted like fmt.Printf.
+type Logger interface {
+	Debug(format string, args ...interface{})
+	Info(format string, args ...interface{})
+	Warn(format string, args ...interface{})
+	Error(format string, args ...interface{})
+}
+
+// stdLogger writes to the standard logger, the default.
+type stdLogger struct{}
+
+func (stdLogger) Debug(format string, args ...interface{}) {
+	log.Printf("DEBUG: "+format, args...)
+}
+
+func (stdLogger) Info(format string, args ...interface{}) {
+	log.Printf(format, args...)
+}
+
+func (stdLogger) Warn(format string, args ...interface{}) {
+	log.Printf("WARN: "+format, args...)
+}
+
+func (stdLogger) Error(format string, args ...interface{}) {
+	log.Printf("ERROR: "+format, args...)
+}
+
+// WithLogger sends the wrapper's messages to logger instead of the standard
+// logger.
+func WithLogger(logger Logger) ProviderOption {
+	return func(p *ProviderWrapper) {
+		p.logger = logger
+	}
+}
+
+func (p *ProviderWrapper) log() Logger {
+	if p.logger == nil {
+		return stdLogger{}
+	}
+	return p.logger
+}
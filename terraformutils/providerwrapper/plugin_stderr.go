@@ -0,0 +1,79 @@
+// Copyright 2018 The Terraformer Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providerwrapper //nolint
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// stderrTailLines is how many lines of plugin stderr startup errors include.
+const stderrTailLines = 20
+
+// lineTail keeps the last lines written to it, to explain why a plugin
+// process failed to start. It is written to by the plugin client while the
+// process runs.
+type lineTail struct {
+	mutex   sync.Mutex
+	max     int
+	lines   []string
+	partial string
+}
+
+func newLineTail(max int) *lineTail {
+	return &lineTail{max: max}
+}
+
+func (t *lineTail) Write(p []byte) (int, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	lines := strings.Split(t.partial+string(p), "\n")
+	t.partial = lines[len(lines)-1]
+	for _, line := range lines[:len(lines)-1] {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		t.lines = append(t.lines, line)
+		if len(t.lines) > t.max {
+			t.lines = t.lines[1:]
+		}
+	}
+	return len(p), nil
+}
+
+// String returns the kept lines, including an unterminated last one.
+func (t *lineTail) String() string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	lines := t.lines
+	if strings.TrimSpace(t.partial) != "" {
+		lines = append(lines[:len(lines):len(lines)], t.partial)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// withPluginStderr adds what the plugin process wrote to stderr to err,
+// which usually tells why the process couldn't start.
+func (p *ProviderWrapper) withPluginStderr(err error) error {
+	if p.stderr == nil {
+		return err
+	}
+	tail := p.stderr.String()
+	if tail == "" {
+		return err
+	}
+	return fmt.Errorf("%w, provider %s stderr:\n%s", err, p.providerName, tail)
+}
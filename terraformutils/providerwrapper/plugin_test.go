@@ -0,0 +1,545 @@
+package providerwrapper //nolint
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/terraformer/terraformutils/tfplugin"
+	"github.com/GoogleCloudPlatform/terraformer/terraformutils/tfplugin/stoleninternal/fromproto"
+	"github.com/GoogleCloudPlatform/terraformer/terraformutils/tfplugin/stoleninternal/tfplugin5"
+	"github.com/GoogleCloudPlatform/terraformer/terraformutils/tfplugin/stoleninternal/toproto"
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/go-plugin"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov5"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"google.golang.org/grpc"
+)
+
+// The test binary doubles as a fake provider plugin: when fakePluginEnv is
+// set, TestMain serves a fake provider over the plugin protocol instead of
+// running the tests, and appends the RPCs it receives to the file named by
+// fakePluginRecordEnv. fakePluginSchemaDelayEnv slows down its schema
+// responses and fakePluginReadDelayEnv its reads, fakePluginWarningEnv makes
+// it warn with the variable's value when configured, fakePluginCrashEnv makes
+// it exit at startup after writing the variable's value to stderr, and
+// fakePluginCookieEnv makes it expect fakePluginHandshake with that magic
+// cookie instead of terraform's.
+const (
+	fakePluginEnv            = "TERRAFORMER_FAKE_PLUGIN"
+	fakePluginRecordEnv      = "TERRAFORMER_FAKE_PLUGIN_RECORD"
+	fakePluginSchemaDelayEnv = "TERRAFORMER_FAKE_PLUGIN_SCHEMA_DELAY"
+	fakePluginReadDelayEnv   = "TERRAFORMER_FAKE_PLUGIN_READ_DELAY"
+	fakePluginWarningEnv     = "TERRAFORMER_FAKE_PLUGIN_WARNING"
+	fakePluginCrashEnv       = "TERRAFORMER_FAKE_PLUGIN_CRASH"
+	fakePluginCookieEnv      = "TERRAFORMER_FAKE_PLUGIN_COOKIE"
+)
+
+func fakePluginHandshake(cookie string) plugin.HandshakeConfig {
+	return plugin.HandshakeConfig{
+		ProtocolVersion:  tfplugin.Handshake.ProtocolVersion,
+		MagicCookieKey:   "TERRAFORMER_FAKE_MAGIC_COOKIE",
+		MagicCookieValue: cookie,
+	}
+}
+
+func TestMain(m *testing.M) {
+	if os.Getenv(fakePluginEnv) != "" {
+		if msg := os.Getenv(fakePluginCrashEnv); msg != "" {
+			fmt.Fprintln(os.Stderr, msg)
+			os.Exit(1)
+		}
+		serveFakePlugin()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func serveFakePlugin() {
+	provider := echoProvider(map[string]*tfprotov5.Schema{
+		"fake_resource": fakeResourceSchema("name"),
+	})
+	provider.schema.ServerCapabilities = &tfprotov5.ServerCapabilities{PlanDestroy: true}
+	if warning := os.Getenv(fakePluginWarningEnv); warning != "" {
+		provider.configureProvider = func(req *tfprotov5.ConfigureProviderRequest) (*tfprotov5.ConfigureProviderResponse, error) {
+			return &tfprotov5.ConfigureProviderResponse{Diagnostics: []*tfprotov5.Diagnostic{
+				{Severity: tfprotov5.DiagnosticSeverityWarning, Summary: warning},
+			}}, nil
+		}
+	}
+	handshake := tfplugin.Handshake
+	if cookie := os.Getenv(fakePluginCookieEnv); cookie != "" {
+		handshake = fakePluginHandshake(cookie)
+	}
+	plugin.Serve(&plugin.ServeConfig{
+		HandshakeConfig: handshake,
+		VersionedPlugins: map[int]plugin.PluginSet{
+			5: {tfplugin.ProviderPluginName: &fakeGRPCPlugin{provider: provider}},
+		},
+		GRPCServer: plugin.DefaultGRPCServer,
+	})
+}
+
+// recordCall appends the name of a received RPC to the record file.
+func recordCall(name string) {
+	path := os.Getenv(fakePluginRecordEnv)
+	if path == "" {
+		return
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	_, _ = f.WriteString(name + "\n")
+}
+
+// installFakePlugin makes the test binary discoverable as the "fake"
+// provider plugin and returns the path of the file its RPCs are recorded to.
+func installFakePlugin(t *testing.T) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake plugin relies on symlinks")
+	}
+	dir := t.TempDir()
+	pluginDir := filepath.Join(dir, "plugins", runtime.GOOS+"_"+runtime.GOARCH)
+	if err := os.MkdirAll(pluginDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(executable, filepath.Join(pluginDir, "terraform-provider-fake_v1.0.0")); err != nil {
+		t.Fatal(err)
+	}
+	record := filepath.Join(dir, "record")
+	t.Setenv("TF_DATA_DIR", dir)
+	t.Setenv("HOME", dir)
+	t.Setenv("TF_REATTACH_PROVIDERS", "")
+	t.Setenv(fakePluginEnv, "1")
+	t.Setenv(fakePluginRecordEnv, record)
+	return record
+}
+
+type fakeGRPCPlugin struct {
+	plugin.Plugin
+	provider tfprotov5.ProviderServer
+}
+
+func (p *fakeGRPCPlugin) GRPCServer(broker *plugin.GRPCBroker, s *grpc.Server) error {
+	tfplugin5.RegisterProviderServer(s, &fakeGRPCServer{provider: p.provider})
+	return nil
+}
+
+func (p *fakeGRPCPlugin) GRPCClient(ctx context.Context, broker *plugin.GRPCBroker, c *grpc.ClientConn) (interface{}, error) {
+	return nil, nil
+}
+
+// fakeGRPCServer exposes a tfprotov5.ProviderServer over gRPC, the reverse
+// of the client in the tfplugin package.
+type fakeGRPCServer struct {
+	tfplugin5.UnimplementedProviderServer
+	provider tfprotov5.ProviderServer
+}
+
+func (s *fakeGRPCServer) GetSchema(ctx context.Context, req *tfplugin5.GetProviderSchema_Request) (*tfplugin5.GetProviderSchema_Response, error) {
+	recordCall("schema")
+	if delay, err := time.ParseDuration(os.Getenv(fakePluginSchemaDelayEnv)); err == nil {
+		time.Sleep(delay)
+	}
+	resp, err := s.provider.GetProviderSchema(ctx, &tfprotov5.GetProviderSchemaRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return toproto.GetProviderSchema_Response(resp)
+}
+
+func (s *fakeGRPCServer) Configure(ctx context.Context, req *tfplugin5.Configure_Request) (*tfplugin5.Configure_Response, error) {
+	recordCall("configure")
+	r, err := fromproto.ConfigureProviderRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.provider.ConfigureProvider(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	return toproto.Configure_Response(resp)
+}
+
+func (s *fakeGRPCServer) Stop(ctx context.Context, req *tfplugin5.Stop_Request) (*tfplugin5.Stop_Response, error) {
+	recordCall("stop")
+	return &tfplugin5.Stop_Response{}, nil
+}
+
+func (s *fakeGRPCServer) ReadResource(ctx context.Context, req *tfplugin5.ReadResource_Request) (*tfplugin5.ReadResource_Response, error) {
+	recordCall("read")
+	if delay, err := time.ParseDuration(os.Getenv(fakePluginReadDelayEnv)); err == nil {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			recordCall("read-cancelled")
+			return nil, ctx.Err()
+		}
+	}
+	r, err := fromproto.ReadResourceRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.provider.ReadResource(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	return toproto.ReadResource_Response(resp)
+}
+
+func TestKillStopsProviderBeforeExit(t *testing.T) {
+	record := installFakePlugin(t)
+	p, err := NewProviderWrapper("fake", cty.EmptyObjectVal, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Kill()
+	if !p.client.Exited() {
+		t.Error("expected plugin process to have exited")
+	}
+	calls, err := os.ReadFile(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Fields(string(calls)); len(got) == 0 || got[len(got)-1] != "stop" {
+		t.Errorf("expected stop to be the last call, got %v", got)
+	}
+}
+
+func TestNewProviderWrapperContextCancelsSlowStartup(t *testing.T) {
+	installFakePlugin(t)
+	t.Setenv(fakePluginSchemaDelayEnv, "1m")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	p, err := NewProviderWrapperContext(ctx, "fake", cty.EmptyObjectVal, false)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the startup to time out, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 30*time.Second {
+		t.Errorf("expected startup to be abandoned quickly, took %s", elapsed)
+	}
+	if !p.client.Exited() {
+		t.Error("expected the plugin process to be killed")
+	}
+}
+
+func TestRefreshWithContextCancelsSlowRead(t *testing.T) {
+	record := installFakePlugin(t)
+	t.Setenv(fakePluginReadDelayEnv, "1m")
+	p, err := NewProviderWrapperWithOptions("fake", cty.EmptyObjectVal, false, WithRetries(3, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Kill()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = p.RefreshWithContext(ctx, &terraform.InstanceInfo{Type: "fake_resource", Id: "fake_resource.r"}, &terraform.InstanceState{ID: "r-1"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the read to time out, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 30*time.Second {
+		t.Errorf("expected the read to be abandoned quickly, took %s", elapsed)
+	}
+	// the plugin notices the cancellation asynchronously
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		calls, err := os.ReadFile(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := strings.Fields(string(calls))
+		if reads := strings.Count(string(calls), "read\n"); reads != 1 {
+			t.Fatalf("expected a single read without retries, got %v", got)
+		}
+		if got[len(got)-1] == "read-cancelled" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the cancellation to reach the plugin")
+		}
+	}
+}
+
+func TestStartupErrorIncludesPluginStderr(t *testing.T) {
+	installFakePlugin(t)
+	t.Setenv(fakePluginCrashEnv, "error while loading shared libraries: libfake.so")
+
+	p, err := NewProviderWrapper("fake", cty.EmptyObjectVal, false)
+	if err == nil {
+		p.Kill()
+		t.Fatal("expected the provider startup to fail")
+	}
+	if !strings.Contains(err.Error(), "libfake.so") {
+		t.Errorf("expected the plugin stderr in the error, got %v", err)
+	}
+}
+
+func TestProviderEnvReachesPlugin(t *testing.T) {
+	installFakePlugin(t)
+
+	p, err := NewProviderWrapperWithOptions("fake", cty.EmptyObjectVal, false, WithProviderEnv(map[string]string{
+		fakePluginCrashEnv: "crashing as asked by the provider env",
+	}))
+	if err == nil {
+		p.Kill()
+		t.Fatal("expected the plugin to see the crash variable")
+	}
+	if !strings.Contains(err.Error(), "crashing as asked by the provider env") {
+		t.Errorf("expected the plugin stderr in the error, got %v", err)
+	}
+}
+
+func TestProviderEnvOverridesInheritedEnv(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("inherited variables win on windows")
+	}
+	installFakePlugin(t)
+	t.Setenv(fakePluginCrashEnv, "crashing with the inherited value")
+
+	p, err := NewProviderWrapperWithOptions("fake", cty.EmptyObjectVal, false, WithProviderEnv(map[string]string{
+		fakePluginCrashEnv: "crashing with the provider env value",
+	}))
+	if err == nil {
+		p.Kill()
+		t.Fatal("expected the plugin to see the crash variable")
+	}
+	if !strings.Contains(err.Error(), "crashing with the provider env value") {
+		t.Errorf("expected the provider env to override the inherited variable, got %v", err)
+	}
+}
+
+func TestNewProviderWrapperFromPath(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake plugin relies on symlinks")
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary := filepath.Join(t.TempDir(), "build", "terraform-provider-fake_v2.3.4")
+	if err := os.MkdirAll(filepath.Dir(binary), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(executable, binary); err != nil {
+		t.Fatal(err)
+	}
+	// nothing to be found where providers are searched for
+	empty := t.TempDir()
+	t.Setenv("TF_DATA_DIR", empty)
+	t.Setenv("HOME", empty)
+	t.Setenv(ProviderMirrorEnv, "")
+	t.Setenv("TF_REATTACH_PROVIDERS", "")
+	t.Setenv(fakePluginEnv, "1")
+
+	p, err := NewProviderWrapperFromPath("fake", binary, cty.EmptyObjectVal, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Kill()
+	if p.providerFilePath != binary {
+		t.Errorf("expected %s to be launched, got %s", binary, p.providerFilePath)
+	}
+	if got := p.GetProviderVersion(); got != "~> 2.3.4" {
+		t.Errorf("expected the version from the file name, got %q", got)
+	}
+	if _, err := p.GetSchema(); err != nil {
+		t.Errorf("expected the launched provider to serve its schema, got %v", err)
+	}
+
+	if _, err := NewProviderWrapperFromPath("fake", filepath.Join(empty, "missing"), cty.EmptyObjectVal, false); err == nil {
+		t.Error("expected a missing binary to be rejected")
+	}
+}
+
+func TestEnsureAliveRelaunchesKilledPlugin(t *testing.T) {
+	record := installFakePlugin(t)
+	p, err := NewProviderWrapper("fake", cty.EmptyObjectVal, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Kill()
+	if err := p.EnsureAlive(context.Background()); err != nil || p.relaunches != 0 {
+		t.Fatalf("expected a running plugin to be left alone, got %v after %d relaunches", err, p.relaunches)
+	}
+
+	for i := 1; i <= maxProviderRelaunches; i++ {
+		crashPlugin(t, p)
+		if err := p.EnsureAlive(context.Background()); err != nil {
+			t.Fatalf("expected the plugin to be relaunched, got %v", err)
+		}
+		if p.relaunches != i {
+			t.Errorf("expected %d relaunches, got %d", i, p.relaunches)
+		}
+		if _, err := p.Refresh(&terraform.InstanceInfo{Type: "fake_resource", Id: "fake_resource.r"}, &terraform.InstanceState{ID: "r-1"}); err != nil {
+			t.Errorf("expected the relaunched plugin to serve reads, got %v", err)
+		}
+	}
+	calls, err := os.ReadFile(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(string(calls), "configure"); got != maxProviderRelaunches+1 {
+		t.Errorf("expected the plugin to be configured after each relaunch, got %d configures", got)
+	}
+
+	crashPlugin(t, p)
+	if err := p.EnsureAlive(context.Background()); err == nil {
+		t.Error("expected relaunches to stop after the limit")
+	}
+}
+
+func TestRefreshRelaunchesPluginKilledMidImport(t *testing.T) {
+	installFakePlugin(t)
+	p, err := NewProviderWrapperWithOptions("fake", cty.EmptyObjectVal, false, WithRetries(1, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Kill()
+	refresher, err := p.NewTypeRefresher("fake_resource")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crashPlugin(t, p)
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			info := &terraform.InstanceInfo{Type: "fake_resource", Id: fmt.Sprintf("fake_resource.r%d", i)}
+			_, errs[i] = refresher.Refresh(context.Background(), info, &terraform.InstanceState{ID: fmt.Sprintf("r-%d", i)}, "")
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("expected refresh %d to recover from the dead plugin, got %v", i, err)
+		}
+	}
+	if p.relaunches != 1 {
+		t.Errorf("expected the concurrent refreshes to relaunch the plugin once, got %d relaunches", p.relaunches)
+	}
+}
+
+func TestEnsureAliveWaitsForImportsInFlight(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	p := newFakeProviderWrapper(&fakeProvider{
+		schema: fakeSchema(map[string]*tfprotov5.Schema{"fake_resource": fakeResourceSchema("name")}),
+		importResourceState: func(req *tfprotov5.ImportResourceStateRequest) (*tfprotov5.ImportResourceStateResponse, error) {
+			close(started)
+			<-release
+			return &tfprotov5.ImportResourceStateResponse{ImportedResources: []*tfprotov5.ImportedResource{{
+				TypeName: "fake_resource",
+				State:    NewDynamicValue(cty.ObjectVal(map[string]cty.Value{"id": cty.StringVal(req.ID), "name": cty.NullVal(cty.String)})),
+			}}}, nil
+		},
+	})
+
+	imported := make(chan error, 1)
+	go func() {
+		_, err := p.ImportResources(&terraform.InstanceInfo{Type: "fake_resource", Id: "fake_resource.r"}, "r-1")
+		imported <- err
+	}()
+	<-started
+	alive := make(chan error, 1)
+	go func() {
+		alive <- p.EnsureAlive(context.Background())
+	}()
+	select {
+	case <-alive:
+		t.Fatal("expected EnsureAlive to wait for the import in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if err := <-imported; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-alive; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// crashPlugin kills the plugin process behind the client's back, as the OOM
+// killer would.
+func crashPlugin(t *testing.T, p *ProviderWrapper) {
+	t.Helper()
+	process, err := os.FindProcess(p.client.ReattachConfig().Pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := process.Kill(); err != nil {
+		t.Fatal(err)
+	}
+	// the plugin client reaps the process
+	for deadline := time.Now().Add(10 * time.Second); !p.client.Exited(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("plugin process didn't exit")
+		}
+	}
+}
+
+func TestHandshakeWithCustomCookie(t *testing.T) {
+	installFakePlugin(t)
+	t.Setenv(fakePluginCookieEnv, "mock-cookie")
+
+	if p, err := NewProviderWrapper("fake", cty.EmptyObjectVal, false); err == nil {
+		p.Kill()
+		t.Fatal("expected the plugin to reject the terraform cookie")
+	}
+
+	p, err := NewProviderWrapperWithOptions("fake", cty.EmptyObjectVal, false, WithHandshake(fakePluginHandshake("mock-cookie")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Kill()
+	if _, err := p.GetSchema(); err != nil {
+		t.Errorf("expected the plugin to serve its schema, got %v", err)
+	}
+}
+
+func TestPluginWarningsGoToLogger(t *testing.T) {
+	installFakePlugin(t)
+	t.Setenv(fakePluginWarningEnv, "region is deprecated")
+	logger := &capturingLogger{}
+
+	p, err := NewProviderWrapperWithOptions("fake", cty.EmptyObjectVal, false, WithWarningLogging(), WithLogger(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Kill()
+	if want := []string{"warn warning region is deprecated on : "}; !reflect.DeepEqual(logger.messages, want) {
+		t.Errorf("expected messages %q, got %q", want, logger.messages)
+	}
+}
+
+func TestServerCapabilitiesFromPlugin(t *testing.T) {
+	installFakePlugin(t)
+	p, err := NewProviderWrapper("fake", cty.EmptyObjectVal, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Kill()
+	if !p.ServerCapabilities().PlanDestroy {
+		t.Error("expected the PlanDestroy capability of the plugin")
+	}
+}
@@ -24,8 +24,12 @@ import (
 	"net"
 	"os"
 	"os/exec"
+	"regexp"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/GoogleCloudPlatform/terraformer/terraformutils/terraformerstring"
@@ -52,54 +56,535 @@ const DefaultPluginVendorDirV12 = "terraform.d/plugins/" + pluginMachineName
 // pluginMachineName is the directory name used in new plugin paths.
 const pluginMachineName = runtime.GOOS + "_" + runtime.GOARCH
 
+// ProviderMirrorEnv names the environment variable pointing at a provider
+// mirror directory, such as one written by terraform providers mirror. It is
+// searched after the data directory and before the home directory.
+const ProviderMirrorEnv = "TERRAFORMER_PROVIDER_MIRROR"
+
+// stopProviderTimeout bounds how long Kill waits for the provider to stop.
+const stopProviderTimeout = 5 * time.Second
+
 type ProviderWrapper struct {
-	context      context.Context
-	provider     tfprotov5.ProviderServer
-	client       *plugin.Client
-	rpcClient    plugin.ClientProtocol
-	providerName string
-	config       cty.Value
-	schema       *tfprotov5.GetProviderSchemaResponse
-	retryCount   int
-	retrySleepMs int
+	context           context.Context
+	provider          tfprotov5.ProviderServer
+	client            *plugin.Client
+	rpcClient         plugin.ClientProtocol
+	providerName      string
+	config            cty.Value
+	schema            *tfprotov5.GetProviderSchemaResponse
+	schemaMutex       sync.Mutex
+	retryCount        int
+	retrySleepMs      int
+	defaultAttributes map[string]map[string]cty.Value
+	attributeRenames  map[string]map[string]string
+	logWarnings       bool
+	maxAttributeDepth int
+	memoryLimit       int64
+	schemaCacheDir    string
+	providerFilePath  string
+	idAttributes      map[string]string
+	omittedDefaults   map[string]map[string]cty.Value
+	logger            Logger
+	strictConfig      bool
+	configuredValue   cty.Value
+	configureDiags    []*tfprotov5.Diagnostic
+	extractedDir      string
+	retryClassifier   RetryClassifier
+	providerMeta      cty.Value
+	stderr            *lineTail
+	readOnlyTrace     func(ReadOnlyTraceEntry)
+	idNormalizer      func(resourceType, id string) string
+	env               []string
+	binaryPath        string
+	resourceRetries   map[string]RetryConfig
+	excludePaths      []*regexp.Regexp
+	optionErr         error
+	verbose           bool
+	relaunches        int
+	liveness          sync.RWMutex
+	handshake         *plugin.HandshakeConfig
+	retryBudget       *int64
+	lazySchemas       map[string]*configschema.Block
+	lazySchemaMutex   sync.Mutex
+	warningsAsErrors  bool
+	idSources         map[string][]string
+	sharedSchema      bool
+	schemaOnly        bool
+	typeOverrides     map[string]map[string]cty.Type
 }
 
-func NewProviderWrapper(providerName string, providerConfig cty.Value, verbose bool, options ...map[string]int) (*ProviderWrapper, error) {
-	p := &ProviderWrapper{retryCount: 5, retrySleepMs: 300}
-	p.providerName = providerName
-	p.config = providerConfig
+// ProviderOption customizes a ProviderWrapper before the provider starts.
+type ProviderOption func(*ProviderWrapper)
+
+// WithRetries overrides how many times a failed read is retried and how long
+// to wait between attempts.
+func WithRetries(retryCount, retrySleepMs int) ProviderOption {
+	return func(p *ProviderWrapper) {
+		p.retryCount = retryCount
+		p.retrySleepMs = retrySleepMs
+	}
+}
+
+// WithExcludePaths drops the attributes whose flatmapped name matches one of
+// the regular expressions in patterns from refreshed states, in the style of
+// GetReadOnlyAttributes, e.g. ^tags_all$ or ^timeouts($|\.). Matching elements
+// of nested blocks, e.g. ^rule\.[0-9]+$, are removed from their block. An
+// invalid pattern fails creating the wrapper.
+func WithExcludePaths(patterns []string) ProviderOption {
+	return func(p *ProviderWrapper) {
+		for _, pattern := range patterns {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				if p.optionErr == nil {
+					p.optionErr = fmt.Errorf("invalid exclude path %q: %w", pattern, err)
+				}
+				continue
+			}
+			p.excludePaths = append(p.excludePaths, re)
+		}
+	}
+}
+
+// WithHandshake replaces the terraform handshake with the plugin, e.g. to
+// launch mock plugins expecting a magic cookie of their own.
+func WithHandshake(handshake plugin.HandshakeConfig) ProviderOption {
+	return func(p *ProviderWrapper) {
+		p.handshake = &handshake
+	}
+}
+
+// WithDefaultAttributes injects values for top-level attributes of
+// resourceType that come back null from a read, e.g. write-only inputs such
+// as passwords that are required in configuration but never returned.
+func WithDefaultAttributes(resourceType string, defaults map[string]cty.Value) ProviderOption {
+	return func(p *ProviderWrapper) {
+		if p.defaultAttributes == nil {
+			p.defaultAttributes = map[string]map[string]cty.Value{}
+		}
+		p.defaultAttributes[resourceType] = defaults
+	}
+}
+
+// WithAttributeRenames renames top-level attributes of resourceType in
+// refreshed values, mapping provider attribute names to the names downstream
+// tooling expects. Renames are applied after default attributes are injected.
+func WithAttributeRenames(resourceType string, renames map[string]string) ProviderOption {
+	return func(p *ProviderWrapper) {
+		if p.attributeRenames == nil {
+			p.attributeRenames = map[string]map[string]string{}
+		}
+		p.attributeRenames[resourceType] = renames
+	}
+}
 
+// WithMaxAttributeDepth drops nested structures of refreshed values that sit
+// deeper than maxDepth path steps, with a warning. Off when maxDepth is 0.
+func WithMaxAttributeDepth(maxDepth int) ProviderOption {
+	return func(p *ProviderWrapper) {
+		p.maxAttributeDepth = maxDepth
+	}
+}
+
+// WithProviderMemoryLimit caps the memory a launched provider process may
+// allocate to limit bytes, so a runaway provider fails instead of exhausting
+// the host. Only supported on Linux, ignored with a warning elsewhere.
+func WithProviderMemoryLimit(limit int64) ProviderOption {
+	return func(p *ProviderWrapper) {
+		p.memoryLimit = limit
+	}
+}
+
+// WithSchemaCacheDir caches the provider schema in dir across runs, keyed by
+// provider name and version. Entries are invalidated when the provider binary
+// changes.
+func WithSchemaCacheDir(dir string) ProviderOption {
+	return func(p *ProviderWrapper) {
+		p.schemaCacheDir = dir
+	}
+}
+
+// WithSchemaOnly launches the provider without configuring it, for tools that
+// only inspect its schema, e.g. with GetSchema or GetReadOnlyAttributes, and
+// have no credentials for it. Refreshing, planning or importing resources
+// with such a provider fails.
+func WithSchemaOnly() ProviderOption {
+	return func(p *ProviderWrapper) {
+		p.schemaOnly = true
+	}
+}
+
+// WithSharedSchemaCache shares the provider schema with the other wrappers of
+// the same provider name and version created with this option, so importing
+// with several wrappers of one provider keeps a single copy of its schema in
+// memory. Providers not launched from a binary don't share their schema.
+func WithSharedSchemaCache() ProviderOption {
+	return func(p *ProviderWrapper) {
+		p.sharedSchema = true
+	}
+}
+
+// WithIDAttribute names the attribute that identifies resources of
+// resourceType, for providers whose primary identifier isn't "id".
+func WithIDAttribute(resourceType, attribute string) ProviderOption {
+	return func(p *ProviderWrapper) {
+		if p.idAttributes == nil {
+			p.idAttributes = map[string]string{}
+		}
+		p.idAttributes[resourceType] = attribute
+	}
+}
+
+// WithIDSourceAttributes fills in the ID of refreshed resources of
+// resourceType from the first of attributes that is set, e.g. arn or
+// self_link, when the provider leaves their id attribute empty. Unlike
+// WithIDAttribute, resources that do have an id keep it.
+func WithIDSourceAttributes(resourceType string, attributes ...string) ProviderOption {
+	return func(p *ProviderWrapper) {
+		if p.idSources == nil {
+			p.idSources = map[string][]string{}
+		}
+		p.idSources[resourceType] = attributes
+	}
+}
+
+// WithIDNormalizer rewrites the IDs passed to the provider's import, e.g. to
+// lowercase ARNs or to turn self links into the resource paths a provider
+// expects. IDs are passed through unchanged by default.
+func WithIDNormalizer(normalizer func(resourceType, id string) string) ProviderOption {
+	return func(p *ProviderWrapper) {
+		p.idNormalizer = normalizer
+	}
+}
+
+// WithProviderEnv sets additional environment variables on the provider
+// plugin process, e.g. to scope credentials to one provider instance. They
+// override variables also set in terraformer's own environment, except on
+// Windows where those keep their value.
+func WithProviderEnv(env map[string]string) ProviderOption {
+	return func(p *ProviderWrapper) {
+		for key, value := range env {
+			p.env = append(p.env, key+"="+value)
+		}
+		sort.Strings(p.env)
+	}
+}
+
+// WithOmittedDefaults nulls the optional attributes of resourceType that hold
+// their default in refreshed values, so they are left out of the generated
+// configuration. Defaults are keyed by attribute path, as returned by
+// configschema.Block.AttributePaths, and apply inside nested blocks too.
+func WithOmittedDefaults(resourceType string, defaults map[string]cty.Value) ProviderOption {
+	return func(p *ProviderWrapper) {
+		if p.omittedDefaults == nil {
+			p.omittedDefaults = map[string]map[string]cty.Value{}
+		}
+		p.omittedDefaults[resourceType] = defaults
+	}
+}
+
+// WithWarningLogging logs the warning diagnostics providers return, such as
+// deprecation notices, even when a call succeeds. Verbose mode implies it.
+func WithWarningLogging() ProviderOption {
+	return func(p *ProviderWrapper) {
+		p.logWarnings = true
+	}
+}
+
+// WithStrictConfig rejects a provider configuration whose nested blocks fall
+// outside their MinItems and MaxItems bounds before configuring the provider,
+// instead of leaving the provider to fail on it later.
+func WithStrictConfig() ProviderOption {
+	return func(p *ProviderWrapper) {
+		p.strictConfig = true
+	}
+}
+
+// WithConfigureWarningsAsErrors fails configuring the provider when it
+// returns warning diagnostics, such as a deprecated argument or partial
+// credentials, and not only errors. The configuration is then also validated
+// with PrepareProviderConfig first, which is otherwise skipped as configure
+// reports the same errors, so its warnings count too. Configure warnings stay
+// available from ConfigureDiagnostics.
+func WithConfigureWarningsAsErrors() ProviderOption {
+	return func(p *ProviderWrapper) {
+		p.warningsAsErrors = true
+	}
+}
+
+// WithProviderMeta sends meta, the content of a module's provider_meta block,
+// along with every read. It is coerced against the provider's ProviderMeta
+// schema.
+func WithProviderMeta(meta cty.Value) ProviderOption {
+	return func(p *ProviderWrapper) {
+		p.providerMeta = meta
+	}
+}
+
+func NewProviderWrapper(providerName string, providerConfig cty.Value, verbose bool, options ...map[string]int) (*ProviderWrapper, error) {
+	var providerOptions []ProviderOption
 	if len(options) > 0 {
 		retryCount, hasOption := options[0]["retryCount"]
 		if hasOption {
-			p.retryCount = retryCount
+			providerOptions = append(providerOptions, func(p *ProviderWrapper) { p.retryCount = retryCount })
 		}
 		retrySleepMs, hasOption := options[0]["retrySleepMs"]
 		if hasOption {
-			p.retrySleepMs = retrySleepMs
+			providerOptions = append(providerOptions, func(p *ProviderWrapper) { p.retrySleepMs = retrySleepMs })
 		}
 	}
+	return NewProviderWrapperWithOptions(providerName, providerConfig, verbose, providerOptions...)
+}
+
+func NewProviderWrapperWithOptions(providerName string, providerConfig cty.Value, verbose bool, options ...ProviderOption) (*ProviderWrapper, error) {
+	return NewProviderWrapperContext(context.Background(), providerName, providerConfig, verbose, options...)
+}
+
+// NewProviderWrapperContext is like NewProviderWrapperWithOptions, but gives
+// up on a provider that hangs at startup once ctx is done, killing the plugin
+// process it launched. ctx only bounds the startup: launching the plugin,
+// fetching its schema and configuring it.
+func NewProviderWrapperContext(ctx context.Context, providerName string, providerConfig cty.Value, verbose bool, options ...ProviderOption) (*ProviderWrapper, error) {
+	p, err := newProviderWrapper(providerName, providerConfig, options)
+	if err != nil {
+		return p, err
+	}
+
+	err = p.initProvider(ctx, verbose)
+
+	return p, err
+}
+
+// NewProviderWrapperFromPath is like NewProviderWrapperWithOptions, but
+// launches the provider binary at binaryPath instead of searching the plugin
+// directories for it, e.g. to try out a locally built provider.
+func NewProviderWrapperFromPath(providerName, binaryPath string, providerConfig cty.Value, verbose bool, options ...ProviderOption) (*ProviderWrapper, error) {
+	if _, err := os.Stat(binaryPath); err != nil {
+		return nil, fmt.Errorf("can't use provider binary: %w", err)
+	}
+	p, err := newProviderWrapper(providerName, providerConfig, options)
+	if err != nil {
+		return p, err
+	}
+	p.binaryPath = binaryPath
+
+	err = p.initProvider(context.Background(), verbose)
+
+	return p, err
+}
+
+// NewProviderWrapperFromServer wraps a provider that is already being served,
+// e.g. in-process, instead of launching a plugin binary, and configures it.
+func NewProviderWrapperFromServer(providerName string, provider tfprotov5.ProviderServer, providerConfig cty.Value, options ...ProviderOption) (*ProviderWrapper, error) {
+	p, err := newProviderWrapper(providerName, providerConfig, options)
+	p.provider = provider
+	p.context = context.Background()
+	if err != nil {
+		return p, err
+	}
 
-	err := p.initProvider(verbose)
+	err = p.configureProvider(p.context)
 
 	return p, err
 }
 
+// newProviderWrapper applies options to a new wrapper, failing on the first
+// invalid one after applying them all.
+func newProviderWrapper(providerName string, providerConfig cty.Value, options []ProviderOption) (*ProviderWrapper, error) {
+	p := &ProviderWrapper{retryCount: 5, retrySleepMs: 300}
+	p.providerName = providerName
+	p.config = providerConfig
+
+	for _, option := range options {
+		option(p)
+	}
+	return p, p.optionErr
+}
+
+// Kill asks the provider to stop its in-flight work before terminating the
+// plugin process, so providers get a chance to clean up. Prefer Close, which
+// also reports whether the temporary files of the wrapper were released.
 func (p *ProviderWrapper) Kill() {
-	p.client.Kill()
+	if err := p.Close(); err != nil {
+		p.log().Warn("%v", err)
+	}
 }
 
+// Close stops and terminates the plugin process, like Kill, and removes the
+// temporary files of the wrapper, such as a provider binary extracted from a
+// zip. It is safe to call more than once, e.g. in a defer.
+func (p *ProviderWrapper) Close() error {
+	if p.provider != nil && p.client != nil && !p.client.Exited() {
+		ctx, cancel := context.WithTimeout(p.context, stopProviderTimeout)
+		resp, err := p.provider.StopProvider(ctx, &tfprotov5.StopProviderRequest{})
+		cancel()
+		if err != nil {
+			p.log().Warn("failed to stop provider %s: %v", p.providerName, err)
+		} else if resp != nil && resp.Error != "" {
+			p.log().Warn("failed to stop provider %s: %s", p.providerName, resp.Error)
+		}
+	}
+	if p.client != nil {
+		p.client.Kill()
+	}
+	return p.removeExtractedProvider()
+}
+
+// removeExtractedProvider removes the provider binary extracted from a zip,
+// if any. The plugin process must not be running anymore.
+func (p *ProviderWrapper) removeExtractedProvider() error {
+	if p.extractedDir != "" {
+		if err := os.RemoveAll(p.extractedDir); err != nil {
+			return fmt.Errorf("failed to remove extracted provider %s: %w", p.extractedDir, err)
+		}
+		p.extractedDir = ""
+	}
+	return nil
+}
+
+// GetSchema returns the provider schema, fetching it on first use. It is safe
+// to call concurrently, the schema is only fetched once. A response carrying
+// error diagnostics is returned as an error and not cached.
 func (p *ProviderWrapper) GetSchema() (*tfprotov5.GetProviderSchemaResponse, error) {
+	return p.getSchema(p.context)
+}
+
+// getSchema is GetSchema with the schema call, if one is needed, bounded by
+// ctx.
+func (p *ProviderWrapper) getSchema(ctx context.Context) (*tfprotov5.GetProviderSchemaResponse, error) {
+	p.schemaMutex.Lock()
+	defer p.schemaMutex.Unlock()
 	if p.schema == nil {
-		r, err := p.provider.GetProviderSchema(p.context, &tfprotov5.GetProviderSchemaRequest{})
+		schema, err := p.loadSchema(ctx)
 		if err != nil {
 			return nil, err
 		}
-		p.schema = r
+		p.schema = p.overrideSchemaTypes(schema)
 	}
 	return p.schema, nil
 }
 
+// loadSchema returns the schema shared by other wrappers or cached on disk,
+// or else fetches it from the provider.
+func (p *ProviderWrapper) loadSchema(ctx context.Context) (*tfprotov5.GetProviderSchemaResponse, error) {
+	var sharedKey string
+	if p.sharedSchema {
+		sharedKey = sharedSchemaKey(p.providerName, p.providerFilePath)
+	}
+	if sharedKey != "" {
+		if shared, ok := loadSharedSchema(sharedKey); ok {
+			return shared, nil
+		}
+	}
+	useCache := p.schemaCacheDir != "" && p.providerFilePath != ""
+	if useCache {
+		if cached, ok := loadCachedSchema(p.schemaCacheDir, p.providerName, p.providerFilePath); ok {
+			return p.shareSchema(sharedKey, cached), nil
+		}
+	}
+	r, err := p.provider.GetProviderSchema(ctx, &tfprotov5.GetProviderSchemaRequest{})
+	if err != nil {
+		return nil, err
+	}
+	// don't keep a partial schema around, the next call gets to retry
+	if err := configschema.WrapDiagnostics(r.Diagnostics).ToError(); err != nil {
+		return nil, fmt.Errorf("provider %s returned a degraded schema: %w", p.providerName, err)
+	}
+	if useCache {
+		if err := storeCachedSchema(p.schemaCacheDir, p.providerName, p.providerFilePath, r); err != nil {
+			p.log().Warn("can't cache schema of provider %s: %v", p.providerName, err)
+		}
+	}
+	return p.shareSchema(sharedKey, r), nil
+}
+
+// shareSchema returns the schema shared under key, sharing schema when none
+// is yet. Without a key, schema is kept to the wrapper.
+func (p *ProviderWrapper) shareSchema(key string, schema *tfprotov5.GetProviderSchemaResponse) *tfprotov5.GetProviderSchemaResponse {
+	if key == "" {
+		return schema
+	}
+	return storeSharedSchema(key, schema)
+}
+
+// ServerCapabilities returns the optional protocol features the provider
+// announces in its schema, e.g. PlanDestroy, so callers can adapt to them.
+// None are reported when the schema can't be fetched.
+//
+// Provider functions and ephemeral resources, added to the schema by later
+// protocol versions, aren't surfaced: protocol 5.3 spoken here has no fields
+// for them, so providers don't send them.
+func (p *ProviderWrapper) ServerCapabilities() *tfprotov5.ServerCapabilities {
+	r, err := p.GetSchema()
+	if err != nil || r.ServerCapabilities == nil {
+		return &tfprotov5.ServerCapabilities{}
+	}
+	return r.ServerCapabilities
+}
+
+// ListResourceTypes returns the sorted names of the resource types the
+// provider supports.
+func (p *ProviderWrapper) ListResourceTypes() ([]string, error) {
+	r, err := p.GetSchema()
+	if err != nil {
+		return nil, err
+	}
+	return sortedSchemaNames(r.ResourceSchemas), nil
+}
+
+// ListDataSourceTypes returns the sorted names of the data source types the
+// provider supports.
+func (p *ProviderWrapper) ListDataSourceTypes() ([]string, error) {
+	r, err := p.GetSchema()
+	if err != nil {
+		return nil, err
+	}
+	return sortedSchemaNames(r.DataSourceSchemas), nil
+}
+
+// SchemaVersion returns the version of the schema of resourceType, which the
+// provider records in the states it returns.
+func (p *ProviderWrapper) SchemaVersion(resourceType string) (uint64, error) {
+	r, err := p.GetSchema()
+	if err != nil {
+		return 0, err
+	}
+	resourceSchema, ok := r.ResourceSchemas[resourceType]
+	if !ok {
+		return 0, fmt.Errorf("unknown resource type %q for provider %q", resourceType, p.providerName)
+	}
+	return uint64(resourceSchema.Version), nil
+}
+
+// stateSchemaVersion returns the schema version recorded in the meta of
+// state, if any. It is an int in shimmed states, and a string or a float64 in
+// decoded ones.
+func stateSchemaVersion(state *terraform.InstanceState) (uint64, bool) {
+	switch version := state.Meta["schema_version"].(type) {
+	case int:
+		return uint64(version), version >= 0
+	case int64:
+		return uint64(version), version >= 0
+	case float64:
+		return uint64(version), version >= 0
+	case string:
+		parsed, err := strconv.ParseUint(version, 10, 64)
+		return parsed, err == nil
+	}
+	return 0, false
+}
+
+func sortedSchemaNames(schemas map[string]*tfprotov5.Schema) []string {
+	names := make([]string, 0, len(schemas))
+	for name := range schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetReadOnlyAttributes returns, per resource type, the patterns matching the
+// flatmapped names of the attributes that are ignored. Each classification
+// is reported to the WithReadOnlyTrace callback, if any.
 func (p *ProviderWrapper) GetReadOnlyAttributes(resourceTypes []string) (map[string][]string, error) {
 	r, err := p.GetSchema()
 
@@ -109,30 +594,132 @@ func (p *ProviderWrapper) GetReadOnlyAttributes(resourceTypes []string) (map[str
 	readOnlyAttributes := map[string][]string{}
 	for resourceName, obj := range r.ResourceSchemas {
 		if terraformerstring.ContainsString(resourceTypes, resourceName) {
-			readOnlyAttributes[resourceName] = append(readOnlyAttributes[resourceName], "^id$")
+			trace := p.readOnlyTracer(resourceName)
+			idPattern := "^" + p.idAttribute(resourceName) + "$"
+			readOnlyAttributes[resourceName] = append(readOnlyAttributes[resourceName], idPattern)
+			trace(p.idAttribute(resourceName), idPattern)
 			for _, v := range obj.Block.Attributes {
 				if !v.Optional && !v.Required {
+					pattern := "^" + v.Name + "$"
 					if v.Type.Is(tftypes.List{}) || v.Type.Is(tftypes.Set{}) {
-						readOnlyAttributes[resourceName] = append(readOnlyAttributes[resourceName], "^"+v.Name+"\\.(.*)")
-					} else {
-						readOnlyAttributes[resourceName] = append(readOnlyAttributes[resourceName], "^"+v.Name+"$")
+						pattern = "^" + v.Name + "\\.(.*)"
 					}
+					readOnlyAttributes[resourceName] = append(readOnlyAttributes[resourceName], pattern)
+					trace(v.Name, pattern)
+				} else if v.Name != p.idAttribute(resourceName) {
+					trace(v.Name, "")
 				}
 			}
-			readOnlyAttributes[resourceName] = p.readObjBlocks(obj.Block.BlockTypes, readOnlyAttributes[resourceName], "-1")
+			readOnlyAttributes[resourceName] = p.walkObjBlocks(obj.Block.BlockTypes, readOnlyAttributes[resourceName], "-1", "", trace)
 		}
 	}
 	return readOnlyAttributes, nil
 }
 
+// ClassifyAttributes reports for each flatmapped attribute of attrs, e.g. a
+// sample state of resourceType, whether the patterns of GetReadOnlyAttributes
+// class it as read-only, to debug why an attribute is left out of or kept in
+// the generated configuration.
+func (p *ProviderWrapper) ClassifyAttributes(resourceType string, attrs map[string]string) (map[string]bool, error) {
+	readOnly, err := p.GetReadOnlyAttributes([]string{resourceType})
+	if err != nil {
+		return nil, err
+	}
+	patterns, ok := readOnly[resourceType]
+	if !ok {
+		return nil, fmt.Errorf("unknown resource type %q for provider %q", resourceType, p.providerName)
+	}
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, re)
+	}
+	classified := make(map[string]bool, len(attrs))
+	for path := range attrs {
+		classified[path] = false
+		for _, re := range compiled {
+			if re.MatchString(path) {
+				classified[path] = true
+				break
+			}
+		}
+	}
+	return classified, nil
+}
+
+// ReadOnlyPathsForValue returns the paths of the read-only attributes, those
+// neither required nor optional plus the top-level id, that are set in val, a
+// value of typeName. Unlike GetReadOnlyAttributes it yields concrete paths,
+// indexed by the actual elements of nested blocks. It returns nil when the
+// type isn't part of the provider schema.
+func (p *ProviderWrapper) ReadOnlyPathsForValue(typeName string, val cty.Value) []cty.Path {
+	r, err := p.GetSchema()
+	if err != nil {
+		return nil
+	}
+	resourceSchema, ok := r.ResourceSchemas[typeName]
+	if !ok || val.IsNull() || !val.IsKnown() {
+		return nil
+	}
+	var paths []cty.Path
+	if val.Type().IsObjectType() && val.Type().HasAttribute("id") && !val.GetAttr("id").IsNull() {
+		paths = append(paths, cty.GetAttrPath("id"))
+	}
+	return readOnlyPathsForBlock(resourceSchema.Block, val, nil, paths)
+}
+
+func readOnlyPathsForBlock(block *tfprotov5.SchemaBlock, val cty.Value, path cty.Path, paths []cty.Path) []cty.Path {
+	if block == nil || val.IsNull() || !val.IsKnown() || !val.Type().IsObjectType() {
+		return paths
+	}
+	for _, attr := range block.Attributes {
+		if attr.Optional || attr.Required || (len(path) == 0 && attr.Name == "id") {
+			continue
+		}
+		if val.Type().HasAttribute(attr.Name) && !val.GetAttr(attr.Name).IsNull() {
+			paths = append(paths, path.GetAttr(attr.Name))
+		}
+	}
+	for _, nested := range block.BlockTypes {
+		if !val.Type().HasAttribute(nested.TypeName) {
+			continue
+		}
+		nestedVal := val.GetAttr(nested.TypeName)
+		nestedPath := path.GetAttr(nested.TypeName)
+		switch nested.Nesting {
+		case tfprotov5.SchemaNestedBlockNestingModeList, tfprotov5.SchemaNestedBlockNestingModeSet, tfprotov5.SchemaNestedBlockNestingModeMap:
+			if nestedVal.IsNull() || !nestedVal.IsKnown() || !nestedVal.CanIterateElements() {
+				continue
+			}
+			for it := nestedVal.ElementIterator(); it.Next(); {
+				key, element := it.Element()
+				paths = readOnlyPathsForBlock(nested.Block, element, nestedPath.Index(key), paths)
+			}
+		default:
+			paths = readOnlyPathsForBlock(nested.Block, nestedVal, nestedPath, paths)
+		}
+	}
+	return paths
+}
+
 func (p *ProviderWrapper) readObjBlocks(block []*tfprotov5.SchemaNestedBlock, readOnlyAttributes []string, parent string) []string {
+	return p.walkObjBlocks(block, readOnlyAttributes, parent, "", func(string, string) {})
+}
+
+// walkObjBlocks is readObjBlocks passing each classification to trace, along
+// with the schema path of the attribute, prefixed by schemaPath.
+func (p *ProviderWrapper) walkObjBlocks(block []*tfprotov5.SchemaNestedBlock, readOnlyAttributes []string, parent, schemaPath string, trace func(path string, pattern string)) []string {
 	for _, v := range block {
 		k := v.TypeName
+		blockPath := schemaPath + k
 		if len(v.Block.BlockTypes) > 0 {
 			if parent == "-1" {
-				readOnlyAttributes = p.readObjBlocks(v.Block.BlockTypes, readOnlyAttributes, k)
+				readOnlyAttributes = p.walkObjBlocks(v.Block.BlockTypes, readOnlyAttributes, k, blockPath+".", trace)
 			} else {
-				readOnlyAttributes = p.readObjBlocks(v.Block.BlockTypes, readOnlyAttributes, parent+"\\.[0-9]+\\."+k)
+				readOnlyAttributes = p.walkObjBlocks(v.Block.BlockTypes, readOnlyAttributes, parent+"\\.[0-9]+\\."+k, blockPath+".", trace)
 			}
 		}
 		fieldCount := 0
@@ -140,61 +727,204 @@ func (p *ProviderWrapper) readObjBlocks(block []*tfprotov5.SchemaNestedBlock, re
 			key := l.Name
 			if !l.Optional && !l.Required {
 				fieldCount++
+				var pattern string
 				switch v.Nesting {
 				case tfprotov5.SchemaNestedBlockNestingModeList:
 					if parent == "-1" {
-						readOnlyAttributes = append(readOnlyAttributes, "^"+k+"\\.[0-9]+\\."+key+"($|\\.[0-9]+|\\.#)")
+						pattern = "^" + k + "\\.[0-9]+\\." + key + "($|\\.[0-9]+|\\.#)"
 					} else {
-						readOnlyAttributes = append(readOnlyAttributes, "^"+parent+"\\.(.*)\\."+key+"$")
+						pattern = "^" + parent + "\\.(.*)\\." + key + "$"
 					}
 				case tfprotov5.SchemaNestedBlockNestingModeSet:
 					if parent == "-1" {
-						readOnlyAttributes = append(readOnlyAttributes, "^"+k+"\\.[0-9]+\\."+key+"$")
+						pattern = "^" + k + "\\.[0-9]+\\." + key + "$"
 					} else {
-						readOnlyAttributes = append(readOnlyAttributes, "^"+parent+"\\.(.*)\\."+key+"($|\\.(.*))")
+						pattern = "^" + parent + "\\.(.*)\\." + key + "($|\\.(.*))"
 					}
 				case tfprotov5.SchemaNestedBlockNestingModeMap:
-					readOnlyAttributes = append(readOnlyAttributes, parent+"\\."+key)
+					pattern = parent + "\\." + key
 				default:
-					readOnlyAttributes = append(readOnlyAttributes, parent+"\\."+key+"$")
+					pattern = parent + "\\." + key + "$"
 				}
+				readOnlyAttributes = append(readOnlyAttributes, pattern)
+				trace(blockPath+"."+key, pattern)
+			} else {
+				trace(blockPath+"."+key, "")
 			}
 		}
 		if fieldCount == len(v.Block.Attributes) && fieldCount > 0 && len(v.Block.BlockTypes) == 0 {
 			readOnlyAttributes = append(readOnlyAttributes, "^"+k)
+			trace(blockPath, "^"+k)
 		}
 	}
 	return readOnlyAttributes
 }
 
 func (p *ProviderWrapper) Refresh(info *terraform.InstanceInfo, state *terraform.InstanceState) (*terraform.InstanceState, error) {
+	return p.RefreshWithContext(p.context, info, state)
+}
+
+// RefreshWithContext is Refresh bounded by ctx: once ctx is done, in-flight
+// calls are cancelled, no further retries or import fallback are attempted
+// and ctx.Err() is returned.
+func (p *ProviderWrapper) RefreshWithContext(ctx context.Context, info *terraform.InstanceInfo, state *terraform.InstanceState) (*terraform.InstanceState, error) {
+	return p.RefreshWithImportID(ctx, info, state, "")
+}
+
+// RefreshWithImportID is RefreshWithContext for resources whose import ID
+// differs from their state ID, such as composite region/name IDs. When the
+// read fails, importID is passed to the import fallback instead of state.ID,
+// unless it is empty.
+func (p *ProviderWrapper) RefreshWithImportID(ctx context.Context, info *terraform.InstanceInfo, state *terraform.InstanceState, importID string) (*terraform.InstanceState, error) {
+	refresher, err := p.NewTypeRefresher(info.Type)
+	if err != nil {
+		return nil, err
+	}
+	return refresher.Refresh(ctx, info, state, importID)
+}
+
+// TypeRefresher refreshes resources of a single type, looking up their schema
+// and computing its implied type once for all of them. It keeps using the
+// schema it was created with when the provider schema is reloaded.
+type TypeRefresher struct {
+	p              *ProviderWrapper
+	resourceType   string
+	provSchema     *tfprotov5.GetProviderSchemaResponse
+	resourceSchema *tfprotov5.Schema
+	impliedType    cty.Type
+}
+
+// NewTypeRefresher returns a TypeRefresher for resources of resourceType.
+func (p *ProviderWrapper) NewTypeRefresher(resourceType string) (*TypeRefresher, error) {
 	provSchema, err := p.GetSchema()
 	if err != nil {
 		return nil, err
 	}
-	impliedType := configschema.WrapBlock(provSchema.ResourceSchemas[info.Type].Block).ImpliedType()
-	priorState, err := state.AttrsAsObjectValue(impliedType)
+	resourceSchema, ok := provSchema.ResourceSchemas[resourceType]
+	if !ok {
+		return nil, fmt.Errorf("unknown resource type %q for provider %q", resourceType, p.providerName)
+	}
+	return &TypeRefresher{
+		p:              p,
+		resourceType:   resourceType,
+		provSchema:     provSchema,
+		resourceSchema: resourceSchema,
+		impliedType:    configschema.WrapBlock(resourceSchema.Block).ImpliedType(),
+	}, nil
+}
+
+// Refresh is ProviderWrapper.RefreshWithImportID for a resource of the
+// refresher's type. When the refresh fails because the provider plugin died,
+// the plugin is relaunched with EnsureAlive and the refresh tried once more.
+func (r *TypeRefresher) Refresh(ctx context.Context, info *terraform.InstanceInfo, state *terraform.InstanceState, importID string) (*terraform.InstanceState, error) {
+	refreshed, died, err := r.refreshLive(ctx, info, state, importID)
+	if err == nil || !died || ctx.Err() != nil {
+		return refreshed, err
+	}
+	if aliveErr := r.p.EnsureAlive(ctx); aliveErr != nil {
+		r.p.log().Warn("%v", aliveErr)
+		return refreshed, err
+	}
+	refreshed, _, err = r.refreshLive(ctx, info, state, importID)
+	return refreshed, err
+}
+
+// refreshLive refreshes the resource while keeping EnsureAlive from
+// relaunching the plugin underneath it, and tells whether the plugin was
+// found dead when the refresh failed.
+func (r *TypeRefresher) refreshLive(ctx context.Context, info *terraform.InstanceInfo, state *terraform.InstanceState, importID string) (*terraform.InstanceState, bool, error) {
+	r.p.liveness.RLock()
+	defer r.p.liveness.RUnlock()
+	refreshed, err := r.refresh(ctx, info, state, importID)
+	return refreshed, err != nil && !r.p.alive(), err
+}
+
+func (r *TypeRefresher) refresh(ctx context.Context, info *terraform.InstanceInfo, state *terraform.InstanceState, importID string) (*terraform.InstanceState, error) {
+	if info.Type != r.resourceType {
+		return nil, fmt.Errorf("can't refresh %s with the refresher of %s", info.Id, r.resourceType)
+	}
+	p := r.p
+	if err := p.checkConfigured("refresh " + info.Id); err != nil {
+		return nil, err
+	}
+	if version, ok := stateSchemaVersion(state); ok && version != uint64(r.resourceSchema.Version) {
+		p.log().Warn("state of resource %s has schema version %d but provider %s uses version %d, it is read without being upgraded and attributes may be lost",
+			info.Id, version, p.providerName, r.resourceSchema.Version)
+	}
+	decoded := state
+	if idAttribute := p.idAttribute(info.Type); idAttribute != "id" {
+		// AttrsAsObjectValue would write the ID into the id attribute
+		decoded = state.DeepCopy()
+		if decoded.Attributes == nil {
+			decoded.Attributes = map[string]string{}
+		}
+		if state.ID != "" && decoded.Attributes[idAttribute] == "" {
+			decoded.Attributes[idAttribute] = state.ID
+		}
+		decoded.ID = ""
+	}
+	priorState, err := decoded.AttrsAsObjectValue(r.impliedType)
+	if err != nil {
+		p.log().Warn("can't decode state of resource %s, rebuilding it from its flatmapped attributes: %v", info.Id, err)
+		attrs := make(map[string]string, len(decoded.Attributes)+1)
+		for k, v := range decoded.Attributes {
+			attrs[k] = v
+		}
+		if decoded.ID != "" {
+			attrs["id"] = decoded.ID
+		}
+		priorState = flatmapObjectValue(attrs, r.impliedType)
+	}
+	providerMeta, err := p.providerMetaValue(r.provSchema)
 	if err != nil {
 		return nil, err
 	}
 	successReadResource := false
 	var resp *tfprotov5.ReadResourceResponse
-	for i := 0; i < p.retryCount; i++ {
-		resp, err = p.provider.ReadResource(p.context, &tfprotov5.ReadResourceRequest{
+	retry := p.retryConfig(info.Type)
+	for i := 0; i < retry.Count; i++ {
+		resp, err = p.provider.ReadResource(ctx, &tfprotov5.ReadResourceRequest{
 			TypeName:     info.Type,
 			CurrentState: NewDynamicValue(priorState),
 			Private:      []byte{},
+			ProviderMeta: providerMeta,
 		})
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		if err != nil {
-			log.Println(err)
-			log.Println(resp.Diagnostics)
-			log.Printf("WARN: Fail read resource from provider for resource %s, wait %dms before retry\n", info.Id, p.retrySleepMs)
-			time.Sleep(time.Duration(p.retrySleepMs) * time.Millisecond)
+			p.log().Error("%v", err)
+			var diagnostics []*tfprotov5.Diagnostic
+			if resp != nil {
+				diagnostics = resp.Diagnostics
+				p.log().Error("%v", diagnostics)
+			}
+			if !p.isRetryable(err, diagnostics) {
+				p.log().Warn("Fail read resource from provider for resource %s, not retrying", info.Id)
+				break
+			}
+			if i+1 < retry.Count && !p.takeRetry() {
+				p.log().Warn("Fail read resource from provider for resource %s, retry budget exhausted", info.Id)
+				break
+			}
+			p.log().Warn("Fail read resource from provider for resource %s, wait %dms before retry", info.Id, retry.SleepMs)
+			if err := sleepContext(ctx, retry.SleepMs); err != nil {
+				return nil, err
+			}
 			continue
 		} else {
+			// A null state isn't a deferred read: providers only defer reads
+			// for clients announcing deferral_allowed, which protocol 5.3
+			// spoken here can't, so their reads are never deferred.
 			if resp.NewState == nil {
-				log.Printf("WARN: Read resource response is null for resource %s, wait %dms before retry\n", info.Id, p.retrySleepMs)
-				time.Sleep(time.Duration(p.retrySleepMs) * time.Millisecond)
+				if i+1 < retry.Count && !p.takeRetry() {
+					p.log().Warn("Read resource response is null for resource %s, retry budget exhausted", info.Id)
+					break
+				}
+				p.log().Warn("Read resource response is null for resource %s, wait %dms before retry", info.Id, retry.SleepMs)
+				if err := sleepContext(ctx, retry.SleepMs); err != nil {
+					return nil, err
+				}
 				continue
 			}
 			successReadResource = true
@@ -204,19 +934,22 @@ func (p *ProviderWrapper) Refresh(info *terraform.InstanceInfo, state *terraform
 
 	var newState *tfprotov5.DynamicValue
 	if !successReadResource {
-		log.Println("Fail read resource from provider, trying import command")
+		p.log().Info("Fail read resource from provider, trying import command")
 		// retry with regular import command - without resource attributes
-		importResponse, err := p.provider.ImportResourceState(p.context, &tfprotov5.ImportResourceStateRequest{
-			TypeName: info.Type,
-			ID:       state.ID,
-		})
+		if importID == "" {
+			importID = state.ID
+		}
+		importedResources, err := p.importResourceState(ctx, info.Type, importID)
 		if err != nil {
 			return nil, err
 		}
-		if len(importResponse.ImportedResources) == 0 {
-			return nil, errors.New("not able to import resource for a given ID")
+		newState = importedResources[0].State
+		for _, imported := range importedResources {
+			if imported.TypeName == info.Type {
+				newState = imported.State
+				break
+			}
 		}
-		newState = importResponse.ImportedResources[0].State
 	} else {
 		if resp.NewState == nil {
 			msg := fmt.Sprintf("ERROR: Read resource response is null for resource %s", info.Id)
@@ -225,18 +958,236 @@ func (p *ProviderWrapper) Refresh(info *terraform.InstanceInfo, state *terraform
 		newState = resp.NewState
 	}
 
-	newStateVal, err := UnmarshallDynamicValue(newState, impliedType)
+	newStateVal, err := UnmarshallDynamicValue(newState, r.impliedType)
 	if err != nil {
 		return nil, err
 	}
-	return terraform.NewInstanceStateShimmedFromValue(newStateVal, int(provSchema.ResourceSchemas[info.Type].Version)), nil
+	newStateVal, err = p.transformRefreshedValue(info.Type, newStateVal)
+	if err != nil {
+		return nil, err
+	}
+	return p.shimInstanceState(info.Type, newStateVal, r.resourceSchema.Version), nil
 }
 
-func (p *ProviderWrapper) initProvider(verbose bool) error {
-	reattach := getReattachProviders()
-	providerFilePath, err := getProviderFileName(p.providerName)
-	if err != nil && reattach == nil {
-		return err
+// PlanChange asks the provider to plan changing a resource of type info.Type
+// from prior to proposed, and returns the planned value with the provider
+// diagnostics. Planning a refreshed state onto itself reveals the computed
+// defaults and normalization a later terraform plan would show. Both values
+// are coerced against the resource schema first; a null prior plans a
+// creation. The configuration sent along is proposed without its read-only
+// attributes, as terraform would have it.
+func (p *ProviderWrapper) PlanChange(info *terraform.InstanceInfo, prior, proposed cty.Value) (cty.Value, []*tfprotov5.Diagnostic, error) {
+	return p.PlanChangeWithContext(p.context, info, prior, proposed)
+}
+
+// PlanChangeWithContext is PlanChange bounded by ctx, which cancels the plan
+// call once it is done.
+func (p *ProviderWrapper) PlanChangeWithContext(ctx context.Context, info *terraform.InstanceInfo, prior, proposed cty.Value) (cty.Value, []*tfprotov5.Diagnostic, error) {
+	p.liveness.RLock()
+	defer p.liveness.RUnlock()
+	if err := p.checkConfigured("plan " + info.Id); err != nil {
+		return cty.NilVal, nil, err
+	}
+	provSchema, err := p.GetSchema()
+	if err != nil {
+		return cty.NilVal, nil, err
+	}
+	resourceSchema, ok := provSchema.ResourceSchemas[info.Type]
+	if !ok {
+		return cty.NilVal, nil, fmt.Errorf("unknown resource type %q for provider %q", info.Type, p.providerName)
+	}
+	block := configschema.WrapBlock(resourceSchema.Block)
+	if prior.IsNull() {
+		prior = cty.NullVal(block.ImpliedType())
+	} else if prior, err = block.CoerceValue(prior); err != nil {
+		return cty.NilVal, nil, fmt.Errorf("invalid prior state of %s: %w", info.Id, err)
+	}
+	proposed, err = block.CoerceValue(proposed)
+	if err != nil {
+		return cty.NilVal, nil, fmt.Errorf("invalid proposed state of %s: %w", info.Id, err)
+	}
+	config, err := nullPaths(proposed, p.ReadOnlyPathsForValue(info.Type, proposed))
+	if err != nil {
+		return cty.NilVal, nil, err
+	}
+	providerMeta, err := p.providerMetaValue(provSchema)
+	if err != nil {
+		return cty.NilVal, nil, err
+	}
+	resp, err := p.provider.PlanResourceChange(ctx, &tfprotov5.PlanResourceChangeRequest{
+		TypeName:         info.Type,
+		PriorState:       NewDynamicValue(prior),
+		ProposedNewState: NewDynamicValue(proposed),
+		Config:           NewDynamicValue(config),
+		PriorPrivate:     []byte{},
+		ProviderMeta:     providerMeta,
+	})
+	if ctx.Err() != nil {
+		return cty.NilVal, nil, ctx.Err()
+	}
+	var diagnostics []*tfprotov5.Diagnostic
+	if resp != nil {
+		diagnostics = resp.Diagnostics
+	}
+	if err != nil {
+		return cty.NilVal, diagnostics, err
+	}
+	if resp.PlannedState == nil {
+		return cty.NilVal, diagnostics, fmt.Errorf("plan response is null for resource %s", info.Id)
+	}
+	planned, err := UnmarshallDynamicValue(resp.PlannedState, block.ImpliedType())
+	if err != nil {
+		return cty.NilVal, diagnostics, err
+	}
+	return planned, diagnostics, nil
+}
+
+// nullPaths returns val with the values at paths replaced by nulls.
+func nullPaths(val cty.Value, paths []cty.Path) (cty.Value, error) {
+	if len(paths) == 0 {
+		return val, nil
+	}
+	return cty.Transform(val, func(path cty.Path, v cty.Value) (cty.Value, error) {
+		for _, p := range paths {
+			if p.Equals(path) {
+				return cty.NullVal(v.Type()), nil
+			}
+		}
+		return v, nil
+	})
+}
+
+// idAttribute returns the name of the attribute identifying resources of
+// resourceType.
+func (p *ProviderWrapper) idAttribute(resourceType string) string {
+	if attribute, ok := p.idAttributes[resourceType]; ok {
+		return attribute
+	}
+	return "id"
+}
+
+// shimInstanceState converts val into an InstanceState whose ID is taken from
+// the id attribute of resourceType, or else from its ID source attributes.
+func (p *ProviderWrapper) shimInstanceState(resourceType string, val cty.Value, version int64) *terraform.InstanceState {
+	state := terraform.NewInstanceStateShimmedFromValue(val, int(version))
+	idAttribute := p.idAttribute(resourceType)
+	if idAttribute != "id" {
+		state.ID = state.Attributes[idAttribute]
+	}
+	if state.ID == "" {
+		for _, source := range p.idSources[resourceType] {
+			if id := state.Attributes[source]; id != "" {
+				state.ID = id
+				state.Attributes[idAttribute] = id
+				break
+			}
+		}
+	}
+	return state
+}
+
+// ImportResources imports the resource with the given id and returns every
+// resource the provider produced, not only the one of type info.Type. Each
+// state is shimmed against the schema of its own TypeName, which is recorded
+// in Ephemeral.Type so callers can tell the extra resources apart.
+func (p *ProviderWrapper) ImportResources(info *terraform.InstanceInfo, id string) ([]*terraform.InstanceState, error) {
+	return p.ImportResourcesWithContext(p.context, info, id)
+}
+
+// ImportResourcesWithContext is ImportResources bounded by ctx, which cancels
+// the import call once it is done.
+func (p *ProviderWrapper) ImportResourcesWithContext(ctx context.Context, info *terraform.InstanceInfo, id string) ([]*terraform.InstanceState, error) {
+	p.liveness.RLock()
+	defer p.liveness.RUnlock()
+	if err := p.checkConfigured("import " + id); err != nil {
+		return nil, err
+	}
+	provSchema, err := p.GetSchema()
+	if err != nil {
+		return nil, err
+	}
+	importedResources, err := p.importResourceState(ctx, info.Type, id)
+	if err != nil {
+		return nil, err
+	}
+	states := []*terraform.InstanceState{}
+	for _, imported := range importedResources {
+		resourceSchema, ok := provSchema.ResourceSchemas[imported.TypeName]
+		if !ok {
+			return nil, fmt.Errorf("import of %s returned unknown resource type %q", info.Id, imported.TypeName)
+		}
+		impliedType := configschema.WrapBlock(resourceSchema.Block).ImpliedType()
+		stateVal, err := UnmarshallDynamicValue(imported.State, impliedType)
+		if err != nil {
+			return nil, err
+		}
+		instanceState := p.shimInstanceState(imported.TypeName, stateVal, resourceSchema.Version)
+		instanceState.Ephemeral.Type = imported.TypeName
+		states = append(states, instanceState)
+	}
+	return states, nil
+}
+
+func (p *ProviderWrapper) importResourceState(ctx context.Context, resourceType, id string) ([]*tfprotov5.ImportedResource, error) {
+	if p.idNormalizer != nil {
+		id = p.idNormalizer(resourceType, id)
+	}
+	importResponse, err := p.provider.ImportResourceState(ctx, &tfprotov5.ImportResourceStateRequest{
+		TypeName: resourceType,
+		ID:       id,
+	})
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(importResponse.ImportedResources) == 0 {
+		if diagErr := configschema.WrapDiagnostics(importResponse.Diagnostics).ToError(); diagErr != nil {
+			return nil, fmt.Errorf("not able to import resource for a given ID: %w", diagErr)
+		}
+		return nil, errors.New("not able to import resource for a given ID")
+	}
+	return importResponse.ImportedResources, nil
+}
+
+func (p *ProviderWrapper) initProvider(ctx context.Context, verbose bool) error {
+	p.verbose = verbose
+	err := p.newPluginClient(verbose)
+	if err == nil {
+		err = p.startProvider(ctx)
+		if err != nil && ctx.Err() != nil {
+			p.client.Kill()
+			err = fmt.Errorf("starting provider %s: %w", p.providerName, ctx.Err())
+		}
+	}
+	if err != nil && p.extractedDir != "" {
+		// callers don't get to Close a wrapper that failed to start
+		if p.client != nil {
+			p.client.Kill()
+		}
+		if removeErr := p.removeExtractedProvider(); removeErr != nil {
+			p.log().Warn("%v", removeErr)
+		}
+	}
+	return err
+}
+
+// newPluginClient sets up the client of the provider plugin, without
+// launching or attaching to it yet.
+func (p *ProviderWrapper) newPluginClient(verbose bool) error {
+	var reattach *plugin.ReattachConfig
+	var err error
+	providerFilePath := p.binaryPath
+	if providerFilePath == "" {
+		reattach, err = getReattachProviders(p.providerName)
+		if err != nil {
+			return err
+		}
+		providerFilePath, err = getProviderFileName(p.providerName)
+		if err != nil && reattach == nil {
+			return err
+		}
 	}
 	options := hclog.LoggerOptions{
 		Name:   "plugin",
@@ -247,27 +1198,62 @@ func (p *ProviderWrapper) initProvider(verbose bool) error {
 		options.Level = hclog.Trace
 	}
 	logger := hclog.New(&options)
+	if reattach == nil && strings.HasSuffix(providerFilePath, ".zip") {
+		providerFilePath, p.extractedDir, err = extractProviderZip(providerFilePath, p.providerName)
+		if err != nil {
+			return err
+		}
+	}
+	p.providerFilePath = providerFilePath
 	cmd := exec.Command(providerFilePath)
+	if p.memoryLimit > 0 {
+		cmd = limitCommand(cmd, p.memoryLimit, p.log())
+	}
+	cmd = envCommand(cmd, p.env)
+	var logWarning func(string)
+	if verbose || p.logWarnings {
+		logWarning = func(warning string) {
+			p.log().Warn("%s", warning)
+		}
+	}
+	versionedPlugins := tfplugin.NewVersionedPlugins(logWarning)
 	var unversionedPlugins plugin.PluginSet
 	if reattach != nil {
 		cmd = nil
+		// the reattached provider may not be running the discovered binary
+		p.providerFilePath = ""
 		// github.com/hashicorp/terraform@v1.4.5/internal/command/meta_providers.go/unmanagedProviderFactory
-		unversionedPlugins = tfplugin.VersionedPlugins[reattach.ProtocolVersion]
+		unversionedPlugins = versionedPlugins[reattach.ProtocolVersion]
 	}
+	handshake := tfplugin.Handshake
+	if p.handshake != nil {
+		handshake = *p.handshake
+	}
+	p.stderr = newLineTail(stderrTailLines)
 	p.client = plugin.NewClient(&plugin.ClientConfig{
 		Cmd:              cmd,
+		Stderr:           p.stderr,
 		Reattach:         reattach,
-		HandshakeConfig:  tfplugin.Handshake,
-		VersionedPlugins: tfplugin.VersionedPlugins,
+		HandshakeConfig:  handshake,
+		VersionedPlugins: versionedPlugins,
 		Plugins:          unversionedPlugins,
 		Managed:          (reattach == nil),
 		Logger:           logger,
 		AllowedProtocols: []plugin.Protocol{plugin.ProtocolGRPC},
 		AutoMTLS:         true,
 	})
-	p.rpcClient, err = p.client.Client()
+	return nil
+}
+
+// startProvider launches or attaches to the provider plugin and configures
+// it, giving up once ctx is done.
+func (p *ProviderWrapper) startProvider(ctx context.Context) error {
+	var err error
+	p.rpcClient, err = p.dialProvider(ctx)
 	if err != nil {
-		return err
+		// reap the process first, so all of its stderr has been read
+		p.client.Kill()
+		return p.withPluginStderr(err)
 	}
 	raw, err := p.rpcClient.Dispense(tfplugin.ProviderPluginName)
 	if err != nil {
@@ -277,34 +1263,175 @@ func (p *ProviderWrapper) initProvider(verbose bool) error {
 	p.provider = raw.(tfprotov5.ProviderServer)
 	p.context = raw.(tfplugin.ClientContext).Context()
 
-	schema, err := p.GetSchema()
+	if p.schema != nil {
+		// the provider was relaunched, don't mix schemas within one run
+		if err := p.reloadSchema(ctx); err != nil {
+			return err
+		}
+	}
+	return p.configureProvider(ctx)
+}
+
+// dialProvider launches or attaches to the provider plugin. The plugin client
+// takes no context, so the dial is abandoned once ctx is done; it only hands
+// its result back over the channel, so an abandoned dial touches nothing.
+func (p *ProviderWrapper) dialProvider(ctx context.Context) (plugin.ClientProtocol, error) {
+	type dialed struct {
+		client plugin.ClientProtocol
+		err    error
+	}
+	done := make(chan dialed, 1)
+	go func() {
+		client, err := p.client.Client()
+		done <- dialed{client, err}
+	}()
+	select {
+	case d := <-done:
+		return d.client, d.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// reloadSchema fetches the schema from a relaunched provider and checks that
+// its resource schema versions match the cached ones, which would not be the
+// case if the provider binary changed underneath us.
+func (p *ProviderWrapper) reloadSchema(ctx context.Context) error {
+	r, err := p.provider.GetProviderSchema(ctx, &tfprotov5.GetProviderSchemaRequest{})
+	if err != nil {
+		return err
+	}
+	if err := compareSchemaVersions(p.schema, r); err != nil {
+		return fmt.Errorf("schema of provider %s changed after relaunch: %w", p.providerName, err)
+	}
+	return nil
+}
+
+func compareSchemaVersions(cached, reloaded *tfprotov5.GetProviderSchemaResponse) error {
+	for resourceType, cachedSchema := range cached.ResourceSchemas {
+		reloadedSchema, ok := reloaded.ResourceSchemas[resourceType]
+		if !ok {
+			return fmt.Errorf("resource type %s is gone", resourceType)
+		}
+		if cachedSchema.Version != reloadedSchema.Version {
+			return fmt.Errorf("resource type %s version %d became %d", resourceType, cachedSchema.Version, reloadedSchema.Version)
+		}
+	}
+	return nil
+}
+
+// providerMetaValue returns the provider_meta value to send with reads, or
+// nil when none was given.
+func (p *ProviderWrapper) providerMetaValue(schema *tfprotov5.GetProviderSchemaResponse) (*tfprotov5.DynamicValue, error) {
+	if p.providerMeta == cty.NilVal || p.providerMeta.IsNull() {
+		return nil, nil
+	}
+	if schema.ProviderMeta == nil {
+		return nil, fmt.Errorf("provider %s doesn't support provider_meta", p.providerName)
+	}
+	meta, err := configschema.WrapBlock(schema.ProviderMeta.Block).CoerceValue(p.providerMeta)
+	if err != nil {
+		return nil, fmt.Errorf("invalid provider_meta for provider %s: %w", p.providerName, err)
+	}
+	return NewDynamicValue(meta), nil
+}
+
+func (p *ProviderWrapper) configureProvider(ctx context.Context) error {
+	if p.schemaOnly {
+		return nil
+	}
+	schema, err := p.getSchema(ctx)
 	if err != nil {
 		return err
 	}
 	if p.config.IsNull() {
 		p.config = cty.EmptyObjectVal
 	}
-	config, err := configschema.WrapBlock(schema.Provider.Block).CoerceValue(p.config)
+	config, err := configschema.WrapBlock(schema.Provider.Block).CoerceValueWithOptions(p.config, configschema.CoerceOptions{
+		EnforceItemCounts: p.strictConfig,
+	})
 	if err != nil {
 		return err
 	}
-	_, err = p.provider.ConfigureProvider(p.context, &tfprotov5.ConfigureProviderRequest{
+	if p.warningsAsErrors {
+		if err := p.prepareProviderConfig(ctx, config); err != nil {
+			return err
+		}
+	}
+	resp, err := p.provider.ConfigureProvider(ctx, &tfprotov5.ConfigureProviderRequest{
 		TerraformVersion: "v1.0.0",
 		Config:           NewDynamicValue(config),
 	})
 	if err != nil {
 		return err
 	}
+	p.configuredValue = config
+	if resp != nil {
+		p.configureDiags = resp.Diagnostics
+	}
+	if p.warningsAsErrors {
+		if warnings := configschema.WrapDiagnostics(p.configureDiags).Warnings(); len(warnings) > 0 {
+			return fmt.Errorf("provider %s returned warnings when configured: %s", p.providerName, strings.Join(warnings, "; "))
+		}
+	}
+
+	return nil
+}
+
+// prepareProviderConfig validates config with the provider, failing on its
+// warnings as well as its errors.
+func (p *ProviderWrapper) prepareProviderConfig(ctx context.Context, config cty.Value) error {
+	resp, err := p.provider.PrepareProviderConfig(ctx, &tfprotov5.PrepareProviderConfigRequest{
+		Config: NewDynamicValue(config),
+	})
+	if err != nil {
+		return err
+	}
+	diags := configschema.WrapDiagnostics(resp.Diagnostics)
+	if err := diags.ToError(); err != nil {
+		return fmt.Errorf("invalid configuration for provider %s: %w", p.providerName, err)
+	}
+	if warnings := diags.Warnings(); len(warnings) > 0 {
+		return fmt.Errorf("provider %s returned warnings when validating its configuration: %s", p.providerName, strings.Join(warnings, "; "))
+	}
+	return nil
+}
 
+// checkConfigured fails calls that need a configured provider, to do what,
+// on a provider started with WithSchemaOnly.
+func (p *ProviderWrapper) checkConfigured(what string) error {
+	if p.schemaOnly {
+		return fmt.Errorf("can't %s: provider %s was started schema-only and isn't configured", what, p.providerName)
+	}
 	return nil
 }
 
+// ConfiguredValue returns the provider configuration as it was sent to the
+// provider, after coercion against the provider schema, so callers can see
+// the effective values such as a region or endpoint. It is a null value
+// until the provider has been configured.
+func (p *ProviderWrapper) ConfiguredValue() cty.Value {
+	if p.configuredValue == cty.NilVal {
+		return cty.NullVal(cty.DynamicPseudoType)
+	}
+	return p.configuredValue
+}
+
+// ConfigureDiagnostics returns the diagnostics, usually warnings, the
+// provider returned when it was last configured.
+func (p *ProviderWrapper) ConfigureDiagnostics() []*tfprotov5.Diagnostic {
+	return p.configureDiags
+}
+
 func getProviderFileName(providerName string) (string, error) {
 	defaultDataDir := os.Getenv("TF_DATA_DIR")
 	if defaultDataDir == "" {
 		defaultDataDir = DefaultDataDir
 	}
 	providerFilePath, err := getProviderFileNameV13andV14(defaultDataDir, providerName)
+	if mirror := os.Getenv(ProviderMirrorEnv); mirror != "" && (err != nil || providerFilePath == "") {
+		providerFilePath, err = getProviderFileNameFromMirror(mirror, providerName)
+	}
 	if err != nil || providerFilePath == "" {
 		providerFilePath, err = getProviderFileNameV13andV14(os.Getenv("HOME")+string(os.PathSeparator)+
 			".terraform.d", providerName)
@@ -319,17 +1446,43 @@ func getProviderFileNameV13andV14(prefix, providerName string) (string, error) {
 	// Read terraform v14 file path
 	registryDir := prefix + string(os.PathSeparator) + "providers" + string(os.PathSeparator) +
 		"registry.terraform.io"
-	providerDirs, err := ioutil.ReadDir(registryDir)
-	if err != nil {
+	if _, err := os.Stat(registryDir); err != nil {
 		// Read terraform v13 file path
 		registryDir = prefix + string(os.PathSeparator) + "plugins" + string(os.PathSeparator) +
 			"registry.terraform.io"
-		providerDirs, err = ioutil.ReadDir(registryDir)
-		if err != nil {
-			return "", err
+	}
+	return getProviderFileNameInHostDir(registryDir, providerName)
+}
+
+// getProviderFileNameFromMirror looks providerName up in a mirror directory,
+// laid out as <mirror>/<hostname>/<namespace>/<type>/, under any hostname.
+func getProviderFileNameFromMirror(mirror, providerName string) (string, error) {
+	hostDirs, err := ioutil.ReadDir(mirror)
+	if err != nil {
+		return "", err
+	}
+	for _, hostDir := range hostDirs {
+		if !hostDir.IsDir() {
+			continue
+		}
+		providerFilePath, err := getProviderFileNameInHostDir(mirror+string(os.PathSeparator)+hostDir.Name(), providerName)
+		if err == nil && providerFilePath != "" {
+			return providerFilePath, nil
 		}
 	}
+	return "", fmt.Errorf("provider %s not found in mirror %s", providerName, mirror)
+}
+
+// getProviderFileNameInHostDir looks providerName up in the directory of one
+// registry host, in both the unpacked <namespace>/<type>/<version>/<os>_<arch>/
+// and the packed <namespace>/<type>/ layouts.
+func getProviderFileNameInHostDir(registryDir, providerName string) (string, error) {
+	providerDirs, err := ioutil.ReadDir(registryDir)
+	if err != nil {
+		return "", err
+	}
 	providerFilePath := ""
+	providerZipPath := ""
 	for _, providerDir := range providerDirs {
 		pluginPath := registryDir + string(os.PathSeparator) + providerDir.Name() +
 			string(os.PathSeparator) + providerName
@@ -339,6 +1492,10 @@ func getProviderFileNameV13andV14(prefix, providerName string) (string, error) {
 		}
 		for _, dir := range dirs {
 			if !dir.IsDir() {
+				// packed layout, the package as downloaded from the registry
+				if isProviderZip(dir.Name(), providerName) {
+					providerZipPath = pluginPath + string(os.PathSeparator) + dir.Name()
+				}
 				continue
 			}
 			for _, dir := range dirs {
@@ -355,6 +1512,9 @@ func getProviderFileNameV13andV14(prefix, providerName string) (string, error) {
 			}
 		}
 	}
+	if providerFilePath == "" {
+		return providerZipPath, nil
+	}
 	return providerFilePath, nil
 }
 
@@ -373,22 +1533,34 @@ func getProviderFileNameV12(providerName string) (string, error) {
 		}
 	}
 	providerFilePath := ""
+	providerZipPath := ""
 	for _, file := range files {
 		if file.IsDir() {
 			continue
 		}
-		if strings.HasPrefix(file.Name(), "terraform-provider-"+providerName) {
+		switch {
+		case isProviderZip(file.Name(), providerName):
+			providerZipPath = pluginPath + string(os.PathSeparator) + file.Name()
+		case strings.HasPrefix(file.Name(), "terraform-provider-"+providerName):
 			providerFilePath = pluginPath + string(os.PathSeparator) + file.Name()
 		}
 	}
+	if providerFilePath == "" {
+		return providerZipPath, nil
+	}
 	return providerFilePath, nil
 }
 
-func getReattachProviders() *plugin.ReattachConfig {
+// getReattachProviders returns the TF_REATTACH_PROVIDERS entry of
+// providerName, keyed either by the provider's full address, such as
+// registry.terraform.io/hashicorp/aws, or by its bare name. An entry keyed by
+// providerName itself is preferred, and several addresses ending in it are
+// an error.
+func getReattachProviders(providerName string) (*plugin.ReattachConfig, error) {
 	// copied from github.com/hashicorp/terraform@v1.4.5/main.go/parseReattachProviders
 	reattach := os.Getenv("TF_REATTACH_PROVIDERS")
 	if reattach == "" {
-		return nil
+		return nil, nil
 	}
 	type reattachConfig struct {
 		Protocol        string
@@ -403,34 +1575,60 @@ func getReattachProviders() *plugin.ReattachConfig {
 	var m map[string]reattachConfig
 	err := encjson.Unmarshal([]byte(reattach), &m)
 	if err != nil {
-		log.Println("Invalid format for TF_REATTACH_PROVIDERS: %w", err)
+		return nil, fmt.Errorf("invalid format for TF_REATTACH_PROVIDERS: %w", err)
 	}
-	for p, c := range m {
-		var addr net.Addr
-		switch c.Addr.Network {
-		case "unix":
-			addr, err = net.ResolveUnixAddr("unix", c.Addr.String)
-			if err != nil {
-				log.Printf("Invalid unix socket path %q for %q: %v", c.Addr.String, p, err)
-			}
-		case "tcp":
-			addr, err = net.ResolveTCPAddr("tcp", c.Addr.String)
-			if err != nil {
-				log.Printf("Invalid TCP address %q for %q: %v", c.Addr.String, p, err)
+	p := providerName
+	c, ok := m[p]
+	if !ok {
+		var matches []string
+		for key := range m {
+			if key[strings.LastIndex(key, "/")+1:] == providerName {
+				matches = append(matches, key)
 			}
+		}
+		sort.Strings(matches)
+		switch len(matches) {
+		case 0:
+			return nil, nil
+		case 1:
+			p, c = matches[0], m[matches[0]]
 		default:
-			log.Printf("Unknown address type %q for %q", c.Addr.Network, p)
+			return nil, fmt.Errorf("TF_REATTACH_PROVIDERS has several providers named %s: %s", providerName, strings.Join(matches, ", "))
 		}
-		// we only care about the first one
-		return &plugin.ReattachConfig{
-			Protocol:        plugin.Protocol(c.Protocol),
-			ProtocolVersion: c.ProtocolVersion,
-			Pid:             c.Pid,
-			Test:            c.Test,
-			Addr:            addr,
+	}
+	addr, err := resolveReattachAddr(c.Addr.Network, c.Addr.String)
+	if err != nil {
+		return nil, fmt.Errorf("can't reattach to %q: %w", p, err)
+	}
+	return &plugin.ReattachConfig{
+		Protocol:        plugin.Protocol(c.Protocol),
+		ProtocolVersion: c.ProtocolVersion,
+		Pid:             c.Pid,
+		Test:            c.Test,
+		Addr:            addr,
+	}, nil
+}
+
+// resolveReattachAddr resolves the address a provider was started to listen
+// on. go-plugin only listens on unix sockets and, on Windows, on tcp, so
+// those are the only transports supported; named pipes aren't.
+func resolveReattachAddr(network, address string) (net.Addr, error) {
+	switch network {
+	case "unix":
+		addr, err := net.ResolveUnixAddr("unix", address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid unix socket path %q: %w", address, err)
+		}
+		return addr, nil
+	case "tcp":
+		addr, err := net.ResolveTCPAddr("tcp", address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TCP address %q: %w", address, err)
 		}
+		return addr, nil
+	default:
+		return nil, fmt.Errorf("unsupported address type %q, only unix and tcp are supported", network)
 	}
-	return nil
 }
 
 func GetProviderVersion(providerName string) string {
@@ -439,6 +1637,21 @@ func GetProviderVersion(providerName string) string {
 		log.Println("Can't find provider file path. Ensure that you are following https://www.terraform.io/docs/configuration/providers.html#third-party-plugins.")
 		return ""
 	}
+	return providerVersionFromPath(providerFilePath)
+}
+
+// GetProviderVersion is GetProviderVersion for the binary the wrapper was
+// created from, when it was given one.
+func (p *ProviderWrapper) GetProviderVersion() string {
+	if p.binaryPath == "" {
+		return GetProviderVersion(p.providerName)
+	}
+	return providerVersionFromPath(p.binaryPath)
+}
+
+// providerVersionFromPath parses the version constraint out of a plugin file
+// name such as terraform-provider-google_v4.0.0_x5.
+func providerVersionFromPath(providerFilePath string) string {
 	t := strings.Split(providerFilePath, string(os.PathSeparator))
 	providerFileName := t[len(t)-1]
 	providerFileNameParts := strings.Split(providerFileName, "_")
@@ -450,6 +1663,19 @@ func GetProviderVersion(providerName string) string {
 	return "~> " + strings.TrimPrefix(providerVersion, "v")
 }
 
+// sleepContext waits for the given number of milliseconds, returning early
+// with ctx.Err() if ctx is done first.
+func sleepContext(ctx context.Context, ms int) error {
+	timer := time.NewTimer(time.Duration(ms) * time.Millisecond)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
 func NewDynamicValue(val cty.Value) *tfprotov5.DynamicValue {
 	mp, err := msgpack.Marshal(val, val.Type())
 	if err != nil {
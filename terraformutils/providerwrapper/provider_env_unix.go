@@ -0,0 +1,34 @@
+// Copyright 2018 The Terraformer Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package providerwrapper //nolint
+
+import (
+	"os/exec"
+)
+
+// envCommand wraps cmd in env(1) setting env, so the variables override those
+// of terraformer's own environment, which the plugin client appends to
+// cmd.Env after ours.
+func envCommand(cmd *exec.Cmd, env []string) *exec.Cmd {
+	if len(env) == 0 {
+		return cmd
+	}
+	args := append(append([]string{}, env...), cmd.Path)
+	wrapped := exec.Command("/usr/bin/env", append(args, cmd.Args[1:]...)...)
+	wrapped.Env = cmd.Env
+	return wrapped
+}
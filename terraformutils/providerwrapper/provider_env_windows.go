@@ -0,0 +1,27 @@
+// Copyright 2018 The Terraformer Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providerwrapper //nolint
+
+import (
+	"os/exec"
+)
+
+// envCommand sets env on cmd. Without env(1) to wrap the plugin in, variables
+// also set in terraformer's own environment keep that value, as the plugin
+// client appends the process environment after ours.
+func envCommand(cmd *exec.Cmd, env []string) *exec.Cmd {
+	cmd.Env = append(cmd.Env, env...)
+	return cmd
+}
@@ -1,13 +1,98 @@
 package providerwrapper //nolint
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
 	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 
+	"github.com/GoogleCloudPlatform/terraformer/terraformutils/tfplugin/stoleninternal/configschema"
+	"github.com/hashicorp/go-cty/cty"
 	"github.com/hashicorp/terraform-plugin-go/tfprotov5"
 	"github.com/hashicorp/terraform-plugin-go/tftypes"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
 )
 
+// fakeProvider is an in-process tfprotov5.ProviderServer; only the methods a
+// test configures are usable, the rest panic through the nil embedded server.
+type fakeProvider struct {
+	tfprotov5.ProviderServer
+	schema              *tfprotov5.GetProviderSchemaResponse
+	getProviderSchema   func() (*tfprotov5.GetProviderSchemaResponse, error)
+	readResource        func(*tfprotov5.ReadResourceRequest) (*tfprotov5.ReadResourceResponse, error)
+	importResourceState func(*tfprotov5.ImportResourceStateRequest) (*tfprotov5.ImportResourceStateResponse, error)
+	configureProvider   func(*tfprotov5.ConfigureProviderRequest) (*tfprotov5.ConfigureProviderResponse, error)
+	prepareConfig       func(*tfprotov5.PrepareProviderConfigRequest) (*tfprotov5.PrepareProviderConfigResponse, error)
+	planResourceChange  func(*tfprotov5.PlanResourceChangeRequest) (*tfprotov5.PlanResourceChangeResponse, error)
+}
+
+func (f *fakeProvider) GetProviderSchema(ctx context.Context, req *tfprotov5.GetProviderSchemaRequest) (*tfprotov5.GetProviderSchemaResponse, error) {
+	if f.getProviderSchema != nil {
+		return f.getProviderSchema()
+	}
+	return f.schema, nil
+}
+
+func (f *fakeProvider) ConfigureProvider(ctx context.Context, req *tfprotov5.ConfigureProviderRequest) (*tfprotov5.ConfigureProviderResponse, error) {
+	if f.configureProvider != nil {
+		return f.configureProvider(req)
+	}
+	return &tfprotov5.ConfigureProviderResponse{}, nil
+}
+
+func (f *fakeProvider) PrepareProviderConfig(ctx context.Context, req *tfprotov5.PrepareProviderConfigRequest) (*tfprotov5.PrepareProviderConfigResponse, error) {
+	if f.prepareConfig != nil {
+		return f.prepareConfig(req)
+	}
+	return &tfprotov5.PrepareProviderConfigResponse{}, nil
+}
+
+func (f *fakeProvider) ReadResource(ctx context.Context, req *tfprotov5.ReadResourceRequest) (*tfprotov5.ReadResourceResponse, error) {
+	return f.readResource(req)
+}
+
+func (f *fakeProvider) ImportResourceState(ctx context.Context, req *tfprotov5.ImportResourceStateRequest) (*tfprotov5.ImportResourceStateResponse, error) {
+	return f.importResourceState(req)
+}
+
+func (f *fakeProvider) PlanResourceChange(ctx context.Context, req *tfprotov5.PlanResourceChangeRequest) (*tfprotov5.PlanResourceChangeResponse, error) {
+	return f.planResourceChange(req)
+}
+
+func newFakeProviderWrapper(f *fakeProvider) *ProviderWrapper {
+	return &ProviderWrapper{
+		context:      context.Background(),
+		provider:     f,
+		providerName: "fake",
+		retryCount:   1,
+	}
+}
+
+func fakeSchema(resourceSchemas map[string]*tfprotov5.Schema) *tfprotov5.GetProviderSchemaResponse {
+	return &tfprotov5.GetProviderSchemaResponse{
+		Provider:        &tfprotov5.Schema{Block: &tfprotov5.SchemaBlock{}},
+		ResourceSchemas: resourceSchemas,
+	}
+}
+
+func fakeResourceSchema(attributes ...string) *tfprotov5.Schema {
+	block := &tfprotov5.SchemaBlock{
+		Attributes: []*tfprotov5.SchemaAttribute{{Name: "id", Type: tftypes.String, Optional: true, Computed: true}},
+	}
+	for _, name := range attributes {
+		block.Attributes = append(block.Attributes, &tfprotov5.SchemaAttribute{Name: name, Type: tftypes.String, Optional: true})
+	}
+	return &tfprotov5.Schema{Block: block}
+}
+
 func TestIgnoredAttributes(t *testing.T) {
 	attributes := []*tfprotov5.SchemaAttribute{
 		{
@@ -77,6 +162,103 @@ func TestIgnoredAttributes(t *testing.T) {
 	}
 }
 
+func TestGetReadOnlyAttributesTrace(t *testing.T) {
+	attributes := []*tfprotov5.SchemaAttribute{
+		{Name: "computed_attribute", Type: tftypes.Number, Computed: true},
+		{Name: "required_attribute", Type: tftypes.String, Required: true},
+	}
+	schema := &tfprotov5.Schema{Block: &tfprotov5.SchemaBlock{
+		Attributes: []*tfprotov5.SchemaAttribute{{Name: "id", Type: tftypes.String, Optional: true, Computed: true}},
+		BlockTypes: []*tfprotov5.SchemaNestedBlock{{
+			TypeName: "attribute_one",
+			Nesting:  tfprotov5.SchemaNestedBlockNestingModeList,
+			Block: &tfprotov5.SchemaBlock{
+				BlockTypes: []*tfprotov5.SchemaNestedBlock{{
+					TypeName: "attribute_two_nested",
+					Nesting:  tfprotov5.SchemaNestedBlockNestingModeList,
+					Block:    &tfprotov5.SchemaBlock{Attributes: attributes},
+				}},
+			},
+		}},
+	}}
+	var trace []ReadOnlyTraceEntry
+	provider := newFakeProviderWrapper(&fakeProvider{schema: fakeSchema(map[string]*tfprotov5.Schema{"nesting_list": schema})})
+	WithReadOnlyTrace(func(entry ReadOnlyTraceEntry) { trace = append(trace, entry) })(provider)
+
+	readOnly, err := provider.GetReadOnlyAttributes([]string{"nesting_list"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ReadOnlyTraceEntry{
+		{ResourceType: "nesting_list", Path: "id", ReadOnly: true, Pattern: "^id$"},
+		{ResourceType: "nesting_list", Path: "attribute_one.attribute_two_nested.computed_attribute", ReadOnly: true, Pattern: "^attribute_one\\.(.*)\\.computed_attribute$"},
+		{ResourceType: "nesting_list", Path: "attribute_one.attribute_two_nested.required_attribute"},
+	}
+	if !reflect.DeepEqual(trace, want) {
+		t.Errorf("expected trace %+v, got %+v", want, trace)
+	}
+	var patterns []string
+	for _, entry := range trace {
+		if entry.ReadOnly {
+			patterns = append(patterns, entry.Pattern)
+		}
+	}
+	if !reflect.DeepEqual(patterns, readOnly["nesting_list"]) {
+		t.Errorf("expected the traced patterns to be %q, got %q", readOnly["nesting_list"], patterns)
+	}
+	if !isAttributeIgnored("attribute_one.0.attribute_two_nested.0.computed_attribute", patterns) {
+		t.Errorf("expected the traced pattern to match the computed attribute, got %q", patterns)
+	}
+}
+
+func TestClassifyAttributes(t *testing.T) {
+	schema := &tfprotov5.Schema{Block: &tfprotov5.SchemaBlock{
+		Attributes: []*tfprotov5.SchemaAttribute{
+			{Name: "id", Type: tftypes.String, Optional: true, Computed: true},
+			{Name: "name", Type: tftypes.String, Required: true},
+			{Name: "arns", Type: tftypes.List{ElementType: tftypes.String}, Computed: true},
+		},
+		BlockTypes: []*tfprotov5.SchemaNestedBlock{{
+			TypeName: "attribute_one",
+			Nesting:  tfprotov5.SchemaNestedBlockNestingModeList,
+			Block: &tfprotov5.SchemaBlock{Attributes: []*tfprotov5.SchemaAttribute{
+				{Name: "computed_attribute", Type: tftypes.Number, Computed: true},
+				{Name: "required_attribute", Type: tftypes.String, Required: true},
+			}},
+		}},
+	}}
+	provider := newFakeProviderWrapper(&fakeProvider{schema: fakeSchema(map[string]*tfprotov5.Schema{"nesting_list": schema})})
+
+	classified, err := provider.ClassifyAttributes("nesting_list", map[string]string{
+		"id":                                 "n-1",
+		"name":                               "n",
+		"arns.#":                             "1",
+		"arns.0":                             "arn:fake:n",
+		"attribute_one.#":                    "1",
+		"attribute_one.0.computed_attribute": "3",
+		"attribute_one.0.required_attribute": "r",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{
+		"id":                                 true,
+		"name":                               false,
+		"arns.#":                             true,
+		"arns.0":                             true,
+		"attribute_one.#":                    false,
+		"attribute_one.0.computed_attribute": true,
+		"attribute_one.0.required_attribute": false,
+	}
+	if !reflect.DeepEqual(classified, want) {
+		t.Errorf("expected %v, got %v", want, classified)
+	}
+
+	if _, err := provider.ClassifyAttributes("fake_unknown", map[string]string{"id": "x"}); err == nil {
+		t.Error("expected an unknown resource type to be rejected")
+	}
+}
+
 func isAttributeIgnored(name string, patterns []string) bool {
 	ignored := false
 	for _, pattern := range patterns {
@@ -87,3 +269,1092 @@ func isAttributeIgnored(name string, patterns []string) bool {
 	}
 	return ignored
 }
+
+func TestImportResourcesReturnsAllImported(t *testing.T) {
+	provider := newFakeProviderWrapper(&fakeProvider{
+		schema: fakeSchema(map[string]*tfprotov5.Schema{
+			"fake_instance": fakeResourceSchema("name"),
+			"fake_volume":   fakeResourceSchema("size"),
+		}),
+		importResourceState: func(req *tfprotov5.ImportResourceStateRequest) (*tfprotov5.ImportResourceStateResponse, error) {
+			return &tfprotov5.ImportResourceStateResponse{
+				ImportedResources: []*tfprotov5.ImportedResource{
+					{
+						TypeName: "fake_instance",
+						State: NewDynamicValue(cty.ObjectVal(map[string]cty.Value{
+							"id":   cty.StringVal(req.ID),
+							"name": cty.StringVal("web"),
+						})),
+					},
+					{
+						TypeName: "fake_volume",
+						State: NewDynamicValue(cty.ObjectVal(map[string]cty.Value{
+							"id":   cty.StringVal("vol-1"),
+							"size": cty.StringVal("8"),
+						})),
+					},
+				},
+			}, nil
+		},
+	})
+
+	states, err := provider.ImportResources(&terraform.InstanceInfo{Type: "fake_instance", Id: "fake_instance.web"}, "i-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != 2 {
+		t.Fatalf("expected 2 imported resources, got %d", len(states))
+	}
+	if states[0].Ephemeral.Type != "fake_instance" || states[0].ID != "i-1" || states[0].Attributes["name"] != "web" {
+		t.Errorf("unexpected first imported resource: %#v", states[0])
+	}
+	if states[1].Ephemeral.Type != "fake_volume" || states[1].ID != "vol-1" || states[1].Attributes["size"] != "8" {
+		t.Errorf("unexpected second imported resource: %#v", states[1])
+	}
+}
+
+func TestReloadSchemaDetectsVersionDrift(t *testing.T) {
+	version := int64(1)
+	provider := newFakeProviderWrapper(&fakeProvider{
+		getProviderSchema: func() (*tfprotov5.GetProviderSchemaResponse, error) {
+			resourceSchema := fakeResourceSchema("name")
+			resourceSchema.Version = version
+			return fakeSchema(map[string]*tfprotov5.Schema{"fake_instance": resourceSchema}), nil
+		},
+	})
+	if _, err := provider.GetSchema(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := provider.reloadSchema(context.Background()); err != nil {
+		t.Errorf("expected identical schema to be accepted, got %s", err)
+	}
+
+	version = 2
+	err := provider.reloadSchema(context.Background())
+	if err == nil {
+		t.Fatal("expected relaunched provider with a new schema version to be rejected")
+	}
+	if !strings.Contains(err.Error(), "fake_instance version 1 became 2") {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if provider.schema.ResourceSchemas["fake_instance"].Version != 1 {
+		t.Error("cached schema must not be replaced by a diverging one")
+	}
+}
+
+func TestReadOnlyPathsForValue(t *testing.T) {
+	schema := fakeResourceSchema("name")
+	schema.Block.Attributes = append(schema.Block.Attributes,
+		&tfprotov5.SchemaAttribute{Name: "arn", Type: tftypes.String, Computed: true},
+		&tfprotov5.SchemaAttribute{Name: "created_at", Type: tftypes.String, Computed: true},
+	)
+	schema.Block.BlockTypes = []*tfprotov5.SchemaNestedBlock{{
+		TypeName: "rule",
+		Nesting:  tfprotov5.SchemaNestedBlockNestingModeList,
+		Block: &tfprotov5.SchemaBlock{Attributes: []*tfprotov5.SchemaAttribute{
+			{Name: "port", Type: tftypes.Number, Optional: true},
+			{Name: "rule_id", Type: tftypes.String, Computed: true},
+		}},
+	}}
+	provider := newFakeProviderWrapper(&fakeProvider{schema: fakeSchema(map[string]*tfprotov5.Schema{"fake_firewall": schema})})
+
+	val := cty.ObjectVal(map[string]cty.Value{
+		"id":         cty.StringVal("fw-1"),
+		"name":       cty.StringVal("fw"),
+		"arn":        cty.StringVal("arn:fake:fw-1"),
+		"created_at": cty.NullVal(cty.String),
+		"rule": cty.ListVal([]cty.Value{
+			cty.ObjectVal(map[string]cty.Value{"port": cty.NumberIntVal(22), "rule_id": cty.StringVal("r-1")}),
+			cty.ObjectVal(map[string]cty.Value{"port": cty.NumberIntVal(443), "rule_id": cty.NullVal(cty.String)}),
+		}),
+	})
+
+	got := provider.ReadOnlyPathsForValue("fake_firewall", val)
+	want := []cty.Path{
+		cty.GetAttrPath("id"),
+		cty.GetAttrPath("arn"),
+		cty.GetAttrPath("rule").IndexInt(0).GetAttr("rule_id"),
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d paths, got %#v", len(want), got)
+	}
+	for i := range want {
+		if !got[i].Equals(want[i]) {
+			t.Errorf("path %d: expected %#v, got %#v", i, want[i], got[i])
+		}
+	}
+	if paths := provider.ReadOnlyPathsForValue("fake_unknown", val); paths != nil {
+		t.Errorf("expected no paths for an unknown type, got %#v", paths)
+	}
+}
+
+func TestListResourceAndDataSourceTypes(t *testing.T) {
+	schema := fakeSchema(map[string]*tfprotov5.Schema{
+		"fake_network":  fakeResourceSchema(),
+		"fake_instance": fakeResourceSchema(),
+		"fake_disk":     fakeResourceSchema(),
+	})
+	schema.DataSourceSchemas = map[string]*tfprotov5.Schema{
+		"fake_zones":  fakeResourceSchema(),
+		"fake_images": fakeResourceSchema(),
+	}
+	provider := newFakeProviderWrapper(&fakeProvider{schema: schema})
+
+	resourceTypes, err := provider.ListResourceTypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"fake_disk", "fake_instance", "fake_network"}; !reflect.DeepEqual(resourceTypes, want) {
+		t.Errorf("expected resource types %v, got %v", want, resourceTypes)
+	}
+	dataSourceTypes, err := provider.ListDataSourceTypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"fake_images", "fake_zones"}; !reflect.DeepEqual(dataSourceTypes, want) {
+		t.Errorf("expected data source types %v, got %v", want, dataSourceTypes)
+	}
+}
+
+func TestRefreshWithCustomIDAttribute(t *testing.T) {
+	schema := &tfprotov5.Schema{Block: &tfprotov5.SchemaBlock{Attributes: []*tfprotov5.SchemaAttribute{
+		{Name: "arn", Type: tftypes.String, Computed: true},
+		{Name: "name", Type: tftypes.String, Optional: true},
+	}}}
+	provider := newFakeProviderWrapper(echoProvider(map[string]*tfprotov5.Schema{"fake_topic": schema}))
+	WithIDAttribute("fake_topic", "arn")(provider)
+
+	state, err := provider.Refresh(&terraform.InstanceInfo{Type: "fake_topic", Id: "fake_topic.events"}, &terraform.InstanceState{
+		ID:         "arn:fake:topic/events",
+		Attributes: map[string]string{"name": "events"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.ID != "arn:fake:topic/events" {
+		t.Errorf("expected the ID to come from arn, got %q", state.ID)
+	}
+	if state.Attributes["arn"] != "arn:fake:topic/events" {
+		t.Errorf("expected arn to be read back, got %v", state.Attributes)
+	}
+
+	readOnly, err := provider.GetReadOnlyAttributes([]string{"fake_topic"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isAttributeIgnored("arn", readOnly["fake_topic"]) || isAttributeIgnored("id", readOnly["fake_topic"]) {
+		t.Errorf("expected arn to be the seeded id attribute, got %v", readOnly["fake_topic"])
+	}
+}
+
+func TestRefreshWithIDSourceAttributes(t *testing.T) {
+	resourceType := cty.Object(map[string]cty.Type{"id": cty.String, "self_link": cty.String, "name": cty.String})
+	provider := newFakeProviderWrapper(&fakeProvider{
+		schema: fakeSchema(map[string]*tfprotov5.Schema{"fake_network": fakeResourceSchema("self_link", "name")}),
+		readResource: func(req *tfprotov5.ReadResourceRequest) (*tfprotov5.ReadResourceResponse, error) {
+			prior, err := UnmarshallDynamicValue(req.CurrentState, resourceType)
+			if err != nil {
+				return nil, err
+			}
+			// the provider knows the network by its self link only
+			return &tfprotov5.ReadResourceResponse{NewState: NewDynamicValue(cty.ObjectVal(map[string]cty.Value{
+				"id":        cty.NullVal(cty.String),
+				"self_link": cty.StringVal("projects/p/global/networks/" + prior.GetAttr("name").AsString()),
+				"name":      prior.GetAttr("name"),
+			}))}, nil
+		},
+	})
+	refresh := func() *terraform.InstanceState {
+		t.Helper()
+		state, err := provider.Refresh(&terraform.InstanceInfo{Type: "fake_network", Id: "fake_network.main"}, &terraform.InstanceState{
+			ID:         "main",
+			Attributes: map[string]string{"name": "main"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return state
+	}
+
+	if state := refresh(); state.ID != "" {
+		t.Errorf("expected no ID without an ID source, got %q", state.ID)
+	}
+	WithIDSourceAttributes("fake_network", "arn", "self_link")(provider)
+	state := refresh()
+	if state.ID != "projects/p/global/networks/main" || state.Attributes["id"] != state.ID {
+		t.Errorf("expected the ID to come from self_link, got %q and %v", state.ID, state.Attributes)
+	}
+}
+
+func TestRefreshUnknownResourceType(t *testing.T) {
+	provider := newFakeProviderWrapper(echoProvider(map[string]*tfprotov5.Schema{"fake_resource": fakeResourceSchema()}))
+
+	_, err := provider.Refresh(&terraform.InstanceInfo{Type: "fake_renamed", Id: "fake_renamed.r"}, &terraform.InstanceState{ID: "r-1"})
+	if err == nil || err.Error() != `unknown resource type "fake_renamed" for provider "fake"` {
+		t.Errorf("expected an unknown resource type error, got %v", err)
+	}
+}
+
+func TestReattachUnsupportedNetwork(t *testing.T) {
+	t.Setenv("TF_REATTACH_PROVIDERS", `{"registry.terraform.io/hashicorp/fake": {
+		"Protocol": "grpc", "ProtocolVersion": 5, "Pid": 1, "Test": true,
+		"Addr": {"Network": "npipe", "String": "\\\\.\\pipe\\terraform-provider-fake"}
+	}}`)
+
+	_, err := NewProviderWrapper("fake", cty.EmptyObjectVal, false)
+	if err == nil || !strings.Contains(err.Error(), `unsupported address type "npipe"`) {
+		t.Errorf("expected an unsupported address type error, got %v", err)
+	}
+}
+
+func TestGetReattachProvidersSelectsByName(t *testing.T) {
+	t.Setenv("TF_REATTACH_PROVIDERS", `{
+		"registry.terraform.io/hashicorp/aws": {"Protocol": "grpc", "ProtocolVersion": 5, "Pid": 1, "Addr": {"Network": "tcp", "String": "127.0.0.1:10001"}},
+		"google": {"Protocol": "grpc", "ProtocolVersion": 5, "Pid": 2, "Addr": {"Network": "tcp", "String": "127.0.0.1:10002"}}
+	}`)
+
+	for name, wantPid := range map[string]int{"aws": 1, "google": 2} {
+		reattach, err := getReattachProviders(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reattach == nil || reattach.Pid != wantPid {
+			t.Errorf("expected %s to reattach to pid %d, got %+v", name, wantPid, reattach)
+		}
+	}
+	reattach, err := getReattachProviders("azurerm")
+	if err != nil || reattach != nil {
+		t.Errorf("expected no reattach config for azurerm, got %+v, %v", reattach, err)
+	}
+}
+
+func TestGetReattachProvidersPrefersExactName(t *testing.T) {
+	t.Setenv("TF_REATTACH_PROVIDERS", `{
+		"registry.terraform.io/hashicorp/aws": {"Protocol": "grpc", "ProtocolVersion": 5, "Pid": 1, "Addr": {"Network": "tcp", "String": "127.0.0.1:10001"}},
+		"aws": {"Protocol": "grpc", "ProtocolVersion": 5, "Pid": 2, "Addr": {"Network": "tcp", "String": "127.0.0.1:10002"}},
+		"registry.terraform.io/hashicorp/google": {"Protocol": "grpc", "ProtocolVersion": 5, "Pid": 3, "Addr": {"Network": "tcp", "String": "127.0.0.1:10003"}},
+		"example.com/acme/google": {"Protocol": "grpc", "ProtocolVersion": 5, "Pid": 4, "Addr": {"Network": "tcp", "String": "127.0.0.1:10004"}}
+	}`)
+
+	for name, wantPid := range map[string]int{"aws": 2, "registry.terraform.io/hashicorp/aws": 1, "example.com/acme/google": 4} {
+		reattach, err := getReattachProviders(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reattach == nil || reattach.Pid != wantPid {
+			t.Errorf("expected %s to reattach to pid %d, got %+v", name, wantPid, reattach)
+		}
+	}
+	_, err := getReattachProviders("google")
+	if err == nil || !strings.Contains(err.Error(), "example.com/acme/google, registry.terraform.io/hashicorp/google") {
+		t.Errorf("expected the ambiguous google providers to be reported, got %v", err)
+	}
+}
+
+// capturingLogger records the messages it receives, prefixed by level.
+type capturingLogger struct {
+	messages []string
+}
+
+func (l *capturingLogger) record(level, format string, args ...interface{}) {
+	l.messages = append(l.messages, level+" "+fmt.Sprintf(format, args...))
+}
+
+func (l *capturingLogger) Debug(format string, args ...interface{}) {
+	l.record("debug", format, args...)
+}
+func (l *capturingLogger) Info(format string, args ...interface{}) { l.record("info", format, args...) }
+func (l *capturingLogger) Warn(format string, args ...interface{}) { l.record("warn", format, args...) }
+func (l *capturingLogger) Error(format string, args ...interface{}) {
+	l.record("error", format, args...)
+}
+
+func TestRefreshLogsThroughLogger(t *testing.T) {
+	provider := newFakeProviderWrapper(&fakeProvider{
+		schema: fakeSchema(map[string]*tfprotov5.Schema{"fake_resource": fakeResourceSchema()}),
+		readResource: func(req *tfprotov5.ReadResourceRequest) (*tfprotov5.ReadResourceResponse, error) {
+			return nil, errors.New("connection refused")
+		},
+		importResourceState: func(req *tfprotov5.ImportResourceStateRequest) (*tfprotov5.ImportResourceStateResponse, error) {
+			return &tfprotov5.ImportResourceStateResponse{}, nil
+		},
+	})
+	logger := &capturingLogger{}
+	WithLogger(logger)(provider)
+
+	if _, err := provider.Refresh(&terraform.InstanceInfo{Type: "fake_resource", Id: "fake_resource.r"}, &terraform.InstanceState{ID: "r-1"}); err == nil {
+		t.Fatal("expected the refresh to fail")
+	}
+	want := []string{
+		"error connection refused",
+		"warn Fail read resource from provider for resource fake_resource.r, wait 0ms before retry",
+		"info Fail read resource from provider, trying import command",
+	}
+	if !reflect.DeepEqual(logger.messages, want) {
+		t.Errorf("expected messages %q, got %q", want, logger.messages)
+	}
+}
+
+func TestGetSchemaConcurrentFirstCalls(t *testing.T) {
+	var calls int32
+	provider := newFakeProviderWrapper(&fakeProvider{getProviderSchema: func() (*tfprotov5.GetProviderSchemaResponse, error) {
+		atomic.AddInt32(&calls, 1)
+		return fakeSchema(map[string]*tfprotov5.Schema{"fake_resource": fakeResourceSchema()}), nil
+	}})
+
+	var wg sync.WaitGroup
+	schemas := make([]*tfprotov5.GetProviderSchemaResponse, 32)
+	for i := range schemas {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			schemas[i], _ = provider.GetSchema()
+		}(i)
+	}
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("expected the schema to be fetched once, got %d", calls)
+	}
+	for i, schema := range schemas {
+		if schema == nil || schema != schemas[0] {
+			t.Errorf("caller %d got a different schema", i)
+		}
+	}
+}
+
+func TestConfiguredValueMatchesSentConfig(t *testing.T) {
+	var sent *tfprotov5.DynamicValue
+	provider := &fakeProvider{
+		schema: &tfprotov5.GetProviderSchemaResponse{
+			Provider: &tfprotov5.Schema{Block: &tfprotov5.SchemaBlock{Attributes: []*tfprotov5.SchemaAttribute{
+				{Name: "region", Type: tftypes.String, Optional: true},
+				{Name: "endpoint", Type: tftypes.String, Optional: true},
+			}}},
+		},
+		configureProvider: func(req *tfprotov5.ConfigureProviderRequest) (*tfprotov5.ConfigureProviderResponse, error) {
+			sent = req.Config
+			return &tfprotov5.ConfigureProviderResponse{Diagnostics: []*tfprotov5.Diagnostic{
+				{Severity: tfprotov5.DiagnosticSeverityWarning, Summary: "endpoint is deprecated"},
+			}}, nil
+		},
+	}
+
+	p, err := NewProviderWrapperFromServer("fake", provider, cty.ObjectVal(map[string]cty.Value{
+		"region": cty.StringVal("eu-west-1"),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	configured := p.ConfiguredValue()
+	want := cty.ObjectVal(map[string]cty.Value{
+		"region":   cty.StringVal("eu-west-1"),
+		"endpoint": cty.NullVal(cty.String),
+	})
+	if !configured.RawEquals(want) {
+		t.Errorf("expected configured value %#v, got %#v", want, configured)
+	}
+	sentVal, err := UnmarshallDynamicValue(sent, configured.Type())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sentVal.RawEquals(configured) {
+		t.Errorf("configured value %#v differs from the sent config %#v", configured, sentVal)
+	}
+	if diags := p.ConfigureDiagnostics(); len(diags) != 1 || diags[0].Summary != "endpoint is deprecated" {
+		t.Errorf("expected the configure warning to be kept, got %v", diags)
+	}
+}
+
+func TestConfigureWarningsAsErrors(t *testing.T) {
+	provider := &fakeProvider{
+		schema: fakeSchema(nil),
+		configureProvider: func(req *tfprotov5.ConfigureProviderRequest) (*tfprotov5.ConfigureProviderResponse, error) {
+			return &tfprotov5.ConfigureProviderResponse{Diagnostics: []*tfprotov5.Diagnostic{
+				{Severity: tfprotov5.DiagnosticSeverityWarning, Summary: "credentials are partial"},
+			}}, nil
+		},
+	}
+
+	if _, err := NewProviderWrapperFromServer("fake", provider, cty.EmptyObjectVal); err != nil {
+		t.Fatalf("expected the warning to be tolerated by default, got %v", err)
+	}
+	_, err := NewProviderWrapperFromServer("fake", provider, cty.EmptyObjectVal, WithConfigureWarningsAsErrors())
+	if err == nil || !strings.Contains(err.Error(), "credentials are partial") {
+		t.Errorf("expected the warning to fail a strict configure, got %v", err)
+	}
+
+	provider.configureProvider = nil
+	provider.prepareConfig = func(req *tfprotov5.PrepareProviderConfigRequest) (*tfprotov5.PrepareProviderConfigResponse, error) {
+		return &tfprotov5.PrepareProviderConfigResponse{Diagnostics: []*tfprotov5.Diagnostic{
+			{Severity: tfprotov5.DiagnosticSeverityWarning, Summary: "argument is deprecated"},
+		}}, nil
+	}
+	if _, err := NewProviderWrapperFromServer("fake", provider, cty.EmptyObjectVal); err != nil {
+		t.Fatalf("expected the configuration not to be validated by default, got %v", err)
+	}
+	_, err = NewProviderWrapperFromServer("fake", provider, cty.EmptyObjectVal, WithConfigureWarningsAsErrors())
+	if err == nil || !strings.Contains(err.Error(), "argument is deprecated") {
+		t.Errorf("expected the validation warning to fail a strict configure, got %v", err)
+	}
+}
+
+func TestSchemaOnlySkipsConfigure(t *testing.T) {
+	provider := &fakeProvider{
+		schema: fakeSchema(map[string]*tfprotov5.Schema{"fake_resource": fakeResourceSchema("name")}),
+		configureProvider: func(req *tfprotov5.ConfigureProviderRequest) (*tfprotov5.ConfigureProviderResponse, error) {
+			return nil, errors.New("no credentials")
+		},
+	}
+	// the provider schema has no region, the config can't even be coerced
+	config := cty.ObjectVal(map[string]cty.Value{"region": cty.StringVal("eu-west-1")})
+
+	if _, err := NewProviderWrapperFromServer("fake", provider, config); err == nil {
+		t.Fatal("expected the invalid config to fail a regular wrapper")
+	}
+	p, err := NewProviderWrapperFromServer("fake", provider, config, WithSchemaOnly())
+	if err != nil {
+		t.Fatalf("expected a schema-only wrapper not to be configured, got %v", err)
+	}
+	if types, err := p.ListResourceTypes(); err != nil || len(types) != 1 {
+		t.Errorf("expected the schema to be available, got %v, %v", types, err)
+	}
+	_, err = p.Refresh(&terraform.InstanceInfo{Type: "fake_resource", Id: "fake_resource.r"}, &terraform.InstanceState{ID: "r-1"})
+	if err == nil || !strings.Contains(err.Error(), "schema-only") {
+		t.Errorf("expected refreshing a schema-only provider to fail, got %v", err)
+	}
+}
+
+func TestRefreshDoesNotRetryFatalErrors(t *testing.T) {
+	for _, test := range []struct {
+		summary   string
+		wantReads int
+	}{
+		{"resource not found", 1},
+		{"AccessDenied: not authorized", 1},
+		{"request throttled, resource not found yet", 3},
+		{"connection refused", 3},
+	} {
+		t.Run(test.summary, func(t *testing.T) {
+			reads := 0
+			provider := newFakeProviderWrapper(&fakeProvider{
+				schema: fakeSchema(map[string]*tfprotov5.Schema{"fake_resource": fakeResourceSchema("name")}),
+				readResource: func(req *tfprotov5.ReadResourceRequest) (*tfprotov5.ReadResourceResponse, error) {
+					reads++
+					return &tfprotov5.ReadResourceResponse{Diagnostics: []*tfprotov5.Diagnostic{
+						{Severity: tfprotov5.DiagnosticSeverityError, Summary: test.summary},
+					}}, errors.New("read failed")
+				},
+				importResourceState: func(req *tfprotov5.ImportResourceStateRequest) (*tfprotov5.ImportResourceStateResponse, error) {
+					return &tfprotov5.ImportResourceStateResponse{ImportedResources: []*tfprotov5.ImportedResource{{
+						TypeName: "fake_resource",
+						State:    NewDynamicValue(cty.ObjectVal(map[string]cty.Value{"id": cty.StringVal(req.ID), "name": cty.NullVal(cty.String)})),
+					}}}, nil
+				},
+			})
+			provider.retryCount = 3
+
+			if _, err := provider.Refresh(&terraform.InstanceInfo{Type: "fake_resource", Id: "fake_resource.r"}, &terraform.InstanceState{ID: "r-1"}); err != nil {
+				t.Fatal(err)
+			}
+			if reads != test.wantReads {
+				t.Errorf("expected %d reads, got %d", test.wantReads, reads)
+			}
+		})
+	}
+}
+
+func TestDefaultRetryClassifierFollowsCategories(t *testing.T) {
+	for message, want := range map[string]configschema.DiagnosticCategory{
+		"Rate limit exceeded":                      configschema.DiagnosticThrottling,
+		"quota exceeded for project":               configschema.DiagnosticThrottling,
+		"dial tcp: i/o timeout":                    configschema.DiagnosticThrottling,
+		"read: connection reset by peer":           configschema.DiagnosticThrottling,
+		"User is not authorized to perform action": configschema.DiagnosticAuth,
+		"expired token":                            configschema.DiagnosticAuth,
+		"ResourceNotFound: no such bucket":         configschema.DiagnosticNotFound,
+		"connection refused":                       configschema.DiagnosticUncategorized,
+	} {
+		diag := &tfprotov5.Diagnostic{Severity: tfprotov5.DiagnosticSeverityError, Summary: message}
+		if got := configschema.CategorizeDiagnostic(diag); got != want {
+			t.Errorf("%q: expected category %s, got %s", message, want, got)
+		}
+		wantRetry := want == configschema.DiagnosticThrottling || want == configschema.DiagnosticUncategorized
+		if got := DefaultRetryClassifier(errors.New("read failed"), []*tfprotov5.Diagnostic{diag}); got != wantRetry {
+			t.Errorf("%q in a diagnostic: expected retry %v, got %v", message, wantRetry, got)
+		}
+		if got := DefaultRetryClassifier(errors.New(message), nil); got != wantRetry {
+			t.Errorf("%q in an error: expected retry %v, got %v", message, wantRetry, got)
+		}
+	}
+}
+
+func TestRefreshCustomRetryClassifier(t *testing.T) {
+	reads := 0
+	provider := newFakeProviderWrapper(&fakeProvider{
+		schema: fakeSchema(map[string]*tfprotov5.Schema{"fake_resource": fakeResourceSchema("name")}),
+		readResource: func(req *tfprotov5.ReadResourceRequest) (*tfprotov5.ReadResourceResponse, error) {
+			reads++
+			return nil, errors.New("quota exhausted")
+		},
+		importResourceState: func(req *tfprotov5.ImportResourceStateRequest) (*tfprotov5.ImportResourceStateResponse, error) {
+			return nil, errors.New("import failed")
+		},
+	})
+	provider.retryCount = 3
+	WithRetryClassifier(func(err error, diagnostics []*tfprotov5.Diagnostic) bool {
+		return !strings.Contains(err.Error(), "quota") && DefaultRetryClassifier(err, diagnostics)
+	})(provider)
+
+	_, _ = provider.Refresh(&terraform.InstanceInfo{Type: "fake_resource", Id: "fake_resource.r"}, &terraform.InstanceState{ID: "r-1"})
+	if reads != 1 {
+		t.Errorf("expected the custom classifier to stop retries, got %d reads", reads)
+	}
+}
+
+func TestRefreshResourceRetries(t *testing.T) {
+	reads := map[string]int{}
+	provider := newFakeProviderWrapper(&fakeProvider{
+		schema: fakeSchema(map[string]*tfprotov5.Schema{
+			"fake_resource": fakeResourceSchema("name"),
+			"fake_flaky":    fakeResourceSchema("name"),
+		}),
+		readResource: func(req *tfprotov5.ReadResourceRequest) (*tfprotov5.ReadResourceResponse, error) {
+			reads[req.TypeName]++
+			return nil, errors.New("connection refused")
+		},
+		importResourceState: func(req *tfprotov5.ImportResourceStateRequest) (*tfprotov5.ImportResourceStateResponse, error) {
+			return nil, errors.New("import failed")
+		},
+	})
+	provider.retryCount = 2
+	WithResourceRetries(map[string]RetryConfig{"fake_flaky": {Count: 4}})(provider)
+
+	for _, resourceType := range []string{"fake_resource", "fake_flaky"} {
+		_, _ = provider.Refresh(&terraform.InstanceInfo{Type: resourceType, Id: resourceType + ".r"}, &terraform.InstanceState{ID: "r-1"})
+	}
+	if reads["fake_flaky"] != 4 {
+		t.Errorf("expected the override to apply to fake_flaky, got %d reads", reads["fake_flaky"])
+	}
+	if reads["fake_resource"] != 2 {
+		t.Errorf("expected fake_resource to keep the default retries, got %d reads", reads["fake_resource"])
+	}
+}
+
+func TestRefreshRetryBudget(t *testing.T) {
+	var reads int64
+	provider := newFakeProviderWrapper(&fakeProvider{
+		schema: fakeSchema(map[string]*tfprotov5.Schema{"fake_resource": fakeResourceSchema("name")}),
+		readResource: func(req *tfprotov5.ReadResourceRequest) (*tfprotov5.ReadResourceResponse, error) {
+			atomic.AddInt64(&reads, 1)
+			return nil, errors.New("rate exceeded")
+		},
+		importResourceState: func(req *tfprotov5.ImportResourceStateRequest) (*tfprotov5.ImportResourceStateResponse, error) {
+			return nil, errors.New("import failed")
+		},
+	})
+	provider.retryCount = 5
+	WithRetryBudget(3)(provider)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, _ = provider.Refresh(&terraform.InstanceInfo{Type: "fake_resource", Id: fmt.Sprintf("fake_resource.r%d", i)}, &terraform.InstanceState{ID: fmt.Sprintf("r-%d", i)})
+		}(i)
+	}
+	wg.Wait()
+	if reads != 10+3 {
+		t.Errorf("expected one read per resource plus the 3 budgeted retries, got %d reads", reads)
+	}
+}
+
+func TestServerCapabilities(t *testing.T) {
+	provider := newFakeProviderWrapper(&fakeProvider{schema: fakeSchema(map[string]*tfprotov5.Schema{})})
+	if capabilities := provider.ServerCapabilities(); capabilities == nil || capabilities.PlanDestroy {
+		t.Errorf("expected no capabilities when the provider announces none, got %v", capabilities)
+	}
+
+	provider.schema = nil
+	provider.provider.(*fakeProvider).schema.ServerCapabilities = &tfprotov5.ServerCapabilities{PlanDestroy: true}
+	if !provider.ServerCapabilities().PlanDestroy {
+		t.Error("expected the PlanDestroy capability of the schema")
+	}
+}
+
+func TestGetSchemaDoesNotCacheDegradedSchema(t *testing.T) {
+	calls := 0
+	provider := newFakeProviderWrapper(&fakeProvider{
+		getProviderSchema: func() (*tfprotov5.GetProviderSchemaResponse, error) {
+			calls++
+			if calls == 1 {
+				schema := fakeSchema(map[string]*tfprotov5.Schema{})
+				schema.Diagnostics = []*tfprotov5.Diagnostic{
+					{Severity: tfprotov5.DiagnosticSeverityError, Summary: "schema unavailable", Detail: "backend timed out"},
+				}
+				return schema, nil
+			}
+			return fakeSchema(map[string]*tfprotov5.Schema{"fake_resource": fakeResourceSchema()}), nil
+		},
+	})
+
+	_, err := provider.GetSchema()
+	if err == nil || !strings.Contains(err.Error(), "backend timed out") {
+		t.Fatalf("expected the degraded schema to be reported, got %v", err)
+	}
+	schema, err := provider.GetSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := schema.ResourceSchemas["fake_resource"]; !ok || calls != 2 {
+		t.Errorf("expected the clean schema to be fetched on retry, got %d calls", calls)
+	}
+	if _, err := provider.GetSchema(); err != nil || calls != 2 {
+		t.Errorf("expected the clean schema to be cached, got %d calls, %v", calls, err)
+	}
+}
+
+func TestRefreshSendsProviderMeta(t *testing.T) {
+	metaType := cty.Object(map[string]cty.Type{"module_name": cty.String, "feature": cty.String})
+	var sent *tfprotov5.DynamicValue
+	schema := fakeSchema(map[string]*tfprotov5.Schema{"fake_resource": fakeResourceSchema("name")})
+	schema.ProviderMeta = &tfprotov5.Schema{Block: &tfprotov5.SchemaBlock{Attributes: []*tfprotov5.SchemaAttribute{
+		{Name: "module_name", Type: tftypes.String, Optional: true},
+		{Name: "feature", Type: tftypes.String, Optional: true},
+	}}}
+	provider := newFakeProviderWrapper(&fakeProvider{
+		schema: schema,
+		readResource: func(req *tfprotov5.ReadResourceRequest) (*tfprotov5.ReadResourceResponse, error) {
+			sent = req.ProviderMeta
+			return &tfprotov5.ReadResourceResponse{NewState: req.CurrentState}, nil
+		},
+	})
+	WithProviderMeta(cty.ObjectVal(map[string]cty.Value{"module_name": cty.StringVal("terraformer")}))(provider)
+
+	if _, err := provider.Refresh(&terraform.InstanceInfo{Type: "fake_resource", Id: "fake_resource.r"}, &terraform.InstanceState{ID: "r-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if sent == nil {
+		t.Fatal("expected provider_meta to be sent")
+	}
+	meta, err := UnmarshallDynamicValue(sent, metaType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := cty.ObjectVal(map[string]cty.Value{"module_name": cty.StringVal("terraformer"), "feature": cty.NullVal(cty.String)})
+	if !meta.RawEquals(want) {
+		t.Errorf("expected provider_meta %#v, got %#v", want, meta)
+	}
+}
+
+func TestRefreshWithImportIDUsesCompositeID(t *testing.T) {
+	for _, test := range []struct {
+		importID string
+		want     string
+	}{
+		{"us-east1/r-1", "us-east1/r-1"},
+		{"", "r-1"},
+	} {
+		var imported []string
+		provider := newFakeProviderWrapper(&fakeProvider{
+			schema: fakeSchema(map[string]*tfprotov5.Schema{"fake_resource": fakeResourceSchema("name")}),
+			readResource: func(req *tfprotov5.ReadResourceRequest) (*tfprotov5.ReadResourceResponse, error) {
+				return nil, errors.New("resource not found")
+			},
+			importResourceState: func(req *tfprotov5.ImportResourceStateRequest) (*tfprotov5.ImportResourceStateResponse, error) {
+				imported = append(imported, req.ID)
+				return &tfprotov5.ImportResourceStateResponse{ImportedResources: []*tfprotov5.ImportedResource{{
+					TypeName: "fake_resource",
+					State:    NewDynamicValue(cty.ObjectVal(map[string]cty.Value{"id": cty.StringVal("r-1"), "name": cty.StringVal("r")})),
+				}}}, nil
+			},
+		})
+
+		state, err := provider.RefreshWithImportID(context.Background(), &terraform.InstanceInfo{Type: "fake_resource", Id: "fake_resource.r"}, &terraform.InstanceState{ID: "r-1"}, test.importID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(imported, []string{test.want}) {
+			t.Errorf("import ID %q: expected import with %q, got %v", test.importID, test.want, imported)
+		}
+		if state.ID != "r-1" {
+			t.Errorf("import ID %q: expected state ID r-1, got %q", test.importID, state.ID)
+		}
+	}
+}
+
+func TestRefreshNormalizesImportID(t *testing.T) {
+	var imported []string
+	provider := newFakeProviderWrapper(&fakeProvider{
+		schema: fakeSchema(map[string]*tfprotov5.Schema{"fake_topic": fakeResourceSchema("name")}),
+		readResource: func(req *tfprotov5.ReadResourceRequest) (*tfprotov5.ReadResourceResponse, error) {
+			return nil, errors.New("resource not found")
+		},
+		importResourceState: func(req *tfprotov5.ImportResourceStateRequest) (*tfprotov5.ImportResourceStateResponse, error) {
+			imported = append(imported, req.ID)
+			return &tfprotov5.ImportResourceStateResponse{ImportedResources: []*tfprotov5.ImportedResource{{
+				TypeName: "fake_topic",
+				State:    NewDynamicValue(cty.ObjectVal(map[string]cty.Value{"id": cty.StringVal(req.ID), "name": cty.StringVal("events")})),
+			}}}, nil
+		},
+	})
+	var normalizedTypes []string
+	WithIDNormalizer(func(resourceType, id string) string {
+		normalizedTypes = append(normalizedTypes, resourceType)
+		return strings.ToLower(id)
+	})(provider)
+
+	state, err := provider.Refresh(&terraform.InstanceInfo{Type: "fake_topic", Id: "fake_topic.events"}, &terraform.InstanceState{ID: "arn:fake:topic/Events"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(imported, []string{"arn:fake:topic/events"}) {
+		t.Errorf("expected the import to receive the normalized ID, got %v", imported)
+	}
+	if !reflect.DeepEqual(normalizedTypes, []string{"fake_topic"}) {
+		t.Errorf("expected the normalizer to be called for fake_topic, got %v", normalizedTypes)
+	}
+	if state.ID != "arn:fake:topic/events" {
+		t.Errorf("expected the imported state ID, got %q", state.ID)
+	}
+}
+
+func TestRefreshFallsBackToFlatmapAttributes(t *testing.T) {
+	schema := &tfprotov5.Schema{Block: &tfprotov5.SchemaBlock{
+		Attributes: []*tfprotov5.SchemaAttribute{
+			{Name: "id", Type: tftypes.String, Optional: true, Computed: true},
+			{Name: "name", Type: tftypes.String, Optional: true},
+			{Name: "priority", Type: tftypes.Number, Optional: true},
+			{Name: "tags", Type: tftypes.Map{ElementType: tftypes.String}, Optional: true},
+		},
+		BlockTypes: []*tfprotov5.SchemaNestedBlock{{
+			TypeName: "rule",
+			Nesting:  tfprotov5.SchemaNestedBlockNestingModeList,
+			Block: &tfprotov5.SchemaBlock{Attributes: []*tfprotov5.SchemaAttribute{
+				{Name: "port", Type: tftypes.Number, Optional: true},
+				{Name: "protocol", Type: tftypes.String, Optional: true},
+			}},
+		}},
+	}}
+	// an unset number stored as "", a legacy map counted with # instead of %,
+	// and a stale block count
+	state := &terraform.InstanceState{ID: "fw-1", Attributes: map[string]string{
+		"name":            "fw",
+		"priority":        "",
+		"tags.#":          "1",
+		"tags.env":        "prod",
+		"rule.#":          "3",
+		"rule.0.port":     "22",
+		"rule.0.protocol": "tcp",
+		"rule.1.port":     "443",
+	}}
+	impliedType := configschema.WrapBlock(schema.Block).ImpliedType()
+	if _, err := state.DeepCopy().AttrsAsObjectValue(impliedType); err == nil {
+		t.Fatal("expected the flatmapped state to be rejected by AttrsAsObjectValue")
+	}
+	var sent cty.Value
+	provider := newFakeProviderWrapper(&fakeProvider{
+		schema: fakeSchema(map[string]*tfprotov5.Schema{"fake_firewall": schema}),
+		readResource: func(req *tfprotov5.ReadResourceRequest) (*tfprotov5.ReadResourceResponse, error) {
+			var err error
+			sent, err = UnmarshallDynamicValue(req.CurrentState, impliedType)
+			if err != nil {
+				return nil, err
+			}
+			return &tfprotov5.ReadResourceResponse{NewState: req.CurrentState}, nil
+		},
+	})
+
+	refreshed, err := provider.Refresh(&terraform.InstanceInfo{Type: "fake_firewall", Id: "fake_firewall.fw"}, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule := func(port int64, protocol cty.Value) cty.Value {
+		return cty.ObjectVal(map[string]cty.Value{"port": cty.NumberIntVal(port), "protocol": protocol})
+	}
+	want := cty.ObjectVal(map[string]cty.Value{
+		"id":       cty.StringVal("fw-1"),
+		"name":     cty.StringVal("fw"),
+		"priority": cty.NullVal(cty.Number),
+		"tags":     cty.MapVal(map[string]cty.Value{"env": cty.StringVal("prod")}),
+		"rule":     cty.ListVal([]cty.Value{rule(22, cty.StringVal("tcp")), rule(443, cty.NullVal(cty.String))}),
+	})
+	if !sent.RawEquals(want) {
+		t.Errorf("expected prior state %#v, got %#v", want, sent)
+	}
+	if refreshed.Attributes["rule.#"] != "2" || refreshed.Attributes["tags.%"] != "1" {
+		t.Errorf("expected the refreshed state to be normalized, got %v", refreshed.Attributes)
+	}
+}
+
+func TestRefreshFlatmapFallbackKeepsIDAttribute(t *testing.T) {
+	schema := fakeResourceSchema("arn")
+	schema.Block.Attributes = append(schema.Block.Attributes, &tfprotov5.SchemaAttribute{Name: "priority", Type: tftypes.Number, Optional: true})
+	impliedType := configschema.WrapBlock(schema.Block).ImpliedType()
+	var sent cty.Value
+	provider := newFakeProviderWrapper(&fakeProvider{
+		schema: fakeSchema(map[string]*tfprotov5.Schema{"fake_role": schema}),
+		readResource: func(req *tfprotov5.ReadResourceRequest) (*tfprotov5.ReadResourceResponse, error) {
+			var err error
+			sent, err = UnmarshallDynamicValue(req.CurrentState, impliedType)
+			if err != nil {
+				return nil, err
+			}
+			return &tfprotov5.ReadResourceResponse{NewState: req.CurrentState}, nil
+		},
+	})
+	WithIDAttribute("fake_role", "arn")(provider)
+	logger := &capturingLogger{}
+	WithLogger(logger)(provider)
+
+	// an unset number stored as "" forces the flatmap fallback
+	state := &terraform.InstanceState{ID: "arn:role/web", Attributes: map[string]string{
+		"id":       "web",
+		"priority": "",
+	}}
+	if _, err := provider.Refresh(&terraform.InstanceInfo{Type: "fake_role", Id: "fake_role.web"}, state); err != nil {
+		t.Fatal(err)
+	}
+	if len(logger.messages) == 0 || !strings.Contains(logger.messages[0], "rebuilding it from its flatmapped attributes") {
+		t.Fatalf("expected the flatmap fallback, got %q", logger.messages)
+	}
+	if got := sent.GetAttr("id"); !got.RawEquals(cty.StringVal("web")) {
+		t.Errorf("expected the id attribute to be kept, got %#v", got)
+	}
+	if got := sent.GetAttr("arn"); !got.RawEquals(cty.StringVal("arn:role/web")) {
+		t.Errorf("expected the state ID in the arn attribute, got %#v", got)
+	}
+}
+
+func TestPlanChangeRevealsNormalization(t *testing.T) {
+	resourceSchema := fakeResourceSchema("name")
+	resourceSchema.Block.Attributes = append(resourceSchema.Block.Attributes, &tfprotov5.SchemaAttribute{Name: "arn", Type: tftypes.String, Computed: true})
+	var sentConfig cty.Value
+	provider := newFakeProviderWrapper(&fakeProvider{
+		schema: fakeSchema(map[string]*tfprotov5.Schema{"fake_resource": resourceSchema}),
+		planResourceChange: func(req *tfprotov5.PlanResourceChangeRequest) (*tfprotov5.PlanResourceChangeResponse, error) {
+			ty := configschema.WrapBlock(resourceSchema.Block).ImpliedType()
+			var err error
+			if sentConfig, err = UnmarshallDynamicValue(req.Config, ty); err != nil {
+				return nil, err
+			}
+			proposed, err := UnmarshallDynamicValue(req.ProposedNewState, ty)
+			if err != nil {
+				return nil, err
+			}
+			attrs := proposed.AsValueMap()
+			attrs["name"] = cty.StringVal(strings.ToLower(attrs["name"].AsString()))
+			return &tfprotov5.PlanResourceChangeResponse{
+				PlannedState: NewDynamicValue(cty.ObjectVal(attrs)),
+				Diagnostics:  []*tfprotov5.Diagnostic{{Severity: tfprotov5.DiagnosticSeverityWarning, Summary: "name normalized"}},
+			}, nil
+		},
+	})
+
+	state := cty.ObjectVal(map[string]cty.Value{
+		"id":   cty.StringVal("r-1"),
+		"name": cty.StringVal("Web"),
+		"arn":  cty.StringVal("arn:fake:r-1"),
+	})
+	planned, diagnostics, err := provider.PlanChange(&terraform.InstanceInfo{Type: "fake_resource", Id: "fake_resource.r"}, state, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := planned.GetAttr("name"); !got.RawEquals(cty.StringVal("web")) {
+		t.Errorf("expected the planned name, got %#v", got)
+	}
+	if len(diagnostics) != 1 || diagnostics[0].Summary != "name normalized" {
+		t.Errorf("expected the plan diagnostics, got %v", diagnostics)
+	}
+	wantConfig := cty.ObjectVal(map[string]cty.Value{
+		"id":   cty.NullVal(cty.String),
+		"name": cty.StringVal("Web"),
+		"arn":  cty.NullVal(cty.String),
+	})
+	if !sentConfig.RawEquals(wantConfig) {
+		t.Errorf("expected config without read-only attributes %#v, got %#v", wantConfig, sentConfig)
+	}
+}
+
+func TestPlanChangeRejectsInvalidState(t *testing.T) {
+	provider := newFakeProviderWrapper(&fakeProvider{
+		schema: fakeSchema(map[string]*tfprotov5.Schema{"fake_resource": fakeResourceSchema("name")}),
+	})
+	_, _, err := provider.PlanChange(&terraform.InstanceInfo{Type: "fake_resource", Id: "fake_resource.r"},
+		cty.NullVal(cty.DynamicPseudoType),
+		cty.ObjectVal(map[string]cty.Value{"name": cty.ListValEmpty(cty.String)}))
+	if err == nil || !strings.Contains(err.Error(), "invalid proposed state of fake_resource.r") {
+		t.Errorf("expected a coercion error, got %v", err)
+	}
+}
+
+func TestProviderFromMirror(t *testing.T) {
+	t.Setenv("TF_DATA_DIR", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	mirror := t.TempDir()
+	t.Setenv(ProviderMirrorEnv, mirror)
+
+	if _, err := getProviderFileName("fake"); err == nil {
+		t.Fatal("expected no provider in an empty mirror")
+	}
+
+	platformDir := filepath.Join(mirror, "terraform.example.com", "acme", "fake", "1.2.0", runtime.GOOS+"_"+runtime.GOARCH)
+	if err := os.MkdirAll(platformDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	binary := filepath.Join(platformDir, "terraform-provider-fake_v1.2.0")
+	if err := os.WriteFile(binary, nil, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	// another provider of the mirror must not be picked
+	otherDir := filepath.Join(mirror, "registry.terraform.io", "hashicorp", "other", "1.0.0", runtime.GOOS+"_"+runtime.GOARCH)
+	if err := os.MkdirAll(otherDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(otherDir, "terraform-provider-other_v1.0.0"), nil, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := getProviderFileName("fake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != binary {
+		t.Errorf("expected %q, got %q", binary, found)
+	}
+}
+
+func TestTypeRefresherRejectsOtherTypes(t *testing.T) {
+	provider := newFakeProviderWrapper(echoProvider(map[string]*tfprotov5.Schema{
+		"fake_bucket": fakeResourceSchema("name"),
+		"fake_topic":  fakeResourceSchema("name"),
+	}))
+	refresher, err := provider.NewTypeRefresher("fake_bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err := refresher.Refresh(context.Background(), &terraform.InstanceInfo{Type: "fake_bucket", Id: "fake_bucket.b"}, &terraform.InstanceState{
+		ID:         "b-1",
+		Attributes: map[string]string{"name": "b"},
+	}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Attributes["name"] != "b" {
+		t.Errorf("expected the bucket to be read back, got %v", state.Attributes)
+	}
+	if _, err := refresher.Refresh(context.Background(), &terraform.InstanceInfo{Type: "fake_topic", Id: "fake_topic.t"}, &terraform.InstanceState{ID: "t-1"}, ""); err == nil {
+		t.Error("expected a topic not to be refreshed with the bucket refresher")
+	}
+	if _, err := provider.NewTypeRefresher("fake_queue"); err == nil {
+		t.Error("expected an unknown resource type to be rejected")
+	}
+}
+
+// BenchmarkRefreshByType compares refreshing resources of one wide type one
+// by one, computing the implied type each time, with a shared TypeRefresher.
+func BenchmarkRefreshByType(b *testing.B) {
+	var names []string
+	for i := 0; i < 50; i++ {
+		names = append(names, fmt.Sprintf("attribute_%d", i))
+	}
+	schema := fakeResourceSchema(names...)
+	for i := 0; i < 10; i++ {
+		schema.Block.BlockTypes = append(schema.Block.BlockTypes, &tfprotov5.SchemaNestedBlock{
+			TypeName: fmt.Sprintf("block_%d", i),
+			Nesting:  tfprotov5.SchemaNestedBlockNestingModeList,
+			Block:    fakeResourceSchema(names[:10]...).Block,
+		})
+	}
+	provider := newFakeProviderWrapper(echoProvider(map[string]*tfprotov5.Schema{"fake_bucket": schema}))
+	info := &terraform.InstanceInfo{Type: "fake_bucket", Id: "fake_bucket.b"}
+	state := &terraform.InstanceState{ID: "b-1", Attributes: map[string]string{"attribute_0": "b"}}
+
+	b.Run("per resource", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := provider.RefreshWithImportID(context.Background(), info, state, ""); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("by type", func(b *testing.B) {
+		refresher, err := provider.NewTypeRefresher("fake_bucket")
+		if err != nil {
+			b.Fatal(err)
+		}
+		for i := 0; i < b.N; i++ {
+			if _, err := refresher.Refresh(context.Background(), info, state, ""); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestRefreshWarnsOnSchemaVersionMismatch(t *testing.T) {
+	schema := fakeResourceSchema("name")
+	schema.Version = 2
+	provider := newFakeProviderWrapper(echoProvider(map[string]*tfprotov5.Schema{"fake_resource": schema}))
+	logger := &capturingLogger{}
+	WithLogger(logger)(provider)
+
+	version, err := provider.SchemaVersion("fake_resource")
+	if err != nil || version != 2 {
+		t.Fatalf("expected schema version 2, got %d, %v", version, err)
+	}
+	if _, err := provider.SchemaVersion("fake_queue"); err == nil {
+		t.Error("expected an unknown resource type to be rejected")
+	}
+
+	for _, meta := range []interface{}{2, "2", nil} {
+		logger.messages = nil
+		if _, err := provider.Refresh(&terraform.InstanceInfo{Type: "fake_resource", Id: "fake_resource.r"}, &terraform.InstanceState{
+			ID:   "r-1",
+			Meta: map[string]interface{}{"schema_version": meta},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if len(logger.messages) != 0 {
+			t.Errorf("expected no warning for schema version %v, got %q", meta, logger.messages)
+		}
+	}
+
+	logger.messages = nil
+	if _, err := provider.Refresh(&terraform.InstanceInfo{Type: "fake_resource", Id: "fake_resource.r"}, &terraform.InstanceState{
+		ID:   "r-1",
+		Meta: map[string]interface{}{"schema_version": "1"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"warn state of resource fake_resource.r has schema version 1 but provider fake uses version 2, it is read without being upgraded and attributes may be lost"}
+	if !reflect.DeepEqual(logger.messages, want) {
+		t.Errorf("expected messages %q, got %q", want, logger.messages)
+	}
+}
+
+func TestRefreshImportErrorIncludesDiagnostics(t *testing.T) {
+	provider := newFakeProviderWrapper(&fakeProvider{
+		schema: fakeSchema(map[string]*tfprotov5.Schema{"fake_resource": fakeResourceSchema("name")}),
+		readResource: func(req *tfprotov5.ReadResourceRequest) (*tfprotov5.ReadResourceResponse, error) {
+			return nil, errors.New("resource not found")
+		},
+		importResourceState: func(req *tfprotov5.ImportResourceStateRequest) (*tfprotov5.ImportResourceStateResponse, error) {
+			return &tfprotov5.ImportResourceStateResponse{Diagnostics: []*tfprotov5.Diagnostic{
+				{Severity: tfprotov5.DiagnosticSeverityWarning, Summary: "deprecated"},
+				{Severity: tfprotov5.DiagnosticSeverityError, Summary: "permission denied", Detail: "missing storage.buckets.get"},
+			}}, nil
+		},
+	})
+
+	_, err := provider.Refresh(&terraform.InstanceInfo{Type: "fake_resource", Id: "fake_resource.r"}, &terraform.InstanceState{ID: "r-1"})
+	if err == nil {
+		t.Fatal("expected the import to fail")
+	}
+	if !strings.Contains(err.Error(), "permission denied") || !strings.Contains(err.Error(), "missing storage.buckets.get") {
+		t.Errorf("expected the import diagnostics in the error, got %v", err)
+	}
+	if strings.Contains(err.Error(), "deprecated") {
+		t.Errorf("expected warnings to be left out of the error, got %v", err)
+	}
+}
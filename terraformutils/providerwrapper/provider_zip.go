@@ -0,0 +1,81 @@
+// Copyright 2018 The Terraformer Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providerwrapper //nolint
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// isProviderZip reports whether fileName is a provider package as downloaded
+// from the registry, e.g. terraform-provider-aws_4.0.0_linux_amd64.zip.
+func isProviderZip(fileName, providerName string) bool {
+	return strings.HasPrefix(fileName, "terraform-provider-"+providerName+"_") &&
+		strings.HasSuffix(fileName, "_"+runtime.GOOS+"_"+runtime.GOARCH+".zip")
+}
+
+// extractProviderZip extracts the provider binary of a registry package into
+// a new temporary directory, returning the binary path and the directory to
+// remove once the provider is done with.
+func extractProviderZip(zipPath, providerName string) (string, string, error) {
+	r, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return "", "", err
+	}
+	defer r.Close()
+	for _, f := range r.File {
+		name := filepath.Base(f.Name)
+		if f.FileInfo().IsDir() || !strings.HasPrefix(name, "terraform-provider-"+providerName) {
+			continue
+		}
+		dir, err := os.MkdirTemp("", "terraformer-provider-")
+		if err != nil {
+			return "", "", err
+		}
+		binaryPath := filepath.Join(dir, name)
+		if err := extractZipFile(f, binaryPath); err != nil {
+			os.RemoveAll(dir)
+			return "", "", fmt.Errorf("can't extract %s: %w", zipPath, err)
+		}
+		return binaryPath, dir, nil
+	}
+	return "", "", fmt.Errorf("no provider binary in %s", zipPath)
+}
+
+func extractZipFile(f *zip.File, dst string) error {
+	src, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o755)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, src); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	// keep the packaged mtime, so the schema cache stays valid across runs
+	return os.Chtimes(dst, f.Modified, f.Modified)
+}
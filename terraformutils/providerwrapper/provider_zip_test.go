@@ -0,0 +1,146 @@
+package providerwrapper //nolint
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-cty/cty"
+)
+
+// writeProviderZip packages the test binary, a fake provider plugin, the way
+// the registry ships providers.
+func writeProviderZip(t *testing.T, zipPath string) {
+	t.Helper()
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := os.Open(executable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	out, err := os.Create(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	w := zip.NewWriter(out)
+	header := &zip.FileHeader{Name: "terraform-provider-fake_v1.0.0", Method: zip.Store}
+	header.SetMode(0o755)
+	dst, err := w.CreateHeader(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// installFakePluginZip is like installFakePlugin, but only leaves the fake
+// provider around as a registry package, returning the record file and the
+// package path.
+func installFakePluginZip(t *testing.T) (string, string) {
+	t.Helper()
+	record := installFakePlugin(t)
+	dataDir := filepath.Dir(record)
+	if err := os.RemoveAll(filepath.Join(dataDir, "plugins")); err != nil {
+		t.Fatal(err)
+	}
+	packageDir := filepath.Join(dataDir, "plugins", "registry.terraform.io", "hashicorp", "fake")
+	if err := os.MkdirAll(packageDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	zipPath := filepath.Join(packageDir, "terraform-provider-fake_1.0.0_"+runtime.GOOS+"_"+runtime.GOARCH+".zip")
+	writeProviderZip(t, zipPath)
+	return record, zipPath
+}
+
+func TestProviderFromZip(t *testing.T) {
+	_, zipPath := installFakePluginZip(t)
+
+	found, err := getProviderFileName("fake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != zipPath {
+		t.Fatalf("expected the zip to be found, got %q", found)
+	}
+
+	p, err := NewProviderWrapper("fake", cty.EmptyObjectVal, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	extracted := p.providerFilePath
+	if strings.HasSuffix(extracted, ".zip") || filepath.Base(extracted) != "terraform-provider-fake_v1.0.0" {
+		t.Errorf("expected an extracted binary, got %q", extracted)
+	}
+	if _, err := p.GetSchema(); err != nil {
+		t.Errorf("expected the extracted provider to serve its schema, got %v", err)
+	}
+
+	p.Kill()
+	if _, err := os.Stat(extracted); !os.IsNotExist(err) {
+		t.Errorf("expected the extracted binary to be removed, got %v", err)
+	}
+}
+
+func TestFailedStartupRemovesExtractedProvider(t *testing.T) {
+	installFakePluginZip(t)
+	t.Setenv(fakePluginCrashEnv, "crashing after extraction")
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+
+	p, err := NewProviderWrapper("fake", cty.EmptyObjectVal, false)
+	if err == nil {
+		p.Kill()
+		t.Fatal("expected the provider startup to fail")
+	}
+	if p.extractedDir != "" {
+		t.Errorf("expected the extraction directory to be forgotten, got %q", p.extractedDir)
+	}
+	entries, err := os.ReadDir(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected the extracted provider to be removed, found %v", entries)
+	}
+}
+
+func TestCloseReleasesExtractedProvider(t *testing.T) {
+	record, _ := installFakePluginZip(t)
+
+	p, err := NewProviderWrapper("fake", cty.EmptyObjectVal, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	extractedDir := filepath.Dir(p.providerFilePath)
+
+	for i := 0; i < 2; i++ {
+		if err := p.Close(); err != nil {
+			t.Fatalf("close %d: %v", i+1, err)
+		}
+	}
+	if !p.client.Exited() {
+		t.Error("expected the plugin process to have exited")
+	}
+	if _, err := os.Stat(extractedDir); !os.IsNotExist(err) {
+		t.Errorf("expected the extraction directory to be removed, got %v", err)
+	}
+	calls, err := os.ReadFile(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(string(calls), "stop"); got != 1 {
+		t.Errorf("expected the provider to be stopped once, got %d stops", got)
+	}
+}
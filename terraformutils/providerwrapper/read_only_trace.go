@@ -0,0 +1,54 @@
+// Copyright 2018 The Terraformer Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providerwrapper //nolint
+
+// ReadOnlyTraceEntry records how GetReadOnlyAttributes classified one
+// attribute, or one nested block whose attributes are all read-only.
+type ReadOnlyTraceEntry struct {
+	// ResourceType is the resource the attribute belongs to.
+	ResourceType string
+	// Path is the schema path of the attribute, the names of its enclosing
+	// nested blocks and its own joined by dots, e.g. rule.port.
+	Path string
+	// ReadOnly tells whether the attribute is ignored.
+	ReadOnly bool
+	// Pattern is the regular expression generated for a read-only attribute,
+	// matched against flatmapped attribute names. It is empty otherwise.
+	Pattern string
+}
+
+// WithReadOnlyTrace passes every classification GetReadOnlyAttributes makes
+// to trace, to debug why an attribute is or isn't ignored.
+func WithReadOnlyTrace(trace func(ReadOnlyTraceEntry)) ProviderOption {
+	return func(p *ProviderWrapper) {
+		p.readOnlyTrace = trace
+	}
+}
+
+// readOnlyTracer returns the trace callback for resourceType, a no-op when
+// tracing isn't enabled.
+func (p *ProviderWrapper) readOnlyTracer(resourceType string) func(path string, pattern string) {
+	if p.readOnlyTrace == nil {
+		return func(string, string) {}
+	}
+	return func(path string, pattern string) {
+		p.readOnlyTrace(ReadOnlyTraceEntry{
+			ResourceType: resourceType,
+			Path:         path,
+			ReadOnly:     pattern != "",
+			Pattern:      pattern,
+		})
+	}
+}
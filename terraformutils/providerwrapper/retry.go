@@ -0,0 +1,111 @@
+// Copyright 2018 The Terraformer Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providerwrapper //nolint
+
+import (
+	"sync/atomic"
+
+	"github.com/GoogleCloudPlatform/terraformer/terraformutils/tfplugin/stoleninternal/configschema"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov5"
+)
+
+// RetryClassifier decides whether a failed read is worth retrying, given the
+// error and the diagnostics the provider returned. Reads it rejects go
+// straight to the import fallback.
+type RetryClassifier func(err error, diagnostics []*tfprotov5.Diagnostic) bool
+
+// DefaultRetryClassifier retries throttled and transiently failed reads but
+// not those rejected for missing permissions or a missing resource, as
+// categorized by configschema.CategorizeDiagnostic from the error
+// diagnostics. The message of err is categorized instead when the
+// diagnostics don't tell, e.g. for transport errors. Uncategorized failures
+// are retried. Custom classifiers can fall back to it for the cases they
+// don't handle.
+func DefaultRetryClassifier(err error, diagnostics []*tfprotov5.Diagnostic) bool {
+	category := configschema.WrapDiagnostics(diagnostics).Category()
+	if category == configschema.DiagnosticUncategorized && err != nil {
+		category = configschema.CategorizeMessage(err.Error())
+	}
+	switch category {
+	case configschema.DiagnosticAuth, configschema.DiagnosticNotFound:
+		return false
+	}
+	return true
+}
+
+// WithRetryClassifier replaces DefaultRetryClassifier to decide which failed
+// reads are retried.
+func WithRetryClassifier(classifier RetryClassifier) ProviderOption {
+	return func(p *ProviderWrapper) {
+		p.retryClassifier = classifier
+	}
+}
+
+func (p *ProviderWrapper) isRetryable(err error, diagnostics []*tfprotov5.Diagnostic) bool {
+	if p.retryClassifier == nil {
+		return DefaultRetryClassifier(err, diagnostics)
+	}
+	return p.retryClassifier(err, diagnostics)
+}
+
+// RetryConfig sets how many times a failed read is retried and how long to
+// wait between attempts, in milliseconds.
+type RetryConfig struct {
+	Count   int
+	SleepMs int
+}
+
+// WithResourceRetries overrides the retries set by WithRetries for the
+// resource types in retries, for types whose reads fail more, or less, often
+// than the rest of the provider's.
+func WithResourceRetries(retries map[string]RetryConfig) ProviderOption {
+	return func(p *ProviderWrapper) {
+		if p.resourceRetries == nil {
+			p.resourceRetries = map[string]RetryConfig{}
+		}
+		for resourceType, retry := range retries {
+			p.resourceRetries[resourceType] = retry
+		}
+	}
+}
+
+// retryConfig returns the retries for resourceType, the wrapper defaults when
+// the type has no override.
+func (p *ProviderWrapper) retryConfig(resourceType string) RetryConfig {
+	if retry, ok := p.resourceRetries[resourceType]; ok {
+		return retry
+	}
+	return RetryConfig{Count: p.retryCount, SleepMs: p.retrySleepMs}
+}
+
+// WithRetryBudget caps the retries of failed reads across every Refresh of
+// the wrapper to retries in total. Once they are used up, failed reads go
+// straight to the import fallback, so a throttled account doesn't multiply
+// its API calls by the retry count of every resource.
+func WithRetryBudget(retries int) ProviderOption {
+	return func(p *ProviderWrapper) {
+		budget := int64(retries)
+		p.retryBudget = &budget
+	}
+}
+
+// takeRetry uses up one retry of the budget, and tells whether there was one
+// left. Without a budget retries are unlimited.
+func (p *ProviderWrapper) takeRetry() bool {
+	if p.retryBudget == nil {
+		return true
+	}
+	return atomic.AddInt64(p.retryBudget, -1) >= 0
+}
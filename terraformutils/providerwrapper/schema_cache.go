@@ -0,0 +1,176 @@
+// Copyright 2018 The Terraformer Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providerwrapper //nolint
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/terraformer/terraformutils/tfplugin/stoleninternal/fromproto"
+	"github.com/GoogleCloudPlatform/terraformer/terraformutils/tfplugin/stoleninternal/tfplugin5"
+	"github.com/GoogleCloudPlatform/terraformer/terraformutils/tfplugin/stoleninternal/toproto"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov5"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// schemaCacheEntry is the on-disk form of a cached provider schema. The
+// schema is stored in its protocol JSON encoding, since tftypes don't
+// round-trip through encoding/json.
+type schemaCacheEntry struct {
+	ProviderVersion string          `json:"provider_version"`
+	BinaryModTime   int64           `json:"binary_mod_time"`
+	Schema          json.RawMessage `json:"schema"`
+}
+
+// sharedSchemas holds the schemas of the wrappers created with
+// WithSharedSchemaCache, keyed by sharedSchemaKey.
+var sharedSchemas = struct {
+	sync.Mutex
+	schemas map[string]*tfprotov5.GetProviderSchemaResponse
+}{schemas: map[string]*tfprotov5.GetProviderSchemaResponse{}}
+
+// sharedSchemaKey identifies the schema of a provider binary by provider name
+// and version, or by its path when its name carries no version. Providers
+// that aren't launched from a binary have no key.
+func sharedSchemaKey(providerName, binaryPath string) string {
+	if binaryPath == "" {
+		return ""
+	}
+	if version := providerBinaryVersion(binaryPath); version != "" {
+		return providerName + "@" + version
+	}
+	return providerName + "@" + binaryPath
+}
+
+func loadSharedSchema(key string) (*tfprotov5.GetProviderSchemaResponse, bool) {
+	sharedSchemas.Lock()
+	defer sharedSchemas.Unlock()
+	schema, ok := sharedSchemas.schemas[key]
+	return schema, ok
+}
+
+// storeSharedSchema shares schema under key and returns the schema shared
+// under key, which is another one when a wrapper stored it first.
+func storeSharedSchema(key string, schema *tfprotov5.GetProviderSchemaResponse) *tfprotov5.GetProviderSchemaResponse {
+	sharedSchemas.Lock()
+	defer sharedSchemas.Unlock()
+	if shared, ok := sharedSchemas.schemas[key]; ok {
+		return shared
+	}
+	sharedSchemas.schemas[key] = schema
+	return schema
+}
+
+// providerBinaryVersion returns the version embedded in a provider binary
+// name such as terraform-provider-aws_v4.0.0_x5, or "" when there is none.
+func providerBinaryVersion(binaryPath string) string {
+	parts := strings.Split(filepath.Base(binaryPath), "_")
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[1]
+}
+
+func schemaCachePath(dir, providerName, binaryPath string) string {
+	name := providerName
+	if version := providerBinaryVersion(binaryPath); version != "" {
+		name += "_" + version
+	}
+	return filepath.Join(dir, name+".json")
+}
+
+// loadCachedSchema returns the schema cached for the provider binary, if the
+// cache entry was written for the same version and binary mtime.
+func loadCachedSchema(dir, providerName, binaryPath string) (*tfprotov5.GetProviderSchemaResponse, bool) {
+	resp, ok := loadCachedSchemaResponse(dir, providerName, binaryPath)
+	if !ok {
+		return nil, false
+	}
+	schema, err := fromproto.GetProviderSchemaResponse(resp)
+	if err != nil {
+		return nil, false
+	}
+	return schema, true
+}
+
+// loadCachedResourceSchema returns the schema of resourceType from the
+// schema cached for the provider binary, converting only that type.
+func loadCachedResourceSchema(dir, providerName, binaryPath, resourceType string) (*tfprotov5.Schema, bool) {
+	resp, ok := loadCachedSchemaResponse(dir, providerName, binaryPath)
+	if !ok || resp.ResourceSchemas[resourceType] == nil {
+		return nil, false
+	}
+	schema, err := fromproto.Schema(resp.ResourceSchemas[resourceType])
+	if err != nil {
+		return nil, false
+	}
+	return schema, true
+}
+
+// loadCachedSchemaResponse returns the cached schema in its protocol form,
+// whose types are yet to be parsed.
+func loadCachedSchemaResponse(dir, providerName, binaryPath string) (*tfplugin5.GetProviderSchema_Response, bool) {
+	info, err := os.Stat(binaryPath)
+	if err != nil {
+		return nil, false
+	}
+	data, err := os.ReadFile(schemaCachePath(dir, providerName, binaryPath))
+	if err != nil {
+		return nil, false
+	}
+	var entry schemaCacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, false
+	}
+	if entry.ProviderVersion != providerBinaryVersion(binaryPath) || entry.BinaryModTime != info.ModTime().UnixNano() {
+		return nil, false
+	}
+	var resp tfplugin5.GetProviderSchema_Response
+	if err := protojson.Unmarshal(entry.Schema, &resp); err != nil {
+		return nil, false
+	}
+	return &resp, true
+}
+
+// storeCachedSchema writes schema to the cache for the provider binary.
+func storeCachedSchema(dir, providerName, binaryPath string, schema *tfprotov5.GetProviderSchemaResponse) error {
+	info, err := os.Stat(binaryPath)
+	if err != nil {
+		return err
+	}
+	resp, err := toproto.GetProviderSchema_Response(schema)
+	if err != nil {
+		return err
+	}
+	encoded, err := protojson.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(schemaCacheEntry{
+		ProviderVersion: providerBinaryVersion(binaryPath),
+		BinaryModTime:   info.ModTime().UnixNano(),
+		Schema:          encoded,
+	})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	return os.WriteFile(schemaCachePath(dir, providerName, binaryPath), data, 0o600)
+}
@@ -0,0 +1,130 @@
+package providerwrapper //nolint
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-go/tfprotov5"
+)
+
+func writeFakeBinary(t *testing.T, path string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte("binary"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSchemaCache(t *testing.T) {
+	dir := t.TempDir()
+	cacheDir := filepath.Join(dir, "cache")
+	binary := filepath.Join(dir, "terraform-provider-fake_v1.0.0")
+	writeFakeBinary(t, binary)
+
+	calls := 0
+	f := &fakeProvider{getProviderSchema: func() (*tfprotov5.GetProviderSchemaResponse, error) {
+		calls++
+		schema := fakeSchema(map[string]*tfprotov5.Schema{"fake_resource": fakeResourceSchema("name")})
+		schema.ServerCapabilities = &tfprotov5.ServerCapabilities{PlanDestroy: true}
+		return schema, nil
+	}}
+	newWrapper := func(binary string) *ProviderWrapper {
+		p := newFakeProviderWrapper(f)
+		WithSchemaCacheDir(cacheDir)(p)
+		p.providerFilePath = binary
+		return p
+	}
+
+	// write
+	if _, err := newWrapper(binary).GetSchema(); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected the provider to be asked once, got %d", calls)
+	}
+
+	// hit
+	schema, err := newWrapper(binary).GetSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Errorf("expected the cached schema to be used, provider asked %d times", calls)
+	}
+	attrs := schema.ResourceSchemas["fake_resource"].Block.Attributes
+	if len(attrs) != 2 || attrs[1].Name != "name" {
+		t.Errorf("expected the cached schema to round-trip, got %v", attrs)
+	}
+	if schema.ServerCapabilities == nil || !schema.ServerCapabilities.PlanDestroy {
+		t.Errorf("expected the cached server capabilities to round-trip, got %v", schema.ServerCapabilities)
+	}
+
+	// invalidation on version change
+	upgraded := filepath.Join(dir, "terraform-provider-fake_v1.1.0")
+	writeFakeBinary(t, upgraded)
+	if _, err := newWrapper(upgraded).GetSchema(); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Errorf("expected a new version to miss the cache, provider asked %d times", calls)
+	}
+
+	// invalidation on binary change
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(binary, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newWrapper(binary).GetSchema(); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 {
+		t.Errorf("expected a rebuilt binary to miss the cache, provider asked %d times", calls)
+	}
+}
+
+func TestSharedSchemaCache(t *testing.T) {
+	resetSharedSchemas := func() {
+		sharedSchemas.Lock()
+		defer sharedSchemas.Unlock()
+		sharedSchemas.schemas = map[string]*tfprotov5.GetProviderSchemaResponse{}
+	}
+	resetSharedSchemas()
+	t.Cleanup(resetSharedSchemas)
+	calls := 0
+	f := &fakeProvider{getProviderSchema: func() (*tfprotov5.GetProviderSchemaResponse, error) {
+		calls++
+		return fakeSchema(map[string]*tfprotov5.Schema{"fake_resource": fakeResourceSchema("name")}), nil
+	}}
+	newWrapper := func(binary string, options ...ProviderOption) *ProviderWrapper {
+		p := newFakeProviderWrapper(f)
+		p.providerName = "fake-shared"
+		p.providerFilePath = binary
+		for _, option := range options {
+			option(p)
+		}
+		return p
+	}
+
+	first, err := newWrapper("/plugins/terraform-provider-fake-shared_v1.0.0", WithSharedSchemaCache()).GetSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := newWrapper("/other/terraform-provider-fake-shared_v1.0.0", WithSharedSchemaCache()).GetSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second || calls != 1 {
+		t.Errorf("expected the wrappers to share one schema, provider asked %d times", calls)
+	}
+
+	if other, _ := newWrapper("/plugins/terraform-provider-fake-shared_v1.1.0", WithSharedSchemaCache()).GetSchema(); other == first {
+		t.Error("expected another version not to share the schema")
+	}
+	if own, _ := newWrapper("/plugins/terraform-provider-fake-shared_v1.0.0").GetSchema(); own == first {
+		t.Error("expected a wrapper without the option to keep its own schema")
+	}
+	if calls != 3 {
+		t.Errorf("expected the provider to be asked 3 times, got %d", calls)
+	}
+}
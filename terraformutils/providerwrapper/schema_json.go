@@ -0,0 +1,181 @@
+// Copyright 2018 The Terraformer Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providerwrapper //nolint
+
+import (
+	encjson "encoding/json"
+	"fmt"
+
+	"github.com/hashicorp/terraform-plugin-go/tfprotov5"
+)
+
+// The types below mirror the JSON emitted by terraform providers schema -json,
+// from github.com/hashicorp/terraform/internal/command/jsonprovider.
+
+type jsonProviders struct {
+	FormatVersion string                         `json:"format_version"`
+	Schemas       map[string]*jsonProviderSchema `json:"provider_schemas,omitempty"`
+}
+
+type jsonProviderSchema struct {
+	Provider          *jsonSchema            `json:"provider,omitempty"`
+	ResourceSchemas   map[string]*jsonSchema `json:"resource_schemas,omitempty"`
+	DataSourceSchemas map[string]*jsonSchema `json:"data_source_schemas,omitempty"`
+}
+
+type jsonSchema struct {
+	Version int64      `json:"version"`
+	Block   *jsonBlock `json:"block,omitempty"`
+}
+
+type jsonBlock struct {
+	Attributes      map[string]*jsonAttribute   `json:"attributes,omitempty"`
+	BlockTypes      map[string]*jsonNestedBlock `json:"block_types,omitempty"`
+	Description     string                      `json:"description,omitempty"`
+	DescriptionKind string                      `json:"description_kind,omitempty"`
+	Deprecated      bool                        `json:"deprecated,omitempty"`
+}
+
+type jsonAttribute struct {
+	AttributeType   encjson.RawMessage `json:"type,omitempty"`
+	Description     string             `json:"description,omitempty"`
+	DescriptionKind string             `json:"description_kind,omitempty"`
+	Deprecated      bool               `json:"deprecated,omitempty"`
+	Required        bool               `json:"required,omitempty"`
+	Optional        bool               `json:"optional,omitempty"`
+	Computed        bool               `json:"computed,omitempty"`
+	Sensitive       bool               `json:"sensitive,omitempty"`
+}
+
+type jsonNestedBlock struct {
+	Block       *jsonBlock `json:"block,omitempty"`
+	NestingMode string     `json:"nesting_mode,omitempty"`
+	MinItems    int64      `json:"min_items,omitempty"`
+	MaxItems    int64      `json:"max_items,omitempty"`
+}
+
+// ExportSchemaJSON serializes the provider schema the way terraform providers
+// schema -json does, so it can be diffed against terraform's view of the same
+// provider. The provider is keyed by source, its host/namespace/type address
+// as returned by terraformutils.ProviderSource, or else as
+// registry.terraform.io/hashicorp/<name>.
+func (p *ProviderWrapper) ExportSchemaJSON(source string) ([]byte, error) {
+	schema, err := p.GetSchema()
+	if err != nil {
+		return nil, err
+	}
+	providerSchema := &jsonProviderSchema{
+		Provider:          marshalJSONSchema(schema.Provider),
+		ResourceSchemas:   marshalJSONSchemas(schema.ResourceSchemas),
+		DataSourceSchemas: marshalJSONSchemas(schema.DataSourceSchemas),
+	}
+	if providerSchema.Provider == nil {
+		return nil, fmt.Errorf("provider %s returned no provider schema", p.providerName)
+	}
+	if source == "" {
+		source = "registry.terraform.io/hashicorp/" + p.providerName
+	}
+	return encjson.Marshal(jsonProviders{
+		FormatVersion: "1.0",
+		Schemas:       map[string]*jsonProviderSchema{source: providerSchema},
+	})
+}
+
+func marshalJSONSchemas(schemas map[string]*tfprotov5.Schema) map[string]*jsonSchema {
+	if len(schemas) == 0 {
+		return nil
+	}
+	ret := make(map[string]*jsonSchema, len(schemas))
+	for name, schema := range schemas {
+		ret[name] = marshalJSONSchema(schema)
+	}
+	return ret
+}
+
+func marshalJSONSchema(schema *tfprotov5.Schema) *jsonSchema {
+	if schema == nil {
+		return nil
+	}
+	return &jsonSchema{
+		Version: schema.Version,
+		Block:   marshalJSONBlock(schema.Block),
+	}
+}
+
+func marshalJSONBlock(block *tfprotov5.SchemaBlock) *jsonBlock {
+	if block == nil {
+		return &jsonBlock{}
+	}
+	ret := &jsonBlock{
+		Description:     block.Description,
+		DescriptionKind: marshalJSONStringKind(block.DescriptionKind),
+		Deprecated:      block.Deprecated,
+	}
+	if len(block.Attributes) > 0 {
+		ret.Attributes = make(map[string]*jsonAttribute, len(block.Attributes))
+		for _, attr := range block.Attributes {
+			jsonAttr := &jsonAttribute{
+				Description:     attr.Description,
+				DescriptionKind: marshalJSONStringKind(attr.DescriptionKind),
+				Deprecated:      attr.Deprecated,
+				Required:        attr.Required,
+				Optional:        attr.Optional,
+				Computed:        attr.Computed,
+				Sensitive:       attr.Sensitive,
+			}
+			if attr.Type != nil {
+				if ty, err := attr.Type.MarshalJSON(); err == nil {
+					jsonAttr.AttributeType = ty
+				}
+			}
+			ret.Attributes[attr.Name] = jsonAttr
+		}
+	}
+	if len(block.BlockTypes) > 0 {
+		ret.BlockTypes = make(map[string]*jsonNestedBlock, len(block.BlockTypes))
+		for _, nested := range block.BlockTypes {
+			ret.BlockTypes[nested.TypeName] = &jsonNestedBlock{
+				Block:       marshalJSONBlock(nested.Block),
+				NestingMode: marshalJSONNestingMode(nested.Nesting),
+				MinItems:    nested.MinItems,
+				MaxItems:    nested.MaxItems,
+			}
+		}
+	}
+	return ret
+}
+
+func marshalJSONStringKind(kind tfprotov5.StringKind) string {
+	if kind == tfprotov5.StringKindMarkdown {
+		return "markdown"
+	}
+	return "plain"
+}
+
+func marshalJSONNestingMode(nesting tfprotov5.SchemaNestedBlockNestingMode) string {
+	switch nesting {
+	case tfprotov5.SchemaNestedBlockNestingModeSingle:
+		return "single"
+	case tfprotov5.SchemaNestedBlockNestingModeGroup:
+		return "group"
+	case tfprotov5.SchemaNestedBlockNestingModeList:
+		return "list"
+	case tfprotov5.SchemaNestedBlockNestingModeSet:
+		return "set"
+	case tfprotov5.SchemaNestedBlockNestingModeMap:
+		return "map"
+	}
+	return ""
+}
@@ -0,0 +1,89 @@
+package providerwrapper //nolint
+
+import (
+	"bytes"
+	encjson "encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-go/tfprotov5"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+)
+
+func TestExportSchemaJSON(t *testing.T) {
+	provider := newFakeProviderWrapper(&fakeProvider{schema: &tfprotov5.GetProviderSchemaResponse{
+		Provider: &tfprotov5.Schema{Block: &tfprotov5.SchemaBlock{
+			Attributes: []*tfprotov5.SchemaAttribute{
+				{Name: "region", Type: tftypes.String, Optional: true, Description: "The region."},
+				{Name: "token", Type: tftypes.String, Optional: true, Sensitive: true},
+			},
+		}},
+		ResourceSchemas: map[string]*tfprotov5.Schema{
+			"fake_instance": {
+				Version: 2,
+				Block: &tfprotov5.SchemaBlock{
+					Description:     "An **instance**.",
+					DescriptionKind: tfprotov5.StringKindMarkdown,
+					Attributes: []*tfprotov5.SchemaAttribute{
+						{Name: "id", Type: tftypes.String, Optional: true, Computed: true},
+						{Name: "name", Type: tftypes.String, Required: true},
+						{Name: "tags", Type: tftypes.Map{ElementType: tftypes.String}, Optional: true},
+						{Name: "legacy", Type: tftypes.Bool, Optional: true, Deprecated: true},
+					},
+					BlockTypes: []*tfprotov5.SchemaNestedBlock{
+						{TypeName: "boot_disk", Nesting: tfprotov5.SchemaNestedBlockNestingModeSingle, Block: &tfprotov5.SchemaBlock{
+							Attributes: []*tfprotov5.SchemaAttribute{{Name: "size", Type: tftypes.Number, Optional: true}},
+						}},
+						{TypeName: "network", Nesting: tfprotov5.SchemaNestedBlockNestingModeList, MinItems: 1, MaxItems: 2, Block: &tfprotov5.SchemaBlock{
+							Attributes: []*tfprotov5.SchemaAttribute{{Name: "ips", Type: tftypes.List{ElementType: tftypes.String}, Computed: true}},
+						}},
+						{TypeName: "rule", Nesting: tfprotov5.SchemaNestedBlockNestingModeSet, Block: &tfprotov5.SchemaBlock{}},
+						{TypeName: "disk", Nesting: tfprotov5.SchemaNestedBlockNestingModeMap, Block: &tfprotov5.SchemaBlock{}},
+						{TypeName: "timeouts", Nesting: tfprotov5.SchemaNestedBlockNestingModeGroup, Block: &tfprotov5.SchemaBlock{}},
+					},
+				},
+			},
+		},
+		DataSourceSchemas: map[string]*tfprotov5.Schema{
+			"fake_image": {Block: &tfprotov5.SchemaBlock{
+				Attributes: []*tfprotov5.SchemaAttribute{
+					{Name: "labels", Type: tftypes.Object{AttributeTypes: map[string]tftypes.Type{"os": tftypes.String}}, Computed: true},
+				},
+			}},
+		},
+	}})
+
+	got, err := provider.ExportSchemaJSON("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var indented bytes.Buffer
+	if err := encjson.Indent(&indented, got, "", "  "); err != nil {
+		t.Fatal(err)
+	}
+	indented.WriteByte('\n')
+	want, err := os.ReadFile(filepath.Join("testdata", "schema.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(indented.Bytes(), want) {
+		t.Errorf("schema JSON differs from testdata/schema.json, got:\n%s", indented.Bytes())
+	}
+}
+
+func TestExportSchemaJSONSource(t *testing.T) {
+	provider := newFakeProviderWrapper(&fakeProvider{schema: fakeSchema(nil)})
+
+	got, err := provider.ExportSchemaJSON("registry.terraform.io/datadog/datadog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var exported jsonProviders
+	if err := encjson.Unmarshal(got, &exported); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := exported.Schemas["registry.terraform.io/datadog/datadog"]; !ok || len(exported.Schemas) != 1 {
+		t.Errorf("expected the provider keyed by its source, got %s", got)
+	}
+}
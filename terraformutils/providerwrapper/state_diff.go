@@ -0,0 +1,158 @@
+// Copyright 2018 The Terraformer Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providerwrapper //nolint
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/go-cty/cty/json"
+)
+
+// DiffStates explains how refreshed differs from prior, as one
+// "path = old -> new" line per changed value, with values rendered as JSON.
+// Objects, maps, lists and tuples are compared element by element, so a
+// reordered list shows up as changes at each moved index; sets are compared
+// as a whole. Values missing on one side are rendered as (absent). Unknown
+// values can't be rendered and make DiffStates fail.
+func DiffStates(prior, refreshed cty.Value) ([]string, error) {
+	var changes []string
+	if err := diffValues(nil, &prior, &refreshed, &changes); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// diffValues appends the changes between prior and refreshed to changes; a
+// nil prior or refreshed means the value doesn't exist on that side.
+func diffValues(path cty.Path, prior, refreshed *cty.Value, changes *[]string) error {
+	if prior != nil && refreshed != nil {
+		if prior.RawEquals(*refreshed) {
+			return nil
+		}
+		if prior.IsKnown() && refreshed.IsKnown() && !prior.IsNull() && !refreshed.IsNull() {
+			priorTy, refreshedTy := prior.Type(), refreshed.Type()
+			switch {
+			case priorTy.IsObjectType() && refreshedTy.IsObjectType():
+				return diffElements(path, prior.AsValueMap(), refreshed.AsValueMap(), true, changes)
+			case priorTy.IsMapType() && refreshedTy.IsMapType():
+				return diffElements(path, prior.AsValueMap(), refreshed.AsValueMap(), false, changes)
+			case (priorTy.IsListType() || priorTy.IsTupleType()) && (refreshedTy.IsListType() || refreshedTy.IsTupleType()):
+				return diffSequences(path, prior.AsValueSlice(), refreshed.AsValueSlice(), changes)
+			}
+		}
+	}
+	priorText, err := renderDiffValue(prior)
+	if err != nil {
+		return fmt.Errorf("%s: %w", formatDiffPath(path), err)
+	}
+	refreshedText, err := renderDiffValue(refreshed)
+	if err != nil {
+		return fmt.Errorf("%s: %w", formatDiffPath(path), err)
+	}
+	*changes = append(*changes, fmt.Sprintf("%s = %s -> %s", formatDiffPath(path), priorText, refreshedText))
+	return nil
+}
+
+// diffElements diffs the attributes of objects, or the elements of maps.
+func diffElements(path cty.Path, prior, refreshed map[string]cty.Value, attributes bool, changes *[]string) error {
+	keys := make([]string, 0, len(prior)+len(refreshed))
+	for key := range prior {
+		keys = append(keys, key)
+	}
+	for key := range refreshed {
+		if _, ok := prior[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		var priorVal, refreshedVal *cty.Value
+		if val, ok := prior[key]; ok {
+			priorVal = &val
+		}
+		if val, ok := refreshed[key]; ok {
+			refreshedVal = &val
+		}
+		var step cty.PathStep = cty.IndexStep{Key: cty.StringVal(key)}
+		if attributes {
+			step = cty.GetAttrStep{Name: key}
+		}
+		if err := diffValues(append(path.Copy(), step), priorVal, refreshedVal, changes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func diffSequences(path cty.Path, prior, refreshed []cty.Value, changes *[]string) error {
+	l := len(prior)
+	if len(refreshed) > l {
+		l = len(refreshed)
+	}
+	for i := 0; i < l; i++ {
+		var priorVal, refreshedVal *cty.Value
+		if i < len(prior) {
+			priorVal = &prior[i]
+		}
+		if i < len(refreshed) {
+			refreshedVal = &refreshed[i]
+		}
+		if err := diffValues(append(path.Copy(), cty.IndexStep{Key: cty.NumberIntVal(int64(i))}), priorVal, refreshedVal, changes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func renderDiffValue(val *cty.Value) (string, error) {
+	switch {
+	case val == nil:
+		return "(absent)", nil
+	case val.IsNull():
+		return "null", nil
+	case !val.IsWhollyKnown():
+		return "", fmt.Errorf("can't diff unknown value")
+	}
+	b, err := json.Marshal(*val, val.Type())
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// formatDiffPath renders path the way attributes are addressed in HCL, e.g.
+// rule[0].name or tags["env"].
+func formatDiffPath(path cty.Path) string {
+	if len(path) == 0 {
+		return "(root)"
+	}
+	var sb strings.Builder
+	for _, step := range path {
+		switch step := step.(type) {
+		case cty.GetAttrStep:
+			if sb.Len() > 0 {
+				sb.WriteByte('.')
+			}
+			sb.WriteString(step.Name)
+		case cty.IndexStep:
+			key, _ := json.Marshal(step.Key, step.Key.Type())
+			fmt.Fprintf(&sb, "[%s]", key)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,110 @@
+package providerwrapper //nolint
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/go-cty/cty"
+)
+
+func TestDiffStates(t *testing.T) {
+	rule := func(name string, port int64) cty.Value {
+		return cty.ObjectVal(map[string]cty.Value{"name": cty.StringVal(name), "port": cty.NumberIntVal(port)})
+	}
+	for _, test := range []struct {
+		name      string
+		prior     cty.Value
+		refreshed cty.Value
+		want      []string
+	}{
+		{
+			"equal",
+			cty.ObjectVal(map[string]cty.Value{"name": cty.StringVal("a")}),
+			cty.ObjectVal(map[string]cty.Value{"name": cty.StringVal("a")}),
+			nil,
+		},
+		{
+			"nested block change",
+			cty.ObjectVal(map[string]cty.Value{
+				"name": cty.StringVal("fw"),
+				"rule": cty.ListVal([]cty.Value{rule("ssh", 22)}),
+			}),
+			cty.ObjectVal(map[string]cty.Value{
+				"name": cty.StringVal("fw"),
+				"rule": cty.ListVal([]cty.Value{rule("ssh", 2222)}),
+			}),
+			[]string{"rule[0].port = 22 -> 2222"},
+		},
+		{
+			"list reordering",
+			cty.ObjectVal(map[string]cty.Value{
+				"zones": cty.ListVal([]cty.Value{cty.StringVal("a"), cty.StringVal("b"), cty.StringVal("c")}),
+			}),
+			cty.ObjectVal(map[string]cty.Value{
+				"zones": cty.ListVal([]cty.Value{cty.StringVal("b"), cty.StringVal("a")}),
+			}),
+			[]string{
+				`zones[0] = "a" -> "b"`,
+				`zones[1] = "b" -> "a"`,
+				`zones[2] = "c" -> (absent)`,
+			},
+		},
+		{
+			"block added and map keys",
+			cty.ObjectVal(map[string]cty.Value{
+				"rule": cty.ListValEmpty(rule("", 0).Type()),
+				"tags": cty.MapVal(map[string]cty.Value{"env": cty.StringVal("dev"), "team x": cty.StringVal("a")}),
+			}),
+			cty.ObjectVal(map[string]cty.Value{
+				"rule": cty.ListVal([]cty.Value{rule("http", 80)}),
+				"tags": cty.MapVal(map[string]cty.Value{"env": cty.StringVal("prod")}),
+			}),
+			[]string{
+				`rule[0] = (absent) -> {"name":"http","port":80}`,
+				`tags["env"] = "dev" -> "prod"`,
+				`tags["team x"] = "a" -> (absent)`,
+			},
+		},
+		{
+			"null and sets",
+			cty.ObjectVal(map[string]cty.Value{
+				"ids":  cty.SetVal([]cty.Value{cty.StringVal("a")}),
+				"note": cty.NullVal(cty.String),
+			}),
+			cty.ObjectVal(map[string]cty.Value{
+				"ids":  cty.SetVal([]cty.Value{cty.StringVal("a"), cty.StringVal("b")}),
+				"note": cty.StringVal("x"),
+			}),
+			[]string{
+				`ids = ["a"] -> ["a","b"]`,
+				`note = null -> "x"`,
+			},
+		},
+		{
+			"root",
+			cty.StringVal("a"),
+			cty.StringVal("b"),
+			[]string{`(root) = "a" -> "b"`},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := DiffStates(test.prior, test.refreshed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("expected %q, got %q", test.want, got)
+			}
+		})
+	}
+}
+
+func TestDiffStatesUnknownValue(t *testing.T) {
+	_, err := DiffStates(
+		cty.ObjectVal(map[string]cty.Value{"id": cty.StringVal("a")}),
+		cty.ObjectVal(map[string]cty.Value{"id": cty.UnknownVal(cty.String)}),
+	)
+	if err == nil || err.Error() != "id: can't diff unknown value" {
+		t.Errorf("expected an unknown value error, got %v", err)
+	}
+}
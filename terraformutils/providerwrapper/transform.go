@@ -0,0 +1,295 @@
+// Copyright 2018 The Terraformer Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providerwrapper //nolint
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/GoogleCloudPlatform/terraformer/terraformutils/tfplugin/stoleninternal/configschema"
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/go-cty/cty/convert"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov5"
+)
+
+// transformRefreshedValue applies the per resource type adjustments configured
+// on the wrapper to a value freshly read from the provider.
+func (p *ProviderWrapper) transformRefreshedValue(resourceType string, val cty.Value) (cty.Value, error) {
+	if defaults, ok := p.defaultAttributes[resourceType]; ok {
+		var err error
+		val, err = injectDefaultAttributes(val, defaults)
+		if err != nil {
+			return val, fmt.Errorf("can't inject default attributes into %s: %w", resourceType, err)
+		}
+	}
+	if defaults, ok := p.omittedDefaults[resourceType]; ok {
+		schema, err := p.GetSchema()
+		if err != nil {
+			return val, err
+		}
+		resourceSchema, ok := schema.ResourceSchemas[resourceType]
+		if !ok {
+			return val, fmt.Errorf("unknown resource type %q for provider %q", resourceType, p.providerName)
+		}
+		val, err = omitDefaults(resourceSchema.Block, val, defaults)
+		if err != nil {
+			return val, fmt.Errorf("can't omit defaults of %s: %w", resourceType, err)
+		}
+	}
+	if p.maxAttributeDepth > 0 {
+		var truncated int
+		val, truncated = truncateAttributeDepth(val, p.maxAttributeDepth)
+		if truncated > 0 {
+			p.log().Warn("dropped %d nested structures of %s deeper than %d levels", truncated, resourceType, p.maxAttributeDepth)
+		}
+	}
+	if renames, ok := p.attributeRenames[resourceType]; ok {
+		var err error
+		val, err = renameAttributes(val, renames)
+		if err != nil {
+			return val, fmt.Errorf("can't rename attributes of %s: %w", resourceType, err)
+		}
+	}
+	if len(p.excludePaths) > 0 {
+		val = excludePaths(val, "", p.excludePaths)
+	}
+	return val, nil
+}
+
+// excludePaths drops the parts of val whose flatmapped name, e.g. tags_all or
+// rule.1, matches one of patterns: attributes become null and collection
+// elements are removed, so the counts of the generated state stay right.
+func excludePaths(val cty.Value, prefix string, patterns []*regexp.Regexp) cty.Value {
+	if val.IsNull() || !val.IsKnown() {
+		return val
+	}
+	excluded := func(key string) bool {
+		for _, pattern := range patterns {
+			if pattern.MatchString(key) {
+				return true
+			}
+		}
+		return false
+	}
+	ty := val.Type()
+	switch {
+	case ty.IsObjectType():
+		attrs := map[string]cty.Value{}
+		for name, attr := range val.AsValueMap() {
+			if excluded(prefix + name) {
+				attrs[name] = cty.NullVal(attr.Type())
+			} else {
+				attrs[name] = excludePaths(attr, prefix+name+".", patterns)
+			}
+		}
+		if len(attrs) == 0 {
+			return val
+		}
+		return cty.ObjectVal(attrs)
+	case ty.IsListType() || ty.IsSetType():
+		var elems []cty.Value
+		i := 0
+		for it := val.ElementIterator(); it.Next(); i++ {
+			_, elem := it.Element()
+			key := prefix + strconv.Itoa(i)
+			if !excluded(key) {
+				elems = append(elems, excludePaths(elem, key+".", patterns))
+			}
+		}
+		switch {
+		case len(elems) == 0 && ty.IsListType():
+			return cty.ListValEmpty(ty.ElementType())
+		case len(elems) == 0:
+			return cty.SetValEmpty(ty.ElementType())
+		case ty.IsListType():
+			return cty.ListVal(elems)
+		}
+		return cty.SetVal(elems)
+	case ty.IsMapType():
+		elems := map[string]cty.Value{}
+		for it := val.ElementIterator(); it.Next(); {
+			k, elem := it.Element()
+			key := prefix + k.AsString()
+			if !excluded(key) {
+				elems[k.AsString()] = excludePaths(elem, key+".", patterns)
+			}
+		}
+		if len(elems) == 0 {
+			return cty.MapValEmpty(ty.ElementType())
+		}
+		return cty.MapVal(elems)
+	}
+	// tuple elements have types of their own and can't be removed
+	return val
+}
+
+func injectDefaultAttributes(val cty.Value, defaults map[string]cty.Value) (cty.Value, error) {
+	if val.IsNull() || !val.IsKnown() || !val.Type().IsObjectType() {
+		return val, nil
+	}
+	attrs := map[string]cty.Value{}
+	for name, attr := range val.AsValueMap() {
+		attrs[name] = attr
+	}
+	for name, defaultVal := range defaults {
+		if !val.Type().HasAttribute(name) {
+			return val, fmt.Errorf("unknown attribute %q", name)
+		}
+		if !attrs[name].IsNull() {
+			continue
+		}
+		converted, err := convert.Convert(defaultVal, val.Type().AttributeType(name))
+		if err != nil {
+			return val, fmt.Errorf("attribute %q: %w", name, err)
+		}
+		attrs[name] = converted
+	}
+	return cty.ObjectVal(attrs), nil
+}
+
+// renameAttributes moves the top-level attributes of val named by the keys of
+// renames to their mapped names. A rename must not land on an attribute that
+// stays in place or on the target of another rename.
+func renameAttributes(val cty.Value, renames map[string]string) (cty.Value, error) {
+	if val.IsNull() || !val.IsKnown() || !val.Type().IsObjectType() {
+		return val, nil
+	}
+	attrs := map[string]cty.Value{}
+	for name, attr := range val.AsValueMap() {
+		if _, renamed := renames[name]; !renamed {
+			attrs[name] = attr
+		}
+	}
+	for from, to := range renames {
+		if !val.Type().HasAttribute(from) {
+			return val, fmt.Errorf("unknown attribute %q", from)
+		}
+		if _, exists := attrs[to]; exists {
+			return val, fmt.Errorf("renaming %q to %q collides with an existing attribute", from, to)
+		}
+		attrs[to] = val.GetAttr(from)
+	}
+	return cty.ObjectVal(attrs), nil
+}
+
+// truncateAttributeDepth replaces the non-empty nested structures found maxDepth
+// path steps into val with nulls of the same type, so their content is dropped
+// while val keeps conforming to the schema. Every attribute and element step
+// counts as one level. It returns how many structures were dropped.
+func truncateAttributeDepth(val cty.Value, maxDepth int) (cty.Value, int) {
+	truncated := 0
+	val, _ = cty.Transform(val, func(path cty.Path, v cty.Value) (cty.Value, error) {
+		if len(path) != maxDepth || v.IsNull() || !v.IsKnown() {
+			return v, nil
+		}
+		ty := v.Type()
+		if !ty.IsObjectType() && !ty.IsCollectionType() && !ty.IsTupleType() {
+			return v, nil
+		}
+		if v.LengthInt() == 0 {
+			return v, nil
+		}
+		truncated++
+		return cty.NullVal(ty), nil
+	})
+	return val, truncated
+}
+
+// omitDefaults nulls the optional attributes of val, at any nesting depth,
+// that hold the default configured for their path, so they are left out of
+// the generated configuration. Paths follow configschema.AttributePath.
+func omitDefaults(block *tfprotov5.SchemaBlock, val cty.Value, defaults map[string]cty.Value) (cty.Value, error) {
+	known := map[string]bool{}
+	for _, path := range configschema.WrapBlock(block).AttributePaths() {
+		known[path.Path] = true
+	}
+	for path := range defaults {
+		if !known[path] {
+			return val, fmt.Errorf("unknown attribute %q", path)
+		}
+	}
+	return omitBlockDefaults(block, val, "", defaults)
+}
+
+func omitBlockDefaults(block *tfprotov5.SchemaBlock, val cty.Value, prefix string, defaults map[string]cty.Value) (cty.Value, error) {
+	if block == nil || val.IsNull() || !val.IsKnown() || !val.Type().IsObjectType() {
+		return val, nil
+	}
+	attrs := map[string]cty.Value{}
+	for name, attr := range val.AsValueMap() {
+		attrs[name] = attr
+	}
+	for _, attrS := range block.Attributes {
+		defaultVal, ok := defaults[prefix+attrS.Name]
+		attr := attrs[attrS.Name]
+		if !ok || !attrS.Optional || attr.IsNull() || !attr.IsKnown() {
+			continue
+		}
+		converted, err := convert.Convert(defaultVal, attr.Type())
+		if err != nil {
+			return val, fmt.Errorf("attribute %q: %w", prefix+attrS.Name, err)
+		}
+		if equal := attr.Equals(converted); equal.IsKnown() && equal.True() {
+			attrs[attrS.Name] = cty.NullVal(attr.Type())
+		}
+	}
+	for _, blockS := range block.BlockTypes {
+		nested := attrs[blockS.TypeName]
+		switch blockS.Nesting {
+		case tfprotov5.SchemaNestedBlockNestingModeList, tfprotov5.SchemaNestedBlockNestingModeSet, tfprotov5.SchemaNestedBlockNestingModeMap:
+			if nested.IsNull() || !nested.IsKnown() || nested.LengthInt() == 0 {
+				continue
+			}
+			elems := map[string]cty.Value{}
+			var list []cty.Value
+			for it := nested.ElementIterator(); it.Next(); {
+				key, elem := it.Element()
+				elem, err := omitBlockDefaults(blockS.Block, elem, prefix+blockS.TypeName+".*.", defaults)
+				if err != nil {
+					return val, err
+				}
+				if blockS.Nesting == tfprotov5.SchemaNestedBlockNestingModeMap {
+					elems[key.AsString()] = elem
+				} else {
+					list = append(list, elem)
+				}
+			}
+			switch blockS.Nesting {
+			case tfprotov5.SchemaNestedBlockNestingModeList:
+				if nested.Type().IsTupleType() {
+					attrs[blockS.TypeName] = cty.TupleVal(list)
+				} else {
+					attrs[blockS.TypeName] = cty.ListVal(list)
+				}
+			case tfprotov5.SchemaNestedBlockNestingModeSet:
+				attrs[blockS.TypeName] = cty.SetVal(list)
+			default:
+				if nested.Type().IsObjectType() {
+					attrs[blockS.TypeName] = cty.ObjectVal(elems)
+				} else {
+					attrs[blockS.TypeName] = cty.MapVal(elems)
+				}
+			}
+		default:
+			var err error
+			attrs[blockS.TypeName], err = omitBlockDefaults(blockS.Block, nested, prefix+blockS.TypeName+".", defaults)
+			if err != nil {
+				return val, err
+			}
+		}
+	}
+	return cty.ObjectVal(attrs), nil
+}
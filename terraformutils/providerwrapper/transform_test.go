@@ -0,0 +1,292 @@
+package providerwrapper //nolint
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/terraformer/terraformutils/tfplugin/stoleninternal/configschema"
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov5"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+// echoProvider returns a fake provider whose reads return the prior state.
+func echoProvider(resourceSchemas map[string]*tfprotov5.Schema) *fakeProvider {
+	return &fakeProvider{
+		schema: fakeSchema(resourceSchemas),
+		readResource: func(req *tfprotov5.ReadResourceRequest) (*tfprotov5.ReadResourceResponse, error) {
+			return &tfprotov5.ReadResourceResponse{NewState: req.CurrentState}, nil
+		},
+	}
+}
+
+func TestRefreshInjectsDefaultAttributes(t *testing.T) {
+	provider := newFakeProviderWrapper(echoProvider(map[string]*tfprotov5.Schema{
+		"fake_database": fakeResourceSchema("name", "password"),
+	}))
+	WithDefaultAttributes("fake_database", map[string]cty.Value{
+		"password": cty.StringVal("changeme"),
+		"name":     cty.StringVal("ignored"),
+	})(provider)
+
+	state, err := provider.Refresh(&terraform.InstanceInfo{Type: "fake_database", Id: "fake_database.db"}, &terraform.InstanceState{
+		ID:         "db-1",
+		Attributes: map[string]string{"name": "db"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Attributes["password"] != "changeme" {
+		t.Errorf("expected default password to be injected, got %q", state.Attributes["password"])
+	}
+	if state.Attributes["name"] != "db" {
+		t.Errorf("expected read name to be kept, got %q", state.Attributes["name"])
+	}
+}
+
+func TestRefreshRenamesAttributes(t *testing.T) {
+	provider := newFakeProviderWrapper(echoProvider(map[string]*tfprotov5.Schema{
+		"fake_database": fakeResourceSchema("name", "engine"),
+	}))
+	WithAttributeRenames("fake_database", map[string]string{"name": "database_name"})(provider)
+
+	state, err := provider.Refresh(&terraform.InstanceInfo{Type: "fake_database", Id: "fake_database.db"}, &terraform.InstanceState{
+		ID:         "db-1",
+		Attributes: map[string]string{"name": "db", "engine": "postgres"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Attributes["database_name"] != "db" {
+		t.Errorf("expected name to be renamed to database_name, got %v", state.Attributes)
+	}
+	if _, ok := state.Attributes["name"]; ok {
+		t.Errorf("expected name to be gone after the rename, got %v", state.Attributes)
+	}
+	if state.Attributes["engine"] != "postgres" {
+		t.Errorf("expected engine to be kept, got %q", state.Attributes["engine"])
+	}
+
+	WithAttributeRenames("fake_database", map[string]string{"name": "engine"})(provider)
+	_, err = provider.Refresh(&terraform.InstanceInfo{Type: "fake_database", Id: "fake_database.db"}, &terraform.InstanceState{
+		ID:         "db-1",
+		Attributes: map[string]string{"name": "db", "engine": "postgres"},
+	})
+	if err == nil {
+		t.Error("expected a rename onto an existing attribute to fail")
+	}
+}
+
+func TestRefreshTruncatesAttributeDepth(t *testing.T) {
+	rule := tftypes.Object{AttributeTypes: map[string]tftypes.Type{
+		"name":  tftypes.String,
+		"ports": tftypes.List{ElementType: tftypes.String},
+	}}
+	schema := fakeResourceSchema()
+	schema.Block.Attributes = append(schema.Block.Attributes, &tfprotov5.SchemaAttribute{
+		Name: "rules", Type: tftypes.List{ElementType: rule}, Optional: true,
+	})
+	provider := newFakeProviderWrapper(echoProvider(map[string]*tfprotov5.Schema{"fake_firewall": schema}))
+	WithMaxAttributeDepth(3)(provider)
+
+	state, err := provider.Refresh(&terraform.InstanceInfo{Type: "fake_firewall", Id: "fake_firewall.fw"}, &terraform.InstanceState{
+		ID: "fw-1",
+		Attributes: map[string]string{
+			"rules.#":         "1",
+			"rules.0.name":    "ssh",
+			"rules.0.ports.#": "1",
+			"rules.0.ports.0": "22",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Attributes["rules.0.name"] != "ssh" {
+		t.Errorf("expected attributes within the depth to be kept, got %v", state.Attributes)
+	}
+	if _, ok := state.Attributes["rules.0.ports.0"]; ok {
+		t.Errorf("expected ports beyond the depth to be dropped, got %v", state.Attributes)
+	}
+}
+
+func TestRefreshOmitsNestedDefaults(t *testing.T) {
+	schema := fakeResourceSchema("name")
+	schema.Block.Attributes = append(schema.Block.Attributes, &tfprotov5.SchemaAttribute{Name: "enabled", Type: tftypes.Bool, Optional: true})
+	schema.Block.BlockTypes = []*tfprotov5.SchemaNestedBlock{{
+		TypeName: "rule",
+		Nesting:  tfprotov5.SchemaNestedBlockNestingModeList,
+		Block: &tfprotov5.SchemaBlock{
+			Attributes: []*tfprotov5.SchemaAttribute{
+				{Name: "port", Type: tftypes.Number, Required: true},
+				{Name: "protocol", Type: tftypes.String, Optional: true},
+			},
+			BlockTypes: []*tfprotov5.SchemaNestedBlock{{
+				TypeName: "logging",
+				Nesting:  tfprotov5.SchemaNestedBlockNestingModeList,
+				MaxItems: 1,
+				Block: &tfprotov5.SchemaBlock{Attributes: []*tfprotov5.SchemaAttribute{
+					{Name: "level", Type: tftypes.String, Optional: true},
+				}},
+			}},
+		},
+	}}
+	provider := newFakeProviderWrapper(echoProvider(map[string]*tfprotov5.Schema{"fake_firewall": schema}))
+	WithOmittedDefaults("fake_firewall", map[string]cty.Value{
+		"enabled":                cty.True,
+		"rule.*.protocol":        cty.StringVal("tcp"),
+		"rule.*.logging.*.level": cty.StringVal("info"),
+	})(provider)
+
+	state, err := provider.Refresh(&terraform.InstanceInfo{Type: "fake_firewall", Id: "fake_firewall.fw"}, &terraform.InstanceState{
+		ID: "fw-1",
+		Attributes: map[string]string{
+			"name":                   "fw",
+			"enabled":                "true",
+			"rule.#":                 "2",
+			"rule.0.port":            "22",
+			"rule.0.protocol":        "tcp",
+			"rule.0.logging.#":       "1",
+			"rule.0.logging.0.level": "info",
+			"rule.1.port":            "53",
+			"rule.1.protocol":        "udp",
+			"rule.1.logging.#":       "1",
+			"rule.1.logging.0.level": "debug",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, omitted := range []string{"enabled", "rule.0.protocol", "rule.0.logging.0.level"} {
+		if _, ok := state.Attributes[omitted]; ok {
+			t.Errorf("expected %s at its default to be omitted, got %v", omitted, state.Attributes)
+		}
+	}
+	for key, want := range map[string]string{
+		"name":                   "fw",
+		"rule.0.port":            "22",
+		"rule.1.protocol":        "udp",
+		"rule.1.logging.0.level": "debug",
+	} {
+		if state.Attributes[key] != want {
+			t.Errorf("expected %s to be kept as %q, got %q", key, want, state.Attributes[key])
+		}
+	}
+
+	WithOmittedDefaults("fake_firewall", map[string]cty.Value{"rule.*.bogus": cty.True})(provider)
+	if _, err := provider.Refresh(&terraform.InstanceInfo{Type: "fake_firewall", Id: "fake_firewall.fw"}, &terraform.InstanceState{ID: "fw-1"}); err == nil {
+		t.Error("expected an unknown default path to fail")
+	}
+}
+
+func TestRefreshExcludesPaths(t *testing.T) {
+	schema := fakeResourceSchema("name", "tags_all")
+	schema.Block.BlockTypes = []*tfprotov5.SchemaNestedBlock{{
+		TypeName: "rule",
+		Nesting:  tfprotov5.SchemaNestedBlockNestingModeList,
+		Block: &tfprotov5.SchemaBlock{Attributes: []*tfprotov5.SchemaAttribute{
+			{Name: "port", Type: tftypes.Number, Required: true},
+		}},
+	}}
+	provider := newFakeProviderWrapper(echoProvider(map[string]*tfprotov5.Schema{"fake_firewall": schema}))
+	WithExcludePaths([]string{`^tags_all$`, `^rule\.1$`})(provider)
+
+	state, err := provider.Refresh(&terraform.InstanceInfo{Type: "fake_firewall", Id: "fake_firewall.fw"}, &terraform.InstanceState{
+		ID: "fw-1",
+		Attributes: map[string]string{
+			"name":        "fw",
+			"tags_all":    "managed",
+			"rule.#":      "3",
+			"rule.0.port": "22",
+			"rule.1.port": "53",
+			"rule.2.port": "443",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := state.Attributes["tags_all"]; ok {
+		t.Errorf("expected tags_all to be excluded, got %v", state.Attributes)
+	}
+	for key, want := range map[string]string{
+		"name":        "fw",
+		"rule.#":      "2",
+		"rule.0.port": "22",
+		"rule.1.port": "443",
+	} {
+		if state.Attributes[key] != want {
+			t.Errorf("expected %s to be %q, got %q", key, want, state.Attributes[key])
+		}
+	}
+}
+
+func TestInvalidExcludePathFailsWrapper(t *testing.T) {
+	provider := echoProvider(map[string]*tfprotov5.Schema{"fake_firewall": fakeResourceSchema("name")})
+	_, err := NewProviderWrapperFromServer("fake", provider, cty.EmptyObjectVal, WithExcludePaths([]string{`^tags_all$`, `^rule\.(`}))
+	if err == nil || !strings.Contains(err.Error(), `"^rule\\.("`) {
+		t.Errorf("expected the invalid pattern to be reported, got %v", err)
+	}
+}
+
+func TestNullBlocksStayDistinctFromEmpty(t *testing.T) {
+	for _, nesting := range []tfprotov5.SchemaNestedBlockNestingMode{
+		tfprotov5.SchemaNestedBlockNestingModeList,
+		tfprotov5.SchemaNestedBlockNestingModeSet,
+		tfprotov5.SchemaNestedBlockNestingModeMap,
+	} {
+		t.Run(nesting.String(), func(t *testing.T) {
+			resourceSchema := fakeResourceSchema()
+			resourceSchema.Block.BlockTypes = []*tfprotov5.SchemaNestedBlock{{
+				TypeName: "rule",
+				Nesting:  nesting,
+				Block: &tfprotov5.SchemaBlock{Attributes: []*tfprotov5.SchemaAttribute{
+					{Name: "port", Type: tftypes.Number, Optional: true},
+				}},
+			}}
+			ty := configschema.WrapBlock(resourceSchema.Block).ImpliedType()
+			provider := newFakeProviderWrapper(&fakeProvider{
+				schema: fakeSchema(map[string]*tfprotov5.Schema{"fake_firewall": resourceSchema}),
+				planResourceChange: func(req *tfprotov5.PlanResourceChangeRequest) (*tfprotov5.PlanResourceChangeResponse, error) {
+					return &tfprotov5.PlanResourceChangeResponse{PlannedState: req.ProposedNewState}, nil
+				},
+			})
+			WithExcludePaths([]string{`^rule\.unused$`})(provider)
+
+			ruleType := ty.AttributeType("rule")
+			empty, err := configschema.WrapBlock(resourceSchema.Block).CoerceValue(cty.ObjectVal(map[string]cty.Value{
+				"id": cty.StringVal("fw-1"),
+			}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, test := range map[string]struct {
+				rule      cty.Value
+				wantCount bool
+			}{
+				"null":  {cty.NullVal(ruleType), false},
+				"empty": {empty.GetAttr("rule"), true},
+			} {
+				val := cty.ObjectVal(map[string]cty.Value{"id": cty.StringVal("fw-1"), "rule": test.rule})
+				planned, _, err := provider.PlanChange(&terraform.InstanceInfo{Type: "fake_firewall", Id: "fake_firewall.fw"}, val, val)
+				if err != nil {
+					t.Fatal(err)
+				}
+				planned, err = provider.transformRefreshedValue("fake_firewall", planned)
+				if err != nil {
+					t.Fatal(err)
+				}
+				state := provider.shimInstanceState("fake_firewall", planned, 0)
+				_, hasCount := state.Attributes["rule.#"]
+				if _, ok := state.Attributes["rule.%"]; ok {
+					hasCount = true
+				}
+				if hasCount != test.wantCount {
+					t.Errorf("%s rule: expected a count in the state to be %v, got %v", name, test.wantCount, state.Attributes)
+				}
+				if back := flatmapObjectValue(state.Attributes, ty).GetAttr("rule"); !back.RawEquals(test.rule) {
+					t.Errorf("%s rule: expected %#v back from the state, got %#v", name, test.rule, back)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2018 The Terraformer Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providerwrapper //nolint
+
+import (
+	"strings"
+
+	"github.com/GoogleCloudPlatform/terraformer/terraformutils/tfplugin/stoleninternal/configschema"
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov5"
+)
+
+// WithTypeOverrides replaces the schema types of attributes of resourceType,
+// keyed by their dotted path such as settings or rule.port, for providers
+// shipping a type that doesn't convert to a cty type, which fails the whole
+// resource. Implied types and coercion then use the replacements.
+func WithTypeOverrides(resourceType string, overrides map[string]cty.Type) ProviderOption {
+	return func(p *ProviderWrapper) {
+		if p.typeOverrides == nil {
+			p.typeOverrides = map[string]map[string]cty.Type{}
+		}
+		p.typeOverrides[resourceType] = overrides
+	}
+}
+
+// overrideSchemaTypes returns schema with the type overrides applied. The
+// overridden resource schemas are copies, schema may be shared with other
+// wrappers.
+func (p *ProviderWrapper) overrideSchemaTypes(schema *tfprotov5.GetProviderSchemaResponse) *tfprotov5.GetProviderSchemaResponse {
+	if len(p.typeOverrides) == 0 {
+		return schema
+	}
+	overridden := *schema
+	overridden.ResourceSchemas = make(map[string]*tfprotov5.Schema, len(schema.ResourceSchemas))
+	for resourceType, resourceSchema := range schema.ResourceSchemas {
+		overridden.ResourceSchemas[resourceType] = p.overrideResourceTypes(resourceType, resourceSchema)
+	}
+	return &overridden
+}
+
+func (p *ProviderWrapper) overrideResourceTypes(resourceType string, schema *tfprotov5.Schema) *tfprotov5.Schema {
+	overrides := p.typeOverrides[resourceType]
+	if len(overrides) == 0 || schema == nil || schema.Block == nil {
+		return schema
+	}
+	overridden := *schema
+	for path, ty := range overrides {
+		block, ok := overrideAttributeType(overridden.Block, strings.Split(path, "."), ty)
+		if !ok {
+			p.log().Warn("can't override the type of %s of %s, there is no such attribute", path, resourceType)
+			continue
+		}
+		overridden.Block = block
+	}
+	return &overridden
+}
+
+// overrideAttributeType returns a copy of block where the attribute at path
+// has type ty, and false when there is no such attribute.
+func overrideAttributeType(block *tfprotov5.SchemaBlock, path []string, ty cty.Type) (*tfprotov5.SchemaBlock, bool) {
+	copied := *block
+	if len(path) == 1 {
+		for i, attr := range block.Attributes {
+			if attr.Name == path[0] {
+				attr := *attr
+				attr.Type = configschema.UnwrapType(ty)
+				copied.Attributes = append([]*tfprotov5.SchemaAttribute{}, block.Attributes...)
+				copied.Attributes[i] = &attr
+				return &copied, true
+			}
+		}
+		return block, false
+	}
+	for i, nested := range block.BlockTypes {
+		if nested.TypeName != path[0] || nested.Block == nil {
+			continue
+		}
+		inner, ok := overrideAttributeType(nested.Block, path[1:], ty)
+		if !ok {
+			return block, false
+		}
+		nested := *nested
+		nested.Block = inner
+		copied.BlockTypes = append([]*tfprotov5.SchemaNestedBlock{}, block.BlockTypes...)
+		copied.BlockTypes[i] = &nested
+		return &copied, true
+	}
+	return block, false
+}
@@ -0,0 +1,74 @@
+package providerwrapper //nolint
+
+import (
+	"testing"
+
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov5"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+func TestTypeOverrides(t *testing.T) {
+	// cty has no objects with optional attributes, so settings can't be
+	// coerced as the provider declares it
+	settingsType := tftypes.Object{
+		AttributeTypes:     map[string]tftypes.Type{"mode": tftypes.String},
+		OptionalAttributes: map[string]struct{}{"mode": {}},
+	}
+	resourceSchema := fakeResourceSchema("name")
+	resourceSchema.Block.Attributes = append(resourceSchema.Block.Attributes, &tfprotov5.SchemaAttribute{Name: "settings", Type: settingsType, Optional: true})
+	resourceSchema.Block.BlockTypes = []*tfprotov5.SchemaNestedBlock{{
+		TypeName: "rule",
+		Nesting:  tfprotov5.SchemaNestedBlockNestingModeList,
+		Block: &tfprotov5.SchemaBlock{Attributes: []*tfprotov5.SchemaAttribute{
+			{Name: "port", Type: tftypes.Number, Optional: true},
+		}},
+	}}
+	schema := fakeSchema(map[string]*tfprotov5.Schema{"fake_service": resourceSchema})
+	newProvider := func() *ProviderWrapper {
+		return newFakeProviderWrapper(&fakeProvider{
+			schema: schema,
+			planResourceChange: func(req *tfprotov5.PlanResourceChangeRequest) (*tfprotov5.PlanResourceChangeResponse, error) {
+				return &tfprotov5.PlanResourceChangeResponse{PlannedState: req.ProposedNewState}, nil
+			},
+		})
+	}
+	info := &terraform.InstanceInfo{Type: "fake_service", Id: "fake_service.web"}
+	state := cty.ObjectVal(map[string]cty.Value{
+		"id":       cty.StringVal("web"),
+		"name":     cty.StringVal("web"),
+		"settings": cty.ObjectVal(map[string]cty.Value{"mode": cty.StringVal("fast")}),
+		"rule":     cty.ListVal([]cty.Value{cty.ObjectVal(map[string]cty.Value{"port": cty.StringVal("http")})}),
+	})
+
+	if _, _, err := newProvider().PlanChange(info, state, state); err == nil {
+		t.Fatal("expected the unconvertible settings type to fail coercion")
+	}
+
+	provider := newProvider()
+	WithTypeOverrides("fake_service", map[string]cty.Type{
+		"settings":  cty.Object(map[string]cty.Type{"mode": cty.String}),
+		"rule.port": cty.String,
+		"missing":   cty.String,
+	})(provider)
+	planned, _, err := provider.PlanChange(info, state, state)
+	if err != nil {
+		t.Fatalf("expected the overridden types to coerce, got %v", err)
+	}
+	if !planned.RawEquals(state) {
+		t.Errorf("expected %#v, got %#v", state, planned)
+	}
+	refresher, err := provider.NewTypeRefresher("fake_service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !refresher.impliedType.Equals(state.Type()) {
+		t.Errorf("expected the implied type %#v, got %#v", state.Type(), refresher.impliedType)
+	}
+
+	// the schema may be shared by other wrappers, it is left untouched
+	if !resourceSchema.Block.Attributes[2].Type.Equal(settingsType) || !resourceSchema.Block.BlockTypes[0].Block.Attributes[0].Type.Is(tftypes.Number) {
+		t.Error("expected the provider schema not to be modified")
+	}
+}
@@ -15,6 +15,7 @@
 package terraformutils
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"regexp"
@@ -39,6 +40,13 @@ type Resource struct {
 	AdditionalFields  map[string]interface{} `json:",omitempty"`
 	SlowQueryRequired bool
 	DataFiles         map[string][]byte
+	// ImportID, when set, replaces the state ID if the resource has to be
+	// imported, for types that import by a composite ID.
+	ImportID string `json:",omitempty"`
+	// ProviderAlias, when set, assigns the resource to the aliased
+	// configuration of its provider, e.g. us_west for provider.aws.us_west,
+	// for resources imported from several regions or accounts.
+	ProviderAlias string `json:",omitempty"`
 }
 
 type ApplicableFilter interface {
@@ -47,20 +55,76 @@ type ApplicableFilter interface {
 
 type ResourceFilter struct {
 	ApplicableFilter
-	ServiceName      string
+	ServiceName string
+	// ResourceType scopes the filter to resources of exactly this type, e.g.
+	// aws_instance, leaving resources of other types untouched.
+	ResourceType     string
 	FieldPath        string
 	AcceptableValues []string
+	// ValuePattern, when set, also accepts the values of FieldPath it
+	// matches.
+	ValuePattern *regexp.Regexp
+	// AttributePath, when set, is matched instead of FieldPath against the
+	// flatmapped state attributes, e.g. tags.Environment, with * standing
+	// for any list index or map key, e.g. network_interface.*.network_ip.
+	// Resources without the attribute don't match.
+	AttributePath string
+}
+
+// NewFieldFilter keeps the resources whose field at the dotted path field,
+// e.g. tags.Name, equals value. A field of "id" filters on resource IDs, and
+// is applied before refreshing. Set ServiceName or ResourceType on the
+// result to scope it.
+func NewFieldFilter(field, value string) ResourceFilter {
+	return ResourceFilter{
+		FieldPath:        field,
+		AcceptableValues: []string{value},
+	}
+}
+
+// NewRegexFilter keeps the resources whose field at the dotted path field
+// matches pattern.
+func NewRegexFilter(field string, pattern *regexp.Regexp) ResourceFilter {
+	return ResourceFilter{
+		FieldPath:    field,
+		ValuePattern: pattern,
+	}
+}
+
+// NewAttributeFilter keeps the resources whose flatmapped state attribute at
+// path, which may contain * wildcards, equals value. It applies after
+// refreshing.
+func NewAttributeFilter(path, value string) ResourceFilter {
+	return ResourceFilter{
+		AttributePath:    path,
+		AcceptableValues: []string{value},
+	}
+}
+
+// NewTagFilter keeps the resources tagged with key set to value.
+func NewTagFilter(key, value string) ResourceFilter {
+	return NewFieldFilter("tags."+key, value)
 }
 
 func (rf *ResourceFilter) Filter(resource Resource) bool {
+	if rf.ResourceType != "" && resource.InstanceInfo.Type != rf.ResourceType {
+		return true
+	}
 	if !rf.IsApplicable(strings.TrimPrefix(resource.InstanceInfo.Type, resource.Provider+"_")) {
 		return true
 	}
 	var vals []interface{}
 	switch {
+	case rf.AttributePath != "":
+		for _, val := range flatmapAttributeValues(rf.AttributePath, resource.InstanceState.Attributes) {
+			vals = append(vals, val)
+		}
+		if len(vals) > 0 && rf.AcceptableValues == nil && rf.ValuePattern == nil {
+			return true
+		}
 	case rf.FieldPath == "id":
 		vals = []interface{}{resource.InstanceState.ID}
-	case rf.AcceptableValues == nil:
+	case rf.AcceptableValues == nil && rf.ValuePattern == nil:
 		var hasField = WalkAndCheckField(rf.FieldPath, resource.InstanceState.Attributes)
 		if hasField {
 			return true
@@ -78,10 +142,47 @@ func (rf *ResourceFilter) Filter(resource Resource) bool {
 				return true
 			}
 		}
+		if s, ok := val.(string); ok && rf.ValuePattern != nil && rf.ValuePattern.MatchString(s) {
+			return true
+		}
 	}
 	return false
 }
 
+// flatmapAttributeValues returns the values of the flatmapped attributes
+// matching path, where a * segment matches any index or key but not the
+// "#" and "%" counts.
+func flatmapAttributeValues(path string, attributes map[string]string) []string {
+	if val, ok := attributes[path]; ok {
+		return []string{val}
+	}
+	if !strings.Contains(path, "*") {
+		return nil
+	}
+	pathSegments := strings.Split(path, ".")
+	var vals []string
+	for key, val := range attributes {
+		keySegments := strings.Split(key, ".")
+		if len(keySegments) != len(pathSegments) {
+			continue
+		}
+		matches := true
+		for i, segment := range pathSegments {
+			if segment == "*" && keySegments[i] != "#" && keySegments[i] != "%" {
+				continue
+			}
+			if segment != keySegments[i] {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			vals = append(vals, val)
+		}
+	}
+	return vals
+}
+
 func (rf *ResourceFilter) IsApplicable(serviceName string) bool {
 	return rf.ServiceName == "" || rf.ServiceName == serviceName
 }
@@ -128,12 +229,34 @@ func (r *Resource) Refresh(provider *providerwrapper.ProviderWrapper) {
 	if r.SlowQueryRequired {
 		time.Sleep(200 * time.Millisecond)
 	}
-	r.InstanceState, err = provider.Refresh(r.InstanceInfo, r.InstanceState)
+	if r.ImportID != "" {
+		r.InstanceState, err = provider.RefreshWithImportID(context.Background(), r.InstanceInfo, r.InstanceState, r.ImportID)
+	} else {
+		r.InstanceState, err = provider.Refresh(r.InstanceInfo, r.InstanceState)
+	}
 	if err != nil {
 		log.Println(err)
 	}
 }
 
+// RefreshWithContext is Refresh bounded by ctx, leaving the resource without
+// state when ctx is done before the provider answers.
+func (r *Resource) RefreshWithContext(ctx context.Context, provider *providerwrapper.ProviderWrapper) {
+	if err := r.refresh(ctx, provider); err != nil {
+		log.Println(err)
+	}
+}
+
+// refresh is RefreshWithContext returning the error instead of logging it.
+func (r *Resource) refresh(ctx context.Context, provider *providerwrapper.ProviderWrapper) error {
+	var err error
+	if r.SlowQueryRequired {
+		time.Sleep(200 * time.Millisecond)
+	}
+	r.InstanceState, err = provider.RefreshWithImportID(ctx, r.InstanceInfo, r.InstanceState, r.ImportID)
+	return err
+}
+
 func (r Resource) GetIDKey() string {
 	if _, exist := r.InstanceState.Attributes["self_link"]; exist {
 		return "self_link"
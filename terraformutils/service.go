@@ -103,8 +103,14 @@ func (s *Service) ParseFilter(rawFilter string) []ResourceFilter {
 			AcceptableValuesPart = parts[2]
 		}
 
+		var ResourceTypePart string
+		if s.ProviderName != "" && strings.HasPrefix(ServiceNamePart, s.ProviderName+"_") {
+			ResourceTypePart, ServiceNamePart = ServiceNamePart, ""
+		}
+
 		filters = append(filters, ResourceFilter{
 			ServiceName:      ServiceNamePart,
+			ResourceType:     ResourceTypePart,
 			FieldPath:        strings.TrimPrefix(FieldPathPart, "Name="),
 			AcceptableValues: ParseFilterValues(strings.TrimPrefix(AcceptableValuesPart, "Value=")),
 		})
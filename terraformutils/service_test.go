@@ -2,6 +2,7 @@ package terraformutils
 
 import (
 	"reflect"
+	"regexp"
 	"testing"
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
@@ -137,3 +138,132 @@ func TestServiceAttributeNameOnlyCleanupWithFilter(t *testing.T) {
 		t.Errorf("failed to cleanup")
 	}
 }
+
+func TestServiceResourceTypeScopedCleanupWithFilter(t *testing.T) {
+	resource := func(resourceType, id, name string) Resource {
+		return Resource{
+			InstanceInfo: &terraform.InstanceInfo{
+				Type: resourceType,
+				Id:   resourceType + "." + id,
+			},
+			InstanceState: &terraform.InstanceState{
+				ID: id,
+			},
+			Item: mapI("tags", mapI("Name", name))}
+	}
+	service := Service{
+		ProviderName: "aws",
+		Resources: []Resource{
+			resource("aws_instance", "i-1", "web"),
+			resource("aws_instance", "i-2", "db"),
+			resource("aws_vpc", "vpc-1", "db"),
+		},
+	}
+	service.ParseFilters([]string{"Type=aws_instance;Name=tags.Name;Value=web"})
+
+	if !reflect.DeepEqual(service.Filter, []ResourceFilter{
+		{
+			ResourceType:     "aws_instance",
+			FieldPath:        "tags.Name",
+			AcceptableValues: []string{"web"},
+		}}) {
+		t.Errorf("failed to parse, got %v", service.Filter)
+	}
+
+	service.PostRefreshCleanup()
+
+	var ids []string
+	for _, r := range service.Resources {
+		ids = append(ids, r.InstanceState.ID)
+	}
+	if !reflect.DeepEqual(ids, []string{"i-1", "vpc-1"}) {
+		t.Errorf("expected only the aws_instance resources to be filtered, got %v", ids)
+	}
+}
+
+func TestFilterConstructorsCleanup(t *testing.T) {
+	newResources := func() []Resource {
+		var resources []Resource
+		for _, vpc := range []struct{ id, name, env string }{
+			{"vpc-1", "web", "prod"},
+			{"vpc-2", "db", "dev"},
+			{"vpc-3", "web-canary", "dev"},
+		} {
+			resources = append(resources, Resource{
+				InstanceInfo:  &terraform.InstanceInfo{Type: "aws_vpc", Id: "aws_vpc." + vpc.id},
+				InstanceState: &terraform.InstanceState{ID: vpc.id},
+				Item:          map[string]interface{}{"name": vpc.name, "tags": mapI("env", vpc.env)},
+			})
+		}
+		return resources
+	}
+	for _, test := range []struct {
+		name    string
+		filter  ResourceFilter
+		initial bool
+		want    []string
+	}{
+		{"field", NewFieldFilter("name", "db"), false, []string{"vpc-2"}},
+		{"id", NewFieldFilter("id", "vpc-3"), true, []string{"vpc-3"}},
+		{"regex", NewRegexFilter("name", regexp.MustCompile(`^web`)), false, []string{"vpc-1", "vpc-3"}},
+		{"regex id", NewRegexFilter("id", regexp.MustCompile(`-[12]$`)), true, []string{"vpc-1", "vpc-2"}},
+		{"tag", NewTagFilter("env", "dev"), false, []string{"vpc-2", "vpc-3"}},
+		{"other type", ResourceFilter{ResourceType: "aws_subnet", FieldPath: "name", AcceptableValues: []string{"none"}}, false, []string{"vpc-1", "vpc-2", "vpc-3"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			service := Service{ProviderName: "aws", Resources: newResources(), Filter: []ResourceFilter{test.filter}}
+			FilterCleanup(&service, test.initial)
+			var got []string
+			for _, resource := range service.Resources {
+				got = append(got, resource.InstanceState.ID)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("expected %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestAttributeFilterCleanup(t *testing.T) {
+	newResources := func() []Resource {
+		var resources []Resource
+		for _, instance := range []struct {
+			id         string
+			attributes map[string]string
+		}{
+			{"i-1", map[string]string{"tags.%": "1", "tags.Environment": "prod", "network_interface.#": "2", "network_interface.0.network_ip": "10.0.0.1", "network_interface.1.network_ip": "10.0.1.1"}},
+			{"i-2", map[string]string{"tags.%": "1", "tags.Environment": "dev", "network_interface.#": "1", "network_interface.0.network_ip": "10.0.1.2"}},
+			{"i-3", map[string]string{"tags.%": "0", "network_interface.#": "0"}},
+		} {
+			resources = append(resources, Resource{
+				InstanceInfo:  &terraform.InstanceInfo{Type: "google_compute_instance", Id: "google_compute_instance." + instance.id},
+				InstanceState: &terraform.InstanceState{ID: instance.id, Attributes: instance.attributes},
+			})
+		}
+		return resources
+	}
+	for _, test := range []struct {
+		name   string
+		filter ResourceFilter
+		want   []string
+	}{
+		{"nested map tag", NewAttributeFilter("tags.Environment", "prod"), []string{"i-1"}},
+		{"wildcard index", NewAttributeFilter("network_interface.*.network_ip", "10.0.1.1"), []string{"i-1"}},
+		{"wildcard skips counts", NewAttributeFilter("network_interface.*", "0"), nil},
+		{"absent path", NewAttributeFilter("labels.team", "x"), nil},
+		{"present path", ResourceFilter{AttributePath: "tags.Environment"}, []string{"i-1", "i-2"}},
+		{"pattern", ResourceFilter{AttributePath: "network_interface.*.network_ip", ValuePattern: regexp.MustCompile(`^10\.0\.1\.`)}, []string{"i-1", "i-2"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			service := Service{ProviderName: "google", Resources: newResources(), Filter: []ResourceFilter{test.filter}}
+			FilterCleanup(&service, false)
+			var got []string
+			for _, resource := range service.Resources {
+				got = append(got, resource.InstanceState.ID)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("expected %v, got %v", test.want, got)
+			}
+		})
+	}
+}
@@ -26,6 +26,13 @@ import (
 )
 
 func OutputHclFiles(resources []terraformutils.Resource, provider terraformutils.ProviderGenerator, path string, serviceName string, isCompact bool, output string, sort bool) error {
+	return OutputHclFilesForRegistry(resources, provider, path, serviceName, isCompact, output, sort, "")
+}
+
+// OutputHclFilesForRegistry is OutputHclFiles with required_providers sources
+// qualified with registryHost, e.g. terraformutils.OpenTofuRegistryHost.
+// An empty registryHost leaves sources as the provider declares them.
+func OutputHclFilesForRegistry(resources []terraformutils.Resource, provider terraformutils.ProviderGenerator, path string, serviceName string, isCompact bool, output string, sort bool, registryHost string) error {
 	if err := os.MkdirAll(path, os.ModePerm); err != nil {
 		return err
 	}
@@ -34,8 +41,11 @@ func OutputHclFiles(resources []terraformutils.Resource, provider terraformutils
 		"version": providerwrapper.GetProviderVersion(provider.GetName()),
 	}
 
-	if providerWithSource, ok := provider.(terraformutils.ProviderWithSource); ok {
-		providerConfig["source"] = providerWithSource.GetSource()
+	if source := ProviderSource(provider); source != "" {
+		providerConfig["source"] = source
+	}
+	if registryHost != "" {
+		providerConfig["source"] = terraformutils.ProviderSource(registryHost, provider.GetName(), ProviderSource(provider))
 	}
 
 	// create provider file
@@ -118,6 +128,14 @@ func OutputHclFiles(resources []terraformutils.Resource, provider terraformutils
 	return nil
 }
 
+// ProviderSource returns the source the provider declares, if any.
+func ProviderSource(provider terraformutils.ProviderGenerator) string {
+	if providerWithSource, ok := provider.(terraformutils.ProviderWithSource); ok {
+		return providerWithSource.GetSource()
+	}
+	return ""
+}
+
 func printFile(v []terraformutils.Resource, fileName, path, output string, sort bool) error {
 	for _, res := range v {
 		if res.DataFiles == nil {
@@ -20,12 +20,16 @@ import (
 type GRPCProviderPlugin struct {
 	plugin.Plugin
 	GRPCProvider func() proto.ProviderServer
+	// LogWarning, when set, is given the warning diagnostics of successful
+	// calls.
+	LogWarning func(warning string)
 }
 
 func (p *GRPCProviderPlugin) GRPCClient(ctx context.Context, broker *plugin.GRPCBroker, c *grpc.ClientConn) (interface{}, error) {
 	return &client{
-		upstream: proto.NewProviderClient(c),
-		ctx:      ctx,
+		upstream:   proto.NewProviderClient(c),
+		ctx:        ctx,
+		logWarning: p.LogWarning,
 	}, nil
 }
 
@@ -35,8 +39,9 @@ func (p *GRPCProviderPlugin) GRPCServer(broker *plugin.GRPCBroker, s *grpc.Serve
 
 type client struct {
 	tfprotov5.ProviderServer
-	upstream proto.ProviderClient
-	ctx      context.Context
+	upstream   proto.ProviderClient
+	ctx        context.Context
+	logWarning func(warning string)
 	ClientContext
 }
 
@@ -48,6 +53,21 @@ func (c *client) Context() context.Context {
 	return c.ctx
 }
 
+// checkDiagnostics turns error diagnostics into an error, logging warnings
+// along the way when enabled.
+func (c *client) checkDiagnostics(diags []*tfprotov5.Diagnostic) error {
+	w := configschema.WrapDiagnostics(diags)
+	if c.logWarning != nil {
+		for _, warning := range w.Warnings() {
+			c.logWarning(warning)
+		}
+	}
+	if w.HasError() {
+		return w.ToError()
+	}
+	return nil
+}
+
 // the following implementation is the reverse of terraform-plugin-go@v0.15.0/tfprotov5/tf5server/server.go
 
 func (c *client) GetProviderSchema(ctx context.Context, req *tfprotov5.GetProviderSchemaRequest) (*tfprotov5.GetProviderSchemaResponse, error) {
@@ -63,8 +83,8 @@ func (c *client) GetProviderSchema(ctx context.Context, req *tfprotov5.GetProvid
 	if err != nil {
 		return nil, err
 	}
-	if w := configschema.WrapDiagnostics(ret.Diagnostics); w.HasError() {
-		return ret, w.ToError()
+	if err := c.checkDiagnostics(ret.Diagnostics); err != nil {
+		return ret, err
 	}
 	return ret, nil
 }
@@ -82,8 +102,8 @@ func (c *client) PrepareProviderConfig(ctx context.Context, req *tfprotov5.Prepa
 	if err != nil {
 		return nil, err
 	}
-	if w := configschema.WrapDiagnostics(ret.Diagnostics); w.HasError() {
-		return ret, w.ToError()
+	if err := c.checkDiagnostics(ret.Diagnostics); err != nil {
+		return ret, err
 	}
 	return ret, nil
 }
@@ -101,8 +121,8 @@ func (c *client) ConfigureProvider(ctx context.Context, req *tfprotov5.Configure
 	if err != nil {
 		return nil, err
 	}
-	if w := configschema.WrapDiagnostics(ret.Diagnostics); w.HasError() {
-		return ret, w.ToError()
+	if err := c.checkDiagnostics(ret.Diagnostics); err != nil {
+		return ret, err
 	}
 	return ret, nil
 }
@@ -136,8 +156,8 @@ func (c *client) ValidateDataSourceConfig(ctx context.Context, req *tfprotov5.Va
 	if err != nil {
 		return nil, err
 	}
-	if w := configschema.WrapDiagnostics(ret.Diagnostics); w.HasError() {
-		return ret, w.ToError()
+	if err := c.checkDiagnostics(ret.Diagnostics); err != nil {
+		return ret, err
 	}
 	return ret, nil
 }
@@ -155,8 +175,8 @@ func (c *client) ReadDataSource(ctx context.Context, req *tfprotov5.ReadDataSour
 	if err != nil {
 		return nil, err
 	}
-	if w := configschema.WrapDiagnostics(ret.Diagnostics); w.HasError() {
-		return ret, w.ToError()
+	if err := c.checkDiagnostics(ret.Diagnostics); err != nil {
+		return ret, err
 	}
 	return ret, nil
 }
@@ -174,8 +194,8 @@ func (c *client) ValidateResourceTypeConfig(ctx context.Context, req *tfprotov5.
 	if err != nil {
 		return nil, err
 	}
-	if w := configschema.WrapDiagnostics(ret.Diagnostics); w.HasError() {
-		return ret, w.ToError()
+	if err := c.checkDiagnostics(ret.Diagnostics); err != nil {
+		return ret, err
 	}
 	return ret, nil
 }
@@ -193,8 +213,8 @@ func (c *client) UpgradeResourceState(ctx context.Context, req *tfprotov5.Upgrad
 	if err != nil {
 		return nil, err
 	}
-	if w := configschema.WrapDiagnostics(ret.Diagnostics); w.HasError() {
-		return ret, w.ToError()
+	if err := c.checkDiagnostics(ret.Diagnostics); err != nil {
+		return ret, err
 	}
 	return ret, nil
 }
@@ -212,8 +232,8 @@ func (c *client) ReadResource(ctx context.Context, req *tfprotov5.ReadResourceRe
 	if err != nil {
 		return nil, err
 	}
-	if w := configschema.WrapDiagnostics(ret.Diagnostics); w.HasError() {
-		return ret, w.ToError()
+	if err := c.checkDiagnostics(ret.Diagnostics); err != nil {
+		return ret, err
 	}
 	return ret, nil
 }
@@ -231,8 +251,8 @@ func (c *client) PlanResourceChange(ctx context.Context, req *tfprotov5.PlanReso
 	if err != nil {
 		return nil, err
 	}
-	if w := configschema.WrapDiagnostics(ret.Diagnostics); w.HasError() {
-		return ret, w.ToError()
+	if err := c.checkDiagnostics(ret.Diagnostics); err != nil {
+		return ret, err
 	}
 	return ret, nil
 }
@@ -250,8 +270,8 @@ func (c *client) ApplyResourceChange(ctx context.Context, req *tfprotov5.ApplyRe
 	if err != nil {
 		return nil, err
 	}
-	if w := configschema.WrapDiagnostics(ret.Diagnostics); w.HasError() {
-		return ret, w.ToError()
+	if err := c.checkDiagnostics(ret.Diagnostics); err != nil {
+		return ret, err
 	}
 	return ret, nil
 }
@@ -269,8 +289,8 @@ func (c *client) ImportResourceState(ctx context.Context, req *tfprotov5.ImportR
 	if err != nil {
 		return nil, err
 	}
-	if w := configschema.WrapDiagnostics(ret.Diagnostics); w.HasError() {
-		return ret, w.ToError()
+	if err := c.checkDiagnostics(ret.Diagnostics); err != nil {
+		return ret, err
 	}
 	return ret, nil
 }
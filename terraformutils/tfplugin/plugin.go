@@ -9,10 +9,16 @@ import (
 // VersionedPlugins includes both protocol 5 and 6 because this is the function
 // called in providerFactory (command/meta_providers.go) to set up the initial
 // plugin client config.
-var VersionedPlugins = map[int]plugin.PluginSet{
-	5: {
-		ProviderPluginName: &GRPCProviderPlugin{},
-	},
+var VersionedPlugins = NewVersionedPlugins(nil)
+
+// NewVersionedPlugins returns the VersionedPlugins with the provider client
+// giving warning diagnostics to logWarning when it is set.
+func NewVersionedPlugins(logWarning func(warning string)) map[int]plugin.PluginSet {
+	return map[int]plugin.PluginSet{
+		5: {
+			ProviderPluginName: &GRPCProviderPlugin{LogWarning: logWarning},
+		},
+	}
 }
 
 // terraform@v.1.4.5/internal/plugin/serve.go
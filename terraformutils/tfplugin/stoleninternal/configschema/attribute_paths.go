@@ -0,0 +1,57 @@
+package configschema
+
+import (
+	"sort"
+
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov5"
+)
+
+// AttributePath describes one attribute reachable from a block schema.
+type AttributePath struct {
+	// Path is the dotted path to the attribute. Elements of list, set and
+	// map nested blocks are represented by a "*" step, so an attribute of a
+	// list block reads as "block.*.attribute".
+	Path     string
+	Type     cty.Type
+	Required bool
+	Optional bool
+	Computed bool
+}
+
+// AttributePaths returns every attribute reachable from the receiving block,
+// including those inside nested blocks, sorted by path.
+//
+// This is the positive counterpart of the read-only attribute detection: it
+// lists what can be set, rather than what must be ignored.
+func (b *Block) AttributePaths() []AttributePath {
+	paths := b.attributePaths("", nil)
+	sort.Slice(paths, func(i, j int) bool {
+		return paths[i].Path < paths[j].Path
+	})
+	return paths
+}
+
+func (b *Block) attributePaths(prefix string, paths []AttributePath) []AttributePath {
+	if b == nil {
+		return paths
+	}
+	for _, attrS := range b.Attributes {
+		paths = append(paths, AttributePath{
+			Path:     prefix + attrS.Name,
+			Type:     WrapType(attrS.Type),
+			Required: attrS.Required,
+			Optional: attrS.Optional,
+			Computed: attrS.Computed,
+		})
+	}
+	for _, blockS := range b.BlockTypes {
+		blockPrefix := prefix + blockS.TypeName + "."
+		switch blockS.Nesting {
+		case tfprotov5.SchemaNestedBlockNestingModeList, tfprotov5.SchemaNestedBlockNestingModeSet, tfprotov5.SchemaNestedBlockNestingModeMap:
+			blockPrefix += "*."
+		}
+		paths = WrapBlock(blockS.Block).attributePaths(blockPrefix, paths)
+	}
+	return paths
+}
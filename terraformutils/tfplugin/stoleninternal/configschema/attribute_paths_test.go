@@ -0,0 +1,88 @@
+package configschema
+
+import (
+	"testing"
+
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov5"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+)
+
+func TestBlockAttributePaths(t *testing.T) {
+	schema := &tfprotov5.SchemaBlock{
+		Attributes: []*tfprotov5.SchemaAttribute{
+			{
+				Name:     "name",
+				Type:     tftypes.String,
+				Required: true,
+			},
+			{
+				Name:     "id",
+				Type:     tftypes.String,
+				Computed: true,
+			},
+		},
+		BlockTypes: []*tfprotov5.SchemaNestedBlock{
+			{
+				TypeName: "disk",
+				Nesting:  tfprotov5.SchemaNestedBlockNestingModeList,
+				Block: &tfprotov5.SchemaBlock{
+					Attributes: []*tfprotov5.SchemaAttribute{
+						{
+							Name:     "size",
+							Type:     tftypes.Number,
+							Optional: true,
+							Computed: true,
+						},
+					},
+					BlockTypes: []*tfprotov5.SchemaNestedBlock{
+						{
+							TypeName: "encryption",
+							Nesting:  tfprotov5.SchemaNestedBlockNestingModeSingle,
+							Block: &tfprotov5.SchemaBlock{
+								Attributes: []*tfprotov5.SchemaAttribute{
+									{
+										Name:     "key",
+										Type:     tftypes.String,
+										Optional: true,
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			{
+				TypeName: "tag",
+				Nesting:  tfprotov5.SchemaNestedBlockNestingModeSet,
+				Block: &tfprotov5.SchemaBlock{
+					Attributes: []*tfprotov5.SchemaAttribute{
+						{
+							Name:     "values",
+							Type:     tftypes.List{ElementType: tftypes.String},
+							Required: true,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	want := []AttributePath{
+		{Path: "disk.*.encryption.key", Type: cty.String, Optional: true},
+		{Path: "disk.*.size", Type: cty.Number, Optional: true, Computed: true},
+		{Path: "id", Type: cty.String, Computed: true},
+		{Path: "name", Type: cty.String, Required: true},
+		{Path: "tag.*.values", Type: cty.List(cty.String), Required: true},
+	}
+	got := WrapBlock(schema).AttributePaths()
+	if len(got) != len(want) {
+		t.Fatalf("wrong number of paths\ngot:  %#v\nwant: %#v", got, want)
+	}
+	for i := range want {
+		if got[i].Path != want[i].Path || !got[i].Type.Equals(want[i].Type) ||
+			got[i].Required != want[i].Required || got[i].Optional != want[i].Optional || got[i].Computed != want[i].Computed {
+			t.Errorf("wrong path %d\ngot:  %#v\nwant: %#v", i, got[i], want[i])
+		}
+	}
+}
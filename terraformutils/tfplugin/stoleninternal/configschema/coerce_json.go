@@ -0,0 +1,68 @@
+package configschema
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/go-cty/cty"
+	ctyjson "github.com/hashicorp/go-cty/cty/json"
+)
+
+// CoerceJSON decodes raw, a JSON object such as an attribute blob returned by
+// a cloud SDK, against the type implied by resourceBlock and coerces the
+// result with CoerceValue, giving a value conforming to the schema.
+//
+// Values that don't match the schema are reported with the path of the
+// mismatch in the message, e.g. rule[0].port, and the error unwraps to the
+// cty.PathError.
+func CoerceJSON(resourceBlock *Block, raw []byte) (cty.Value, error) {
+	ty, err := resourceBlock.ImpliedTypeErr()
+	if err != nil {
+		return cty.NilVal, err
+	}
+	val, err := ctyjson.Unmarshal(raw, ty)
+	if err != nil {
+		return cty.NilVal, withErrorPath(err)
+	}
+	val, err = resourceBlock.CoerceValue(val)
+	if err != nil {
+		return cty.NilVal, withErrorPath(err)
+	}
+	return val, nil
+}
+
+// withErrorPath prefixes the message of a cty.PathError with its path.
+func withErrorPath(err error) error {
+	var pathErr cty.PathError
+	if !errors.As(err, &pathErr) || len(pathErr.Path) == 0 {
+		return err
+	}
+	return fmt.Errorf("%s: %w", formatPath(pathErr.Path), err)
+}
+
+// formatPath renders path the way it would be written in configuration, e.g.
+// rule[0].port or tags["Name"].
+func formatPath(path cty.Path) string {
+	var b strings.Builder
+	for _, step := range path {
+		switch step := step.(type) {
+		case cty.GetAttrStep:
+			if b.Len() > 0 {
+				b.WriteString(".")
+			}
+			b.WriteString(step.Name)
+		case cty.IndexStep:
+			switch {
+			case step.Key.Type() == cty.String:
+				fmt.Fprintf(&b, "[%q]", step.Key.AsString())
+			case step.Key.Type() == cty.Number:
+				fmt.Fprintf(&b, "[%s]", step.Key.AsBigFloat().Text('f', -1))
+			default:
+				// set elements have no index of their own
+				b.WriteString("[...]")
+			}
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,61 @@
+package configschema
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov5"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+)
+
+func TestCoerceJSON(t *testing.T) {
+	block := WrapBlock(&tfprotov5.SchemaBlock{
+		Attributes: []*tfprotov5.SchemaAttribute{
+			{Name: "name", Type: tftypes.String, Required: true},
+			{Name: "tags", Type: tftypes.Map{ElementType: tftypes.String}, Optional: true},
+		},
+		BlockTypes: []*tfprotov5.SchemaNestedBlock{{
+			TypeName: "rule",
+			Nesting:  tfprotov5.SchemaNestedBlockNestingModeList,
+			Block: &tfprotov5.SchemaBlock{Attributes: []*tfprotov5.SchemaAttribute{
+				{Name: "port", Type: tftypes.Number, Required: true},
+			}},
+		}},
+	})
+
+	val, err := CoerceJSON(block, []byte(`{"name": "fw", "tags": {"env": "prod"}, "rule": [{"port": 22}, {"port": "443"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := cty.ObjectVal(map[string]cty.Value{
+		"name": cty.StringVal("fw"),
+		"tags": cty.MapVal(map[string]cty.Value{"env": cty.StringVal("prod")}),
+		"rule": cty.ListVal([]cty.Value{
+			cty.ObjectVal(map[string]cty.Value{"port": cty.NumberIntVal(22)}),
+			cty.ObjectVal(map[string]cty.Value{"port": cty.NumberIntVal(443)}),
+		}),
+	})
+	if !val.RawEquals(want) {
+		t.Errorf("expected %#v, got %#v", want, val)
+	}
+
+	for raw, wantPath := range map[string]string{
+		`{"name": "fw", "rule": [{"port": 22}, {"port": "ssh"}]}`: "rule[1].port: ",
+		`{"name": "fw", "tags": {"env": ["prod"]}}`:               `tags["env"]: `,
+	} {
+		_, err := CoerceJSON(block, []byte(raw))
+		if err == nil || !strings.HasPrefix(err.Error(), wantPath) {
+			t.Errorf("expected an error at %s for %s, got %v", wantPath, raw, err)
+		}
+		var pathErr cty.PathError
+		if !errors.As(err, &pathErr) {
+			t.Errorf("expected the error for %s to unwrap to a cty.PathError, got %T", raw, err)
+		}
+	}
+
+	if _, err := CoerceJSON(block, []byte(`{"name": "fw", "rule": [{"port": 22, "protocol": "tcp"}]}`)); err == nil || !strings.HasPrefix(err.Error(), "rule[0]: ") {
+		t.Errorf("expected the attribute missing from the schema to be reported, got %v", err)
+	}
+}
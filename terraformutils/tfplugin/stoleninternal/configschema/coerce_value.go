@@ -2,6 +2,7 @@ package configschema
 
 import (
 	"fmt"
+	"sort"
 
 	"github.com/hashicorp/go-cty/cty"
 	"github.com/hashicorp/go-cty/cty/convert"
@@ -23,15 +24,72 @@ import (
 // error may be a cty.PathError indicating a position within the nested
 // data structure where the problem applies.
 func (b *Block) CoerceValue(in cty.Value) (cty.Value, error) {
+	return b.CoerceValueWithOptions(in, CoerceOptions{})
+}
+
+// CoerceAgainstSchema coerces input to conform to schema as CoerceValue
+// does, for tools validating a value against a resource schema, e.g. a test
+// fixture or the input of a failed import, without a provider wrapper.
+func CoerceAgainstSchema(schema *tfprotov5.SchemaBlock, input cty.Value) (cty.Value, error) {
+	if schema == nil {
+		return cty.NilVal, fmt.Errorf("no schema to coerce against")
+	}
+	return WrapBlock(schema).CoerceValue(input)
+}
+
+// CoerceOptions tightens the checks CoerceValue applies.
+type CoerceOptions struct {
+	// EnforceItemCounts rejects list and set blocks whose number of known
+	// items falls outside their MinItems and MaxItems bounds, and missing
+	// single blocks with MinItems set.
+	EnforceItemCounts bool
+
+	// FillMissingRequired substitutes a null for required attributes that
+	// are missing from the value instead of failing, e.g. for partial
+	// states that are fixed up in the generated configuration later.
+	FillMissingRequired bool
+
+	// SkipUnconvertibleTypes nulls attributes whose schema type has no cty
+	// equivalent, instead of failing on them.
+	SkipUnconvertibleTypes bool
+}
+
+// CoerceValueWithOptions is like CoerceValue, with the additional checks
+// enabled in opts.
+func (b *Block) CoerceValueWithOptions(in cty.Value, opts CoerceOptions) (cty.Value, error) {
 	var path cty.Path
-	return b.coerceValue(in, path)
+	return b.coerceValue(in, path, opts)
+}
+
+// CoerceValueLenient is like CoerceValue, but fills missing required
+// attributes with nulls instead of returning an error.
+func (b *Block) CoerceValueLenient(in cty.Value) (cty.Value, error) {
+	return b.CoerceValueWithOptions(in, CoerceOptions{FillMissingRequired: true})
+}
+
+// checkItemCount validates the number of items given for a list, set or
+// single block against its bounds, when opts asks for it.
+func checkItemCount(blockS *tfprotov5.SchemaNestedBlock, l int, path cty.Path, opts CoerceOptions) error {
+	if !opts.EnforceItemCounts {
+		return nil
+	}
+	path = append(path.Copy(), cty.GetAttrStep{Name: blockS.TypeName})
+	if blockS.MinItems > 0 && int64(l) < blockS.MinItems {
+		return path.NewErrorf("too few blocks (%d required, %d given)", blockS.MinItems, l)
+	}
+	if blockS.MaxItems > 0 && int64(l) > blockS.MaxItems {
+		return path.NewErrorf("too many blocks (%d allowed, %d given)", blockS.MaxItems, l)
+	}
+	return nil
 }
 
-func (b *Block) coerceValue(in cty.Value, path cty.Path) (cty.Value, error) {
+func (b *Block) coerceValue(in cty.Value, path cty.Path, opts CoerceOptions) (cty.Value, error) {
 	switch {
 	case in.IsNull():
 		return cty.NullVal(b.ImpliedType()), nil
 	case !in.IsKnown():
+		// The hashicorp/go-cty fork providers are built against predates
+		// refined unknowns, so there is nothing beyond the type to carry over.
 		return cty.UnknownVal(b.ImpliedType()), nil
 	}
 
@@ -70,13 +128,13 @@ func (b *Block) coerceValue(in cty.Value, path cty.Path) (cty.Value, error) {
 		switch {
 		case ty.HasAttribute(name):
 			val = in.GetAttr(name)
-		case attrS.Computed || attrS.Optional:
+		case attrS.Computed || attrS.Optional || opts.FillMissingRequired:
 			val = cty.NullVal(WrapType(attrS.Type))
 		default:
 			return cty.UnknownVal(b.ImpliedType()), path.NewErrorf("attribute %q is required", name)
 		}
 
-		val, err := WrapAttribute(attrS).coerceValue(val, append(path, cty.GetAttrStep{Name: name}))
+		val, err := WrapAttribute(attrS).coerceValue(val, append(path, cty.GetAttrStep{Name: name}), opts)
 		if err != nil {
 			return cty.UnknownVal(b.ImpliedType()), err
 		}
@@ -85,7 +143,7 @@ func (b *Block) coerceValue(in cty.Value, path cty.Path) (cty.Value, error) {
 	}
 	for _, blockS := range b.BlockTypes {
 		typeName := blockS.TypeName
-		impliedType := WrapNestedBlock(blockS).ImpliedType()
+		impliedType := WrapNestedBlock(blockS).WrappedBlock().ImpliedType()
 		switch blockS.Nesting {
 
 		case tfprotov5.SchemaNestedBlockNestingModeSingle, tfprotov5.SchemaNestedBlockNestingModeGroup:
@@ -93,21 +151,37 @@ func (b *Block) coerceValue(in cty.Value, path cty.Path) (cty.Value, error) {
 			case ty.HasAttribute(typeName):
 				var err error
 				val := in.GetAttr(typeName)
-				attrs[typeName], err = WrapNestedBlock(blockS).coerceValue(val, append(path, cty.GetAttrStep{Name: typeName}))
+				if val.IsNull() {
+					if err := checkItemCount(blockS, 0, path, opts); err != nil {
+						return cty.UnknownVal(b.ImpliedType()), err
+					}
+				}
+				attrs[typeName], err = WrapNestedBlock(blockS).WrappedBlock().coerceValue(val, append(path, cty.GetAttrStep{Name: typeName}), opts)
 				if err != nil {
 					return cty.UnknownVal(b.ImpliedType()), err
 				}
 			default:
+				// a single block with MinItems set is required
+				if err := checkItemCount(blockS, 0, path, opts); err != nil {
+					return cty.UnknownVal(b.ImpliedType()), err
+				}
 				attrs[typeName] = WrapNestedBlock(blockS).EmptyValue()
 			}
 
 		case tfprotov5.SchemaNestedBlockNestingModeList:
+			// A null list, set or map block stays null and an absent one
+			// becomes empty, as in configuration. Since states flatmap a
+			// null collection without its count, they keep telling blocks
+			// a provider left unset apart from empty ones.
 			switch {
 			case ty.HasAttribute(typeName):
 				coll := in.GetAttr(typeName)
 
 				switch {
 				case coll.IsNull():
+					if err := checkItemCount(blockS, 0, path, opts); err != nil {
+						return cty.UnknownVal(b.ImpliedType()), err
+					}
 					attrs[typeName] = cty.NullVal(cty.List(impliedType))
 					continue
 				case !coll.IsKnown():
@@ -119,6 +193,9 @@ func (b *Block) coerceValue(in cty.Value, path cty.Path) (cty.Value, error) {
 					return cty.UnknownVal(b.ImpliedType()), path.NewErrorf("must be a list")
 				}
 				l := coll.LengthInt()
+				if err := checkItemCount(blockS, l, path, opts); err != nil {
+					return cty.UnknownVal(b.ImpliedType()), err
+				}
 
 				if l == 0 {
 					attrs[typeName] = cty.ListValEmpty(impliedType)
@@ -126,19 +203,37 @@ func (b *Block) coerceValue(in cty.Value, path cty.Path) (cty.Value, error) {
 				}
 				elems := make([]cty.Value, 0, l)
 				{
-					path = append(path, cty.GetAttrStep{Name: typeName})
+					path := append(path.Copy(), cty.GetAttrStep{Name: typeName})
 					for it := coll.ElementIterator(); it.Next(); {
 						var err error
 						idx, val := it.Element()
-						val, err = WrapNestedBlock(blockS).coerceValue(val, append(path, cty.IndexStep{Key: idx}))
+						val, err = WrapNestedBlock(blockS).WrappedBlock().coerceValue(val, append(path, cty.IndexStep{Key: idx}), opts)
 						if err != nil {
 							return cty.UnknownVal(b.ImpliedType()), err
 						}
 						elems = append(elems, val)
 					}
 				}
-				attrs[typeName] = cty.ListVal(elems)
+				// Dynamic attributes can give each element its own type,
+				// which only a tuple can hold; ImpliedType then constrains
+				// the block to cty.DynamicPseudoType anyway.
+				useTuple := false
+				for _, v := range elems[1:] {
+					if !v.Type().Equals(elems[0].Type()) {
+						useTuple = true
+						break
+					}
+				}
+
+				if useTuple {
+					attrs[typeName] = cty.TupleVal(elems)
+				} else {
+					attrs[typeName] = cty.ListVal(elems)
+				}
 			default:
+				if err := checkItemCount(blockS, 0, path, opts); err != nil {
+					return cty.UnknownVal(b.ImpliedType()), err
+				}
 				attrs[typeName] = cty.ListValEmpty(impliedType)
 			}
 
@@ -149,6 +244,9 @@ func (b *Block) coerceValue(in cty.Value, path cty.Path) (cty.Value, error) {
 
 				switch {
 				case coll.IsNull():
+					if err := checkItemCount(blockS, 0, path, opts); err != nil {
+						return cty.UnknownVal(b.ImpliedType()), err
+					}
 					attrs[typeName] = cty.NullVal(cty.Set(impliedType))
 					continue
 				case !coll.IsKnown():
@@ -160,6 +258,9 @@ func (b *Block) coerceValue(in cty.Value, path cty.Path) (cty.Value, error) {
 					return cty.UnknownVal(b.ImpliedType()), path.NewErrorf("must be a set")
 				}
 				l := coll.LengthInt()
+				if err := checkItemCount(blockS, l, path, opts); err != nil {
+					return cty.UnknownVal(b.ImpliedType()), err
+				}
 
 				if l == 0 {
 					attrs[typeName] = cty.SetValEmpty(impliedType)
@@ -167,19 +268,32 @@ func (b *Block) coerceValue(in cty.Value, path cty.Path) (cty.Value, error) {
 				}
 				elems := make([]cty.Value, 0, l)
 				{
-					path = append(path, cty.GetAttrStep{Name: typeName})
+					path := append(path.Copy(), cty.GetAttrStep{Name: typeName})
 					for it := coll.ElementIterator(); it.Next(); {
 						var err error
 						idx, val := it.Element()
-						val, err = WrapNestedBlock(blockS).coerceValue(val, append(path, cty.IndexStep{Key: idx}))
+						val, err = WrapNestedBlock(blockS).WrappedBlock().coerceValue(val, append(path, cty.IndexStep{Key: idx}), opts)
 						if err != nil {
 							return cty.UnknownVal(b.ImpliedType()), err
 						}
+						// Distinct input elements can coerce to the same
+						// value, e.g. "22" and 22, and a set keeps only one.
+						if containsRawEqual(elems, val) {
+							continue
+						}
+						// Dynamic attributes can give each element its own
+						// type, which a set can't hold, unlike a list's tuple.
+						if len(elems) > 0 && !val.Type().Equals(elems[0].Type()) {
+							return cty.UnknownVal(b.ImpliedType()), append(path, cty.IndexStep{Key: idx}).NewErrorf("set elements must all have the same type, got %s and %s", elems[0].Type().FriendlyName(), val.Type().FriendlyName())
+						}
 						elems = append(elems, val)
 					}
 				}
 				attrs[typeName] = cty.SetVal(elems)
 			default:
+				if err := checkItemCount(blockS, 0, path, opts); err != nil {
+					return cty.UnknownVal(b.ImpliedType()), err
+				}
 				attrs[typeName] = cty.SetValEmpty(impliedType)
 			}
 
@@ -207,14 +321,14 @@ func (b *Block) coerceValue(in cty.Value, path cty.Path) (cty.Value, error) {
 				}
 				elems := make(map[string]cty.Value)
 				{
-					path = append(path, cty.GetAttrStep{Name: typeName})
+					path := append(path.Copy(), cty.GetAttrStep{Name: typeName})
 					for it := coll.ElementIterator(); it.Next(); {
 						var err error
 						key, val := it.Element()
 						if key.Type() != cty.String || key.IsNull() || !key.IsKnown() {
 							return cty.UnknownVal(b.ImpliedType()), path.NewErrorf("must be a map")
 						}
-						val, err = WrapNestedBlock(blockS).coerceValue(val, append(path, cty.IndexStep{Key: key}))
+						val, err = WrapNestedBlock(blockS).WrappedBlock().coerceValue(val, append(path, cty.IndexStep{Key: key}), opts)
 						if err != nil {
 							return cty.UnknownVal(b.ImpliedType()), err
 						}
@@ -227,7 +341,10 @@ func (b *Block) coerceValue(in cty.Value, path cty.Path) (cty.Value, error) {
 				useObject := false
 				switch {
 				case coll.Type().IsObjectType():
-					useObject = true
+					// Inputs decoded from JSON arrive as objects keyed by
+					// the map keys. Once every element conforms to a static
+					// block type they can become a proper map.
+					useObject = impliedType.HasDynamicTypes()
 				default:
 					// It's possible that we were given a map, and need to coerce it to an object
 					ety := coll.Type().ElementType()
@@ -257,10 +374,71 @@ func (b *Block) coerceValue(in cty.Value, path cty.Path) (cty.Value, error) {
 	return cty.ObjectVal(attrs), nil
 }
 
-func (a *Attribute) coerceValue(in cty.Value, path cty.Path) (cty.Value, error) {
-	val, err := convert.Convert(in, WrapType(a.Type))
+// containsRawEqual reports whether vals holds a value identical to val.
+func containsRawEqual(vals []cty.Value, val cty.Value) bool {
+	for _, v := range vals {
+		if v.RawEquals(val) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Attribute) coerceValue(in cty.Value, path cty.Path, opts CoerceOptions) (cty.Value, error) {
+	ty, err := WrapTypeErr(a.Type)
+	if err != nil {
+		if opts.SkipUnconvertibleTypes {
+			return cty.NullVal(cty.DynamicPseudoType), nil
+		}
+		return cty.DynamicVal, path.NewError(err)
+	}
+	val, err := convert.Convert(in, ty)
 	if err != nil {
-		return cty.UnknownVal(WrapType(a.Type)), path.NewError(err)
+		// Point at the offending element when the problem is inside a
+		// collection, so the message reads the same at any depth.
+		elemPath, elem, elemTy := locateConversionError(in, ty, path.Copy())
+		if elemTy == cty.NilType {
+			return cty.UnknownVal(ty), elemPath.NewError(err)
+		}
+		return cty.UnknownVal(ty), elemPath.NewErrorf("expected %s, got %s", elemTy.FriendlyName(), elem.Type().FriendlyName())
 	}
 	return val, nil
 }
+
+// locateConversionError descends into in for the innermost element that
+// can't be converted to its part of ty, returning its path, value and the
+// type it must have. The type is cty.NilType when the failure is about the
+// shape of in rather than the type of one element, e.g. a missing attribute.
+func locateConversionError(in cty.Value, ty cty.Type, path cty.Path) (cty.Path, cty.Value, cty.Type) {
+	if in.IsNull() || !in.IsKnown() {
+		return path, in, ty
+	}
+	inTy := in.Type()
+	switch {
+	case ty.IsCollectionType() && (inTy.IsCollectionType() || inTy.IsTupleType() || (ty.IsMapType() && inTy.IsObjectType())):
+		ety := ty.ElementType()
+		for it := in.ElementIterator(); it.Next(); {
+			key, elem := it.Element()
+			if _, err := convert.Convert(elem, ety); err != nil {
+				return locateConversionError(elem, ety, append(path, cty.IndexStep{Key: key}))
+			}
+		}
+	case ty.IsObjectType() && inTy.IsObjectType():
+		names := make([]string, 0, len(ty.AttributeTypes()))
+		for name := range ty.AttributeTypes() {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			aty := ty.AttributeType(name)
+			if !inTy.HasAttribute(name) {
+				return path, in, cty.NilType
+			}
+			if _, err := convert.Convert(in.GetAttr(name), aty); err != nil {
+				return locateConversionError(in.GetAttr(name), aty, append(path, cty.GetAttrStep{Name: name}))
+			}
+		}
+		return path, in, cty.NilType
+	}
+	return path, in, ty
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/hashicorp/go-cty/cty"
@@ -184,6 +185,64 @@ func TestCoerceValue(t *testing.T) {
 			cty.DynamicVal,
 			`.foo[0]: attribute "bar" is required`,
 		},
+		"list block with one item having a wrong-typed attribute": {
+			&tfprotov5.SchemaBlock{
+				BlockTypes: []*tfprotov5.SchemaNestedBlock{
+					{
+						TypeName: "foo",
+						Block: &tfprotov5.SchemaBlock{
+							Attributes: []*tfprotov5.SchemaAttribute{
+								{
+									Name:     "bar",
+									Type:     tftypes.Number,
+									Required: true,
+								},
+							},
+						},
+						Nesting: tfprotov5.SchemaNestedBlockNestingModeList,
+					},
+				},
+			},
+			cty.ObjectVal(map[string]cty.Value{
+				"foo": cty.ListVal([]cty.Value{cty.ObjectVal(map[string]cty.Value{
+					"bar": cty.True,
+				})}),
+			}),
+			cty.DynamicVal,
+			`.foo[0].bar: expected number, got bool`,
+		},
+		"wrong-typed list attribute element": {
+			&tfprotov5.SchemaBlock{
+				Attributes: []*tfprotov5.SchemaAttribute{
+					{
+						Name:     "foo",
+						Type:     tftypes.List{ElementType: tftypes.Number},
+						Optional: true,
+					},
+				},
+			},
+			cty.ObjectVal(map[string]cty.Value{
+				"foo": cty.TupleVal([]cty.Value{cty.NumberIntVal(1), cty.True}),
+			}),
+			cty.DynamicVal,
+			`.foo[1]: expected number, got bool`,
+		},
+		"wrong-typed map attribute element": {
+			&tfprotov5.SchemaBlock{
+				Attributes: []*tfprotov5.SchemaAttribute{
+					{
+						Name:     "foo",
+						Type:     tftypes.Map{ElementType: tftypes.Bool},
+						Optional: true,
+					},
+				},
+			},
+			cty.ObjectVal(map[string]cty.Value{
+				"foo": cty.ObjectVal(map[string]cty.Value{"a": cty.True, "b": cty.StringVal("maybe")}),
+			}),
+			cty.DynamicVal,
+			`.foo["b"]: expected bool, got string`,
+		},
 		"list block with one item having an extraneous attribute": {
 			&tfprotov5.SchemaBlock{
 				BlockTypes: []*tfprotov5.SchemaNestedBlock{
@@ -342,6 +401,40 @@ func TestCoerceValue(t *testing.T) {
 			}),
 			"",
 		},
+		"dynamic attribute in set block": {
+			&tfprotov5.SchemaBlock{
+				BlockTypes: []*tfprotov5.SchemaNestedBlock{
+					{
+						TypeName: "foo",
+						Nesting:  tfprotov5.SchemaNestedBlockNestingModeSet,
+						Block: &tfprotov5.SchemaBlock{
+							Attributes: []*tfprotov5.SchemaAttribute{
+								{
+									Name:     "bar",
+									Type:     tftypes.DynamicPseudoType,
+									Optional: true,
+								},
+							},
+						},
+					},
+				},
+			},
+			cty.ObjectVal(map[string]cty.Value{
+				"foo": cty.SetVal([]cty.Value{
+					cty.ObjectVal(map[string]cty.Value{
+						"bar": cty.StringVal("beep"),
+					}),
+				}),
+			}),
+			cty.ObjectVal(map[string]cty.Value{
+				"foo": cty.SetVal([]cty.Value{
+					cty.ObjectVal(map[string]cty.Value{
+						"bar": cty.StringVal("beep"),
+					}),
+				}),
+			}),
+			``,
+		},
 		"unknowns in nested list": {
 			&tfprotov5.SchemaBlock{
 				BlockTypes: []*tfprotov5.SchemaNestedBlock{
@@ -455,7 +548,7 @@ func TestCoerceValue(t *testing.T) {
 				"foo": cty.False,
 			}),
 			cty.DynamicVal,
-			`.foo: number required`,
+			`.foo: expected number, got bool`,
 		},
 		"unset computed value": {
 			&tfprotov5.SchemaBlock{
@@ -524,6 +617,163 @@ func TestCoerceValue(t *testing.T) {
 			}),
 			``,
 		},
+		"dynamic attributes in list": {
+			// A list block whose elements end up with different types, via
+			// a dynamic attribute, becomes a tuple.
+			&tfprotov5.SchemaBlock{
+				BlockTypes: []*tfprotov5.SchemaNestedBlock{
+					{
+						TypeName: "foo",
+						Nesting:  tfprotov5.SchemaNestedBlockNestingModeList,
+						Block: &tfprotov5.SchemaBlock{
+							Attributes: []*tfprotov5.SchemaAttribute{
+								{
+									Name:     "bar",
+									Type:     tftypes.DynamicPseudoType,
+									Optional: true,
+								},
+							},
+						},
+					},
+				},
+			},
+			cty.ObjectVal(map[string]cty.Value{
+				"foo": cty.TupleVal([]cty.Value{
+					cty.ObjectVal(map[string]cty.Value{
+						"bar": cty.StringVal("beep"),
+					}),
+					cty.ObjectVal(map[string]cty.Value{
+						"bar": cty.NumberIntVal(8),
+					}),
+				}),
+			}),
+			cty.ObjectVal(map[string]cty.Value{
+				"foo": cty.TupleVal([]cty.Value{
+					cty.ObjectVal(map[string]cty.Value{
+						"bar": cty.StringVal("beep"),
+					}),
+					cty.ObjectVal(map[string]cty.Value{
+						"bar": cty.NumberIntVal(8),
+					}),
+				}),
+			}),
+			``,
+		},
+		"dynamic attributes in list of same type": {
+			&tfprotov5.SchemaBlock{
+				BlockTypes: []*tfprotov5.SchemaNestedBlock{
+					{
+						TypeName: "foo",
+						Nesting:  tfprotov5.SchemaNestedBlockNestingModeList,
+						Block: &tfprotov5.SchemaBlock{
+							Attributes: []*tfprotov5.SchemaAttribute{
+								{
+									Name:     "bar",
+									Type:     tftypes.DynamicPseudoType,
+									Optional: true,
+								},
+							},
+						},
+					},
+				},
+			},
+			cty.ObjectVal(map[string]cty.Value{
+				"foo": cty.TupleVal([]cty.Value{
+					cty.ObjectVal(map[string]cty.Value{
+						"bar": cty.StringVal("beep"),
+					}),
+					cty.ObjectVal(map[string]cty.Value{
+						"bar": cty.StringVal("boop"),
+					}),
+				}),
+			}),
+			cty.ObjectVal(map[string]cty.Value{
+				"foo": cty.ListVal([]cty.Value{
+					cty.ObjectVal(map[string]cty.Value{
+						"bar": cty.StringVal("beep"),
+					}),
+					cty.ObjectVal(map[string]cty.Value{
+						"bar": cty.StringVal("boop"),
+					}),
+				}),
+			}),
+			``,
+		},
+		"object-shaped map input": {
+			&tfprotov5.SchemaBlock{
+				BlockTypes: []*tfprotov5.SchemaNestedBlock{
+					{
+						TypeName: "foo",
+						Nesting:  tfprotov5.SchemaNestedBlockNestingModeMap,
+						Block: &tfprotov5.SchemaBlock{
+							Attributes: []*tfprotov5.SchemaAttribute{
+								{
+									Name:     "bar",
+									Type:     tftypes.String,
+									Optional: true,
+								},
+								{
+									Name:     "baz",
+									Type:     tftypes.Number,
+									Optional: true,
+								},
+							},
+						},
+					},
+				},
+			},
+			cty.ObjectVal(map[string]cty.Value{
+				"foo": cty.ObjectVal(map[string]cty.Value{
+					"a": cty.ObjectVal(map[string]cty.Value{
+						"bar": cty.StringVal("beep"),
+					}),
+					"b": cty.ObjectVal(map[string]cty.Value{
+						"baz": cty.StringVal("8"),
+					}),
+				}),
+			}),
+			cty.ObjectVal(map[string]cty.Value{
+				"foo": cty.MapVal(map[string]cty.Value{
+					"a": cty.ObjectVal(map[string]cty.Value{
+						"bar": cty.StringVal("beep"),
+						"baz": cty.NullVal(cty.Number),
+					}),
+					"b": cty.ObjectVal(map[string]cty.Value{
+						"bar": cty.NullVal(cty.String),
+						"baz": cty.NumberIntVal(8),
+					}),
+				}),
+			}),
+			``,
+		},
+		"object-shaped map input with invalid element": {
+			&tfprotov5.SchemaBlock{
+				BlockTypes: []*tfprotov5.SchemaNestedBlock{
+					{
+						TypeName: "foo",
+						Nesting:  tfprotov5.SchemaNestedBlockNestingModeMap,
+						Block: &tfprotov5.SchemaBlock{
+							Attributes: []*tfprotov5.SchemaAttribute{
+								{
+									Name:     "baz",
+									Type:     tftypes.Number,
+									Optional: true,
+								},
+							},
+						},
+					},
+				},
+			},
+			cty.ObjectVal(map[string]cty.Value{
+				"foo": cty.ObjectVal(map[string]cty.Value{
+					"a": cty.ObjectVal(map[string]cty.Value{
+						"baz": cty.StringVal("beep"),
+					}),
+				}),
+			}),
+			cty.DynamicVal,
+			`.foo["a"].baz: expected number, got string`,
+		},
 		"dynamic attributes in map": {
 			// Convert a block represented as a map to an object if a
 			// DynamicPseudoType causes the element types to mismatch.
@@ -608,6 +858,240 @@ func TestCoerceValue(t *testing.T) {
 	}
 }
 
+func TestCoerceAgainstSchema(t *testing.T) {
+	schema := &tfprotov5.SchemaBlock{
+		Attributes: []*tfprotov5.SchemaAttribute{
+			{Name: "foo", Type: tftypes.String, Required: true},
+		},
+	}
+
+	got, err := CoerceAgainstSchema(schema, cty.ObjectVal(map[string]cty.Value{"foo": cty.True}))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want := cty.ObjectVal(map[string]cty.Value{"foo": cty.StringVal("true")}); !got.RawEquals(want) {
+		t.Errorf("wrong result\ngot:  %#v\nwant: %#v", got, want)
+	}
+
+	_, err = CoerceAgainstSchema(schema, cty.EmptyObjectVal)
+	if err == nil || tfdiagsFormatError(err) != `attribute "foo" is required` {
+		t.Errorf("expected the missing required attribute to be reported, got %v", err)
+	}
+
+	if _, err := CoerceAgainstSchema(nil, cty.EmptyObjectVal); err == nil {
+		t.Error("expected a missing schema to be rejected")
+	}
+}
+
+func TestCoerceValueEnforceItemCounts(t *testing.T) {
+	schema := func(nesting tfprotov5.SchemaNestedBlockNestingMode) *tfprotov5.SchemaBlock {
+		return &tfprotov5.SchemaBlock{
+			BlockTypes: []*tfprotov5.SchemaNestedBlock{
+				{
+					TypeName: "foo",
+					Block: &tfprotov5.SchemaBlock{
+						Attributes: []*tfprotov5.SchemaAttribute{
+							{
+								Name:     "bar",
+								Type:     tftypes.String,
+								Optional: true,
+							},
+						},
+					},
+					Nesting:  nesting,
+					MinItems: 2,
+					MaxItems: 3,
+				},
+			},
+		}
+	}
+	single := func(minItems int64) *tfprotov5.SchemaBlock {
+		block := schema(tfprotov5.SchemaNestedBlockNestingModeSingle)
+		block.BlockTypes[0].MinItems = minItems
+		block.BlockTypes[0].MaxItems = 1
+		return block
+	}
+	items := func(n int) []cty.Value {
+		var vals []cty.Value
+		for i := 0; i < n; i++ {
+			vals = append(vals, cty.ObjectVal(map[string]cty.Value{
+				"bar": cty.StringVal(fmt.Sprintf("item%d", i)),
+			}))
+		}
+		return vals
+	}
+
+	tests := map[string]struct {
+		Schema  *tfprotov5.SchemaBlock
+		Input   cty.Value
+		WantErr string
+	}{
+		"list under count": {
+			schema(tfprotov5.SchemaNestedBlockNestingModeList),
+			cty.ObjectVal(map[string]cty.Value{"foo": cty.ListVal(items(1))}),
+			`.foo: too few blocks (2 required, 1 given)`,
+		},
+		"list absent": {
+			schema(tfprotov5.SchemaNestedBlockNestingModeList),
+			cty.EmptyObjectVal,
+			`.foo: too few blocks (2 required, 0 given)`,
+		},
+		"list over count": {
+			schema(tfprotov5.SchemaNestedBlockNestingModeList),
+			cty.ObjectVal(map[string]cty.Value{"foo": cty.ListVal(items(4))}),
+			`.foo: too many blocks (3 allowed, 4 given)`,
+		},
+		"list within bounds": {
+			schema(tfprotov5.SchemaNestedBlockNestingModeList),
+			cty.ObjectVal(map[string]cty.Value{"foo": cty.ListVal(items(2))}),
+			``,
+		},
+		"list unknown": {
+			schema(tfprotov5.SchemaNestedBlockNestingModeList),
+			cty.ObjectVal(map[string]cty.Value{"foo": cty.UnknownVal(cty.List(cty.Object(map[string]cty.Type{"bar": cty.String})))}),
+			``,
+		},
+		"set under count": {
+			schema(tfprotov5.SchemaNestedBlockNestingModeSet),
+			cty.ObjectVal(map[string]cty.Value{"foo": cty.SetVal(items(1))}),
+			`.foo: too few blocks (2 required, 1 given)`,
+		},
+		"set over count": {
+			schema(tfprotov5.SchemaNestedBlockNestingModeSet),
+			cty.ObjectVal(map[string]cty.Value{"foo": cty.SetVal(items(4))}),
+			`.foo: too many blocks (3 allowed, 4 given)`,
+		},
+		"set within bounds": {
+			schema(tfprotov5.SchemaNestedBlockNestingModeSet),
+			cty.ObjectVal(map[string]cty.Value{"foo": cty.SetVal(items(3))}),
+			``,
+		},
+		"required single absent": {
+			single(1),
+			cty.EmptyObjectVal,
+			`.foo: too few blocks (1 required, 0 given)`,
+		},
+		"required single null": {
+			single(1),
+			cty.ObjectVal(map[string]cty.Value{"foo": cty.NullVal(cty.Object(map[string]cty.Type{"bar": cty.String}))}),
+			`.foo: too few blocks (1 required, 0 given)`,
+		},
+		"required single given": {
+			single(1),
+			cty.ObjectVal(map[string]cty.Value{"foo": items(1)[0]}),
+			``,
+		},
+		"optional single absent": {
+			single(0),
+			cty.EmptyObjectVal,
+			``,
+		},
+		"optional single null": {
+			single(0),
+			cty.ObjectVal(map[string]cty.Value{"foo": cty.NullVal(cty.Object(map[string]cty.Type{"bar": cty.String}))}),
+			``,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := WrapBlock(test.Schema).CoerceValueWithOptions(test.Input, CoerceOptions{EnforceItemCounts: true})
+			if err == nil {
+				if test.WantErr != "" {
+					t.Fatalf("coersion succeeded; want error: %q", test.WantErr)
+				}
+				return
+			}
+			if got := tfdiagsFormatError(err); got != test.WantErr {
+				t.Fatalf("wrong error\ngot:  %s\nwant: %s", got, test.WantErr)
+			}
+			if _, err := WrapBlock(test.Schema).CoerceValue(test.Input); err != nil {
+				t.Errorf("default coercion should stay permissive, got %s", err)
+			}
+		})
+	}
+}
+
+func TestCoerceValueLenient(t *testing.T) {
+	schema := WrapBlock(&tfprotov5.SchemaBlock{
+		Attributes: []*tfprotov5.SchemaAttribute{
+			{
+				Name:     "name",
+				Type:     tftypes.String,
+				Required: true,
+			},
+		},
+		BlockTypes: []*tfprotov5.SchemaNestedBlock{
+			{
+				TypeName: "rule",
+				Nesting:  tfprotov5.SchemaNestedBlockNestingModeList,
+				Block: &tfprotov5.SchemaBlock{
+					Attributes: []*tfprotov5.SchemaAttribute{
+						{
+							Name:     "priority",
+							Type:     tftypes.Number,
+							Required: true,
+						},
+					},
+				},
+			},
+		},
+	})
+	in := cty.ObjectVal(map[string]cty.Value{
+		"rule": cty.ListVal([]cty.Value{cty.EmptyObjectVal}),
+	})
+
+	got, err := schema.CoerceValueLenient(in)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := cty.ObjectVal(map[string]cty.Value{
+		"name": cty.NullVal(cty.String),
+		"rule": cty.ListVal([]cty.Value{
+			cty.ObjectVal(map[string]cty.Value{
+				"priority": cty.NullVal(cty.Number),
+			}),
+		}),
+	})
+	if !got.RawEquals(want) {
+		t.Errorf("wrong result\ngot:  %#v\nwant: %#v", got, want)
+	}
+
+	if _, err := schema.CoerceValue(in); err == nil || tfdiagsFormatError(err) != `attribute "name" is required` {
+		t.Errorf("strict coercion should still fail on the missing attribute, got %v", err)
+	}
+}
+
+func TestCoerceValueUnconvertibleType(t *testing.T) {
+	schema := WrapBlock(&tfprotov5.SchemaBlock{
+		Attributes: []*tfprotov5.SchemaAttribute{
+			{Name: "name", Type: tftypes.String, Optional: true},
+			{Name: "settings", Type: optionalAttrsType, Optional: true},
+		},
+	})
+	in := cty.ObjectVal(map[string]cty.Value{
+		"name":     cty.StringVal("web"),
+		"settings": cty.ObjectVal(map[string]cty.Value{"a": cty.StringVal("b")}),
+	})
+
+	_, err := schema.CoerceValue(in)
+	if err == nil || !strings.HasPrefix(tfdiagsFormatError(err), ".settings: unsupported type") {
+		t.Errorf("expected the unconvertible attribute to be reported, got %v", err)
+	}
+
+	got, err := schema.CoerceValueWithOptions(in, CoerceOptions{SkipUnconvertibleTypes: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := cty.ObjectVal(map[string]cty.Value{
+		"name":     cty.StringVal("web"),
+		"settings": cty.NullVal(cty.DynamicPseudoType),
+	})
+	if !got.RawEquals(want) {
+		t.Errorf("wrong result\ngot:  %#v\nwant: %#v", got, want)
+	}
+}
+
 // FormatError is a helper function to produce a user-friendly string
 // representation of certain special error types that we might want to
 // include in diagnostic messages.
@@ -654,3 +1138,41 @@ func tfdiagsFormatCtyPath(path cty.Path) string {
 	}
 	return buf.String()
 }
+
+func TestCoerceValueSetDuplicates(t *testing.T) {
+	schema := WrapBlock(&tfprotov5.SchemaBlock{
+		BlockTypes: []*tfprotov5.SchemaNestedBlock{{
+			TypeName: "rule",
+			Nesting:  tfprotov5.SchemaNestedBlockNestingModeSet,
+			Block: &tfprotov5.SchemaBlock{Attributes: []*tfprotov5.SchemaAttribute{
+				{Name: "port", Type: tftypes.Number, Optional: true},
+				{Name: "value", Type: tftypes.DynamicPseudoType, Optional: true},
+			}},
+		}},
+	})
+
+	got, err := schema.CoerceValue(cty.ObjectVal(map[string]cty.Value{
+		"rule": cty.TupleVal([]cty.Value{
+			cty.ObjectVal(map[string]cty.Value{"port": cty.StringVal("22")}),
+			cty.ObjectVal(map[string]cty.Value{"port": cty.NumberIntVal(22)}),
+		}),
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	rules := got.GetAttr("rule")
+	want := cty.ObjectVal(map[string]cty.Value{"port": cty.NumberIntVal(22), "value": cty.NullVal(cty.DynamicPseudoType)})
+	if rules.LengthInt() != 1 || !rules.AsValueSlice()[0].RawEquals(want) {
+		t.Errorf("expected the rules to coerce to the single %#v, got %#v", want, rules)
+	}
+
+	_, err = schema.CoerceValue(cty.ObjectVal(map[string]cty.Value{
+		"rule": cty.TupleVal([]cty.Value{
+			cty.ObjectVal(map[string]cty.Value{"value": cty.StringVal("a")}),
+			cty.ObjectVal(map[string]cty.Value{"value": cty.True}),
+		}),
+	}))
+	if err == nil || !strings.HasPrefix(tfdiagsFormatError(err), ".rule[1]: set elements must all have the same type") {
+		t.Errorf("expected the inconsistent set elements to be reported, got %v", err)
+	}
+}
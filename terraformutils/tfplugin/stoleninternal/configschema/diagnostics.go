@@ -0,0 +1,119 @@
+package configschema
+
+import (
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-go/tfprotov5"
+)
+
+// DiagnosticCategory is a common kind of provider failure, guessed from the
+// wording of error diagnostics since providers don't report one.
+type DiagnosticCategory int
+
+const (
+	// DiagnosticUncategorized is any failure not recognized as another
+	// category, or no failure at all.
+	DiagnosticUncategorized DiagnosticCategory = iota
+	// DiagnosticThrottling is a request rejected by rate limiting or failed
+	// by a transient backend or network outage, worth retrying later.
+	DiagnosticThrottling
+	// DiagnosticAuth is a request rejected for missing credentials or
+	// permissions.
+	DiagnosticAuth
+	// DiagnosticNotFound is a request for an object that doesn't exist,
+	// e.g. a resource deleted since it was listed.
+	DiagnosticNotFound
+)
+
+func (c DiagnosticCategory) String() string {
+	switch c {
+	case DiagnosticThrottling:
+		return "throttling"
+	case DiagnosticAuth:
+		return "auth"
+	case DiagnosticNotFound:
+		return "not found"
+	}
+	return "uncategorized"
+}
+
+// diagnosticCategoryPatterns are matched in order against the lowercased
+// summary and detail, so throttling wins over a not found in the same
+// message, as in "throttled, resource not found yet".
+var diagnosticCategoryPatterns = []struct {
+	category DiagnosticCategory
+	patterns []string
+}{
+	{DiagnosticThrottling, []string{
+		"throttl",
+		"rate exceeded",
+		"rate limit",
+		"too many requests",
+		"quota exceeded",
+		"temporarily unavailable",
+		"try again later",
+		"timeout",
+		"timed out",
+		"connection reset",
+	}},
+	{DiagnosticAuth, []string{
+		"access denied",
+		"accessdenied",
+		"permission denied",
+		"forbidden",
+		"unauthorized",
+		"not authorized",
+		"invalid credentials",
+		"expired token",
+		"token has expired",
+	}},
+	{DiagnosticNotFound, []string{
+		"not found",
+		"notfound",
+		"does not exist",
+		"no such",
+	}},
+}
+
+// CategorizeDiagnostic guesses the category of one diagnostic from its
+// summary and detail.
+func CategorizeDiagnostic(diag *tfprotov5.Diagnostic) DiagnosticCategory {
+	if diag == nil {
+		return DiagnosticUncategorized
+	}
+	return CategorizeMessage(diag.Summary + "\n" + diag.Detail)
+}
+
+// CategorizeMessage guesses the category of a failure from its message, for
+// errors that come without diagnostics, such as transport errors.
+func CategorizeMessage(message string) DiagnosticCategory {
+	message = strings.ToLower(message)
+	for _, c := range diagnosticCategoryPatterns {
+		for _, pattern := range c.patterns {
+			if strings.Contains(message, pattern) {
+				return c.category
+			}
+		}
+	}
+	return DiagnosticUncategorized
+}
+
+// Category returns the category of the first categorized error severity
+// Diagnostic, DiagnosticUncategorized when none is.
+func (d Diagnostics) Category() DiagnosticCategory {
+	for _, diag := range d.diags {
+		if diag == nil || diag.Severity != tfprotov5.DiagnosticSeverityError {
+			continue
+		}
+		if category := CategorizeDiagnostic(diag); category != DiagnosticUncategorized {
+			return category
+		}
+	}
+	return DiagnosticUncategorized
+}
+
+// Diagnostics returns the wrapped diagnostics, for callers that need more
+// than ToError and Category tell.
+func (d Diagnostics) Diagnostics() []*tfprotov5.Diagnostic {
+	return d.diags
+}
@@ -0,0 +1,46 @@
+package configschema
+
+import (
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-go/tfprotov5"
+)
+
+func TestDiagnosticsCategory(t *testing.T) {
+	for _, test := range []struct {
+		summary string
+		detail  string
+		want    DiagnosticCategory
+	}{
+		{"Error reading EC2 instance", "Throttling: Rate exceeded\n\tstatus code: 400", DiagnosticThrottling},
+		{"googleapi: Error 429: Quota exceeded for quota metric 'Read requests'", "", DiagnosticThrottling},
+		{"Error: AccessDenied: User is not authorized to perform: s3:GetBucketPolicy", "", DiagnosticAuth},
+		{"googleapi: Error 403: Required 'compute.networks.get' permission", "Forbidden", DiagnosticAuth},
+		{"Error: ResourceNotFoundException: Function not found", "", DiagnosticNotFound},
+		{"Error reading network", "The resource 'projects/p/networks/n' does not exist", DiagnosticNotFound},
+		{"request throttled, resource not found yet", "", DiagnosticThrottling},
+		{"Invalid attribute value", "expected a number", DiagnosticUncategorized},
+	} {
+		t.Run(test.summary, func(t *testing.T) {
+			diag := &tfprotov5.Diagnostic{Severity: tfprotov5.DiagnosticSeverityError, Summary: test.summary, Detail: test.detail}
+			diags := WrapDiagnostics([]*tfprotov5.Diagnostic{diag})
+			if got := diags.Category(); got != test.want {
+				t.Errorf("expected %s, got %s", test.want, got)
+			}
+			if got := diags.Diagnostics(); len(got) != 1 || got[0] != diag {
+				t.Errorf("expected the original diagnostic, got %v", got)
+			}
+		})
+	}
+}
+
+func TestDiagnosticsCategoryIgnoresWarnings(t *testing.T) {
+	diags := WrapDiagnostics([]*tfprotov5.Diagnostic{
+		{Severity: tfprotov5.DiagnosticSeverityWarning, Summary: "rate limit almost reached"},
+		{Severity: tfprotov5.DiagnosticSeverityError, Summary: "unknown failure"},
+		{Severity: tfprotov5.DiagnosticSeverityError, Summary: "bucket does not exist"},
+	})
+	if got := diags.Category(); got != DiagnosticNotFound {
+		t.Errorf("expected the first categorized error to win, got %s", got)
+	}
+}
@@ -1,6 +1,8 @@
 package configschema
 
 import (
+	"fmt"
+
 	"github.com/hashicorp/go-cty/cty"
 	"github.com/hashicorp/terraform-plugin-go/tfprotov5"
 )
@@ -9,17 +11,39 @@ import (
 // configuration block using the receiving block schema.
 //
 // ImpliedType always returns a result, even if the given schema is
-// inconsistent.
+// inconsistent. Attributes whose type has no cty equivalent are given
+// cty.DynamicPseudoType, so they accept any value.
 func (b *Block) ImpliedType() cty.Type {
+	ty, _ := b.impliedType("")
+	return ty
+}
+
+// ImpliedTypeErr is like ImpliedType, but also returns an error naming the
+// first attribute whose type can't be converted to a cty.Type.
+func (b *Block) ImpliedTypeErr() (cty.Type, error) {
+	return b.impliedType("")
+}
+
+// impliedType builds the implied type of b, reporting attributes by their
+// dotted path below prefix.
+func (b *Block) impliedType(prefix string) (cty.Type, error) {
 	if b == nil {
-		return cty.EmptyObject
+		return cty.EmptyObject, nil
 	}
 
 	atys := make(map[string]cty.Type)
+	var typeErr error
 
 	for _, attrS := range b.Attributes {
 		name := attrS.Name
-		atys[name] = WrapType(attrS.Type)
+		ty, err := WrapTypeErr(attrS.Type)
+		if err != nil {
+			if typeErr == nil {
+				typeErr = fmt.Errorf("attribute %q: %w", prefix+name, err)
+			}
+			ty = cty.DynamicPseudoType
+		}
+		atys[name] = ty
 	}
 
 	for _, blockS := range b.BlockTypes {
@@ -28,7 +52,10 @@ func (b *Block) ImpliedType() cty.Type {
 			panic("invalid schema, blocks and attributes cannot have the same name")
 		}
 
-		childType := WrapBlock(blockS.Block).ImpliedType()
+		childType, err := WrapBlock(blockS.Block).impliedType(prefix + name + ".")
+		if err != nil && typeErr == nil {
+			typeErr = err
+		}
 
 		switch blockS.Nesting {
 		case tfprotov5.SchemaNestedBlockNestingModeSingle, tfprotov5.SchemaNestedBlockNestingModeGroup:
@@ -46,10 +73,16 @@ func (b *Block) ImpliedType() cty.Type {
 				atys[name] = cty.List(childType)
 			}
 		case tfprotov5.SchemaNestedBlockNestingModeSet:
+			// Terraform rejects dynamically-typed attributes inside set
+			// blocks, but some providers ship such schemas anyway. Rather
+			// than failing on the whole provider, fall back to the same
+			// cty.DynamicPseudoType constraint used for lists and maps so
+			// each value can still settle on its own concrete type.
 			if childType.HasDynamicTypes() {
-				panic("can't use cty.DynamicPseudoType inside a block type with tfprotov5.SchemaNestedBlockNestingModeSet")
+				atys[name] = cty.DynamicPseudoType
+			} else {
+				atys[name] = cty.Set(childType)
 			}
-			atys[name] = cty.Set(childType)
 		case tfprotov5.SchemaNestedBlockNestingModeMap:
 			// We prefer to use a map where possible, since it makes our
 			// implied type more complete, but if there are any
@@ -67,5 +100,5 @@ func (b *Block) ImpliedType() cty.Type {
 		}
 	}
 
-	return cty.Object(atys)
+	return cty.Object(atys), typeErr
 }
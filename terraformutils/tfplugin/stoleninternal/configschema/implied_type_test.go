@@ -1,6 +1,7 @@
 package configschema
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/hashicorp/go-cty/cty"
@@ -125,6 +126,28 @@ func TestBlockImpliedType(t *testing.T) {
 				}),
 			}),
 		},
+		"dynamic attribute in set block": {
+			&tfprotov5.SchemaBlock{
+				BlockTypes: []*tfprotov5.SchemaNestedBlock{
+					{
+						TypeName: "set",
+						Nesting:  tfprotov5.SchemaNestedBlockNestingModeSet,
+						Block: &tfprotov5.SchemaBlock{
+							Attributes: []*tfprotov5.SchemaAttribute{
+								{
+									Name:     "value",
+									Type:     tftypes.DynamicPseudoType,
+									Optional: true,
+								},
+							},
+						},
+					},
+				},
+			},
+			cty.Object(map[string]cty.Type{
+				"set": cty.DynamicPseudoType,
+			}),
+		},
 	}
 
 	for name, test := range tests {
@@ -136,3 +159,37 @@ func TestBlockImpliedType(t *testing.T) {
 		})
 	}
 }
+
+func TestBlockImpliedTypeErr(t *testing.T) {
+	schema := WrapBlock(&tfprotov5.SchemaBlock{
+		Attributes: []*tfprotov5.SchemaAttribute{
+			{Name: "name", Type: tftypes.String, Optional: true},
+		},
+		BlockTypes: []*tfprotov5.SchemaNestedBlock{
+			{
+				TypeName: "network",
+				Nesting:  tfprotov5.SchemaNestedBlockNestingModeList,
+				Block: &tfprotov5.SchemaBlock{
+					Attributes: []*tfprotov5.SchemaAttribute{
+						{Name: "settings", Type: optionalAttrsType, Optional: true},
+					},
+				},
+			},
+		},
+	})
+
+	_, err := schema.ImpliedTypeErr()
+	if err == nil || !strings.HasPrefix(err.Error(), `attribute "network.settings": unsupported type`) {
+		t.Errorf("expected the unconvertible attribute to be named, got %v", err)
+	}
+	if got := schema.ImpliedType().AttributeType("name"); !got.Equals(cty.String) {
+		t.Errorf("expected ImpliedType to still return a result, got %#v", got)
+	}
+
+	valid := WrapBlock(&tfprotov5.SchemaBlock{
+		Attributes: []*tfprotov5.SchemaAttribute{{Name: "name", Type: tftypes.String, Optional: true}},
+	})
+	if got, err := valid.ImpliedTypeErr(); err != nil || !got.Equals(cty.Object(map[string]cty.Type{"name": cty.String})) {
+		t.Errorf("expected the implied type of a valid schema, got %#v, %v", got, err)
+	}
+}
@@ -2,35 +2,75 @@ package configschema
 
 import (
 	"fmt"
+	"sync"
 
 	"github.com/hashicorp/go-cty/cty"
 	"github.com/hashicorp/terraform-plugin-go/tfprotov5"
 	"github.com/hashicorp/terraform-plugin-go/tftypes"
 )
 
+// wrappedTypes and unwrappedTypes memoize WrapTypeErr and UnwrapType, keyed
+// on the JSON encoding of the converted type: parsing it back is what's
+// costly, and ImpliedType and CoerceValue convert the same types over and
+// over.
+var (
+	wrappedTypes   sync.Map // string -> wrappedType
+	unwrappedTypes sync.Map // string -> tftypes.Type
+)
+
+type wrappedType struct {
+	ty  cty.Type
+	err error
+}
+
 func UnwrapType(t cty.Type) tftypes.Type {
+	if t == cty.NilType {
+		return tftypes.Object{}
+	}
 	b, err := t.MarshalJSON()
 	if err != nil {
 		return tftypes.Object{}
 	}
+	if cached, ok := unwrappedTypes.Load(string(b)); ok {
+		return cached.(tftypes.Type)
+	}
 	tftype, err := tftypes.ParseJSONType(b)
 	if err != nil {
-		return tftypes.Object{}
+		tftype = tftypes.Object{}
 	}
+	unwrappedTypes.Store(string(b), tftype)
 	return tftype
 }
 
+// WrapType is WrapTypeErr returning cty.NilType for types it can't convert.
 func WrapType(t tftypes.Type) cty.Type {
-	b, err := t.MarshalJSON()
+	ty, err := WrapTypeErr(t)
 	if err != nil {
 		return cty.NilType
 	}
-	var ctype cty.Type
-	err = ctype.UnmarshalJSON(b)
+	return ty
+}
+
+// WrapTypeErr converts t to the equivalent cty.Type, failing for types cty
+// has no equivalent of, such as objects with optional attributes.
+func WrapTypeErr(t tftypes.Type) (cty.Type, error) {
+	if t == nil {
+		return cty.NilType, fmt.Errorf("missing type")
+	}
+	b, err := t.MarshalJSON()
 	if err != nil {
-		return cty.NilType
+		return cty.NilType, fmt.Errorf("can't encode type %s: %w", t, err)
 	}
-	return ctype
+	if cached, ok := wrappedTypes.Load(string(b)); ok {
+		wrapped := cached.(wrappedType)
+		return wrapped.ty, wrapped.err
+	}
+	var wrapped wrappedType
+	if err := wrapped.ty.UnmarshalJSON(b); err != nil {
+		wrapped = wrappedType{cty.NilType, fmt.Errorf("unsupported type %s: %w", t, err)}
+	}
+	wrappedTypes.Store(string(b), wrapped)
+	return wrapped.ty, wrapped.err
 }
 
 type Block struct {
@@ -56,10 +96,27 @@ type NestedBlock struct {
 	tfprotov5.SchemaNestedBlock
 }
 
-func WrapNestedBlock(b *tfprotov5.SchemaNestedBlock) *Block {
+// WrapNestedBlock wraps b, keeping its nesting mode and item bounds, so that
+// EmptyValue honors them; WrappedBlock returns the schema of its content.
+func WrapNestedBlock(b *tfprotov5.SchemaNestedBlock) *NestedBlock {
+	return &NestedBlock{*b}
+}
+
+// WrappedBlock returns the schema of the content of the nested block.
+func (b *NestedBlock) WrappedBlock() *Block {
+	if b.Block == nil {
+		return &Block{}
+	}
 	return &Block{*b.Block}
 }
 
+// WrapNestedBlockContent wraps the content of b, as WrapNestedBlock used to.
+//
+// Deprecated: use WrapNestedBlock(b).WrappedBlock().
+func WrapNestedBlockContent(b *tfprotov5.SchemaNestedBlock) *Block {
+	return WrapNestedBlock(b).WrappedBlock()
+}
+
 type Diagnostics struct {
 	diags []*tfprotov5.Diagnostic
 }
@@ -78,6 +135,20 @@ func (d Diagnostics) HasError() bool {
 	return false
 }
 
+// Warnings returns the warning severity Diagnostics, e.g. deprecation
+// notices, which ToError leaves out.
+func (d Diagnostics) Warnings() []string {
+	var warnings []string
+	for _, diag := range d.diags {
+		if diag.Severity == tfprotov5.DiagnosticSeverityWarning {
+			warnings = append(warnings, fmt.Sprintf("warning %s on %v: %s", diag.Summary, diag.Attribute, diag.Detail))
+		}
+	}
+	return warnings
+}
+
+// ToError folds the error severity Diagnostics into one error, or returns nil
+// when there are none.
 func (d Diagnostics) ToError() error {
 	var errs []error
 	for _, diag := range d.diags {
@@ -85,8 +156,11 @@ func (d Diagnostics) ToError() error {
 			errs = append(errs, fmt.Errorf("error %s on %v: %s", diag.Summary, diag.Attribute, diag.Detail))
 		}
 	}
-	if len(d.diags) == 1 {
-		return fmt.Errorf("%s", errs[0])
+	if len(errs) == 0 {
+		return nil
+	}
+	if len(errs) == 1 {
+		return errs[0]
 	}
 	msg := "encountered errors:"
 	for _, err := range errs {
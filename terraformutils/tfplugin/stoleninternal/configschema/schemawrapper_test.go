@@ -0,0 +1,167 @@
+package configschema
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov5"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+)
+
+func TestDiagnosticsMixedSeverities(t *testing.T) {
+	diags := WrapDiagnostics([]*tfprotov5.Diagnostic{
+		{Severity: tfprotov5.DiagnosticSeverityWarning, Summary: "deprecated", Detail: "use new_attr"},
+		{Severity: tfprotov5.DiagnosticSeverityError, Summary: "failed", Detail: "boom"},
+		{Severity: tfprotov5.DiagnosticSeverityWarning, Summary: "slow", Detail: "retrying"},
+	})
+
+	if !diags.HasError() {
+		t.Error("expected diagnostics to have an error")
+	}
+	warnings := diags.Warnings()
+	if len(warnings) != 2 || !strings.Contains(warnings[0], "deprecated") || !strings.Contains(warnings[1], "slow") {
+		t.Errorf("expected both warnings, got %q", warnings)
+	}
+	err := diags.ToError()
+	if err == nil || !strings.Contains(err.Error(), "failed") || strings.Contains(err.Error(), "deprecated") {
+		t.Errorf("expected only the error in ToError, got %v", err)
+	}
+
+	warningsOnly := WrapDiagnostics([]*tfprotov5.Diagnostic{
+		{Severity: tfprotov5.DiagnosticSeverityWarning, Summary: "deprecated"},
+	})
+	if warningsOnly.HasError() {
+		t.Error("expected warnings alone not to be an error")
+	}
+	if len(warningsOnly.Warnings()) != 1 {
+		t.Errorf("expected one warning, got %q", warningsOnly.Warnings())
+	}
+}
+
+func TestDiagnosticsToErrorSingleWarning(t *testing.T) {
+	diags := WrapDiagnostics([]*tfprotov5.Diagnostic{
+		{Severity: tfprotov5.DiagnosticSeverityWarning, Summary: "deprecated"},
+	})
+	if err := diags.ToError(); err != nil {
+		t.Errorf("expected no error from a lone warning, got %v", err)
+	}
+}
+
+func TestDiagnosticsToErrorSingleError(t *testing.T) {
+	diags := WrapDiagnostics([]*tfprotov5.Diagnostic{
+		{Severity: tfprotov5.DiagnosticSeverityError, Summary: "failed", Detail: "boom"},
+	})
+	err := diags.ToError()
+	if err == nil || strings.Contains(err.Error(), "encountered errors") {
+		t.Errorf("expected the lone error unwrapped, got %v", err)
+	}
+}
+
+func TestWrapNestedBlockKeepsNesting(t *testing.T) {
+	blockS := &tfprotov5.SchemaNestedBlock{
+		TypeName: "rule",
+		Nesting:  tfprotov5.SchemaNestedBlockNestingModeList,
+		MinItems: 1,
+		MaxItems: 3,
+		Block: &tfprotov5.SchemaBlock{Attributes: []*tfprotov5.SchemaAttribute{
+			{Name: "port", Type: tftypes.Number, Optional: true},
+		}},
+	}
+	nested := WrapNestedBlock(blockS)
+	if nested.Nesting != tfprotov5.SchemaNestedBlockNestingModeList || nested.MinItems != 1 || nested.MaxItems != 3 {
+		t.Errorf("expected list nesting with 1 to 3 items, got %v with %d to %d", nested.Nesting, nested.MinItems, nested.MaxItems)
+	}
+	elemType := cty.Object(map[string]cty.Type{"port": cty.Number})
+	if got := nested.WrappedBlock().ImpliedType(); !got.Equals(elemType) {
+		t.Errorf("expected the content type %#v, got %#v", elemType, got)
+	}
+	if got := nested.EmptyValue(); !got.RawEquals(cty.ListValEmpty(elemType)) {
+		t.Errorf("expected an empty list, got %#v", got)
+	}
+	if got := WrapNestedBlockContent(blockS).ImpliedType(); !got.Equals(elemType) {
+		t.Errorf("expected the shim to wrap the content, got %#v", got)
+	}
+}
+
+// deepType returns an object type nesting lists of objects depth levels deep,
+// with width attributes per level.
+func deepType(depth, width int) tftypes.Type {
+	attrs := map[string]tftypes.Type{}
+	for i := 0; i < width; i++ {
+		attrs[fmt.Sprintf("attr%d", i)] = tftypes.String
+	}
+	if depth > 0 {
+		attrs["nested"] = tftypes.List{ElementType: deepType(depth-1, width)}
+	}
+	return tftypes.Object{AttributeTypes: attrs}
+}
+
+func TestWrapTypeCached(t *testing.T) {
+	ty := deepType(4, 5)
+	want := WrapType(ty)
+	if !want.IsObjectType() || !want.AttributeType("nested").ElementType().IsObjectType() {
+		t.Fatalf("expected a nested object type, got %#v", want)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// a fresh but equal type must hit the same cache entry
+			if got := WrapType(deepType(4, 5)); !got.Equals(want) {
+				t.Errorf("expected %#v, got %#v", want, got)
+			}
+			if got := UnwrapType(want); !got.Equal(ty) {
+				t.Errorf("expected %s, got %s", ty, got)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := WrapType(tftypes.List{ElementType: tftypes.Number}); !got.Equals(cty.List(cty.Number)) {
+		t.Errorf("expected a different type not to collide in the cache, got %#v", got)
+	}
+}
+
+// optionalAttrsType is an object type with optional attributes, which
+// tftypes can express but cty can't parse back.
+var optionalAttrsType = tftypes.Object{
+	AttributeTypes:     map[string]tftypes.Type{"a": tftypes.String},
+	OptionalAttributes: map[string]struct{}{"a": {}},
+}
+
+func TestWrapTypeInvalid(t *testing.T) {
+	if got := WrapType(nil); got != cty.NilType {
+		t.Errorf("expected nil to wrap to cty.NilType, got %#v", got)
+	}
+	if _, err := WrapTypeErr(nil); err == nil {
+		t.Error("expected an error wrapping a nil type")
+	}
+	for i := 0; i < 2; i++ {
+		if got := WrapType(optionalAttrsType); got != cty.NilType {
+			t.Errorf("call %d: expected an unsupported type to wrap to cty.NilType, got %#v", i+1, got)
+		}
+		got, err := WrapTypeErr(optionalAttrsType)
+		if err == nil || !strings.Contains(err.Error(), "unsupported type") || got != cty.NilType {
+			t.Errorf("call %d: expected an unsupported type error, got %#v, %v", i+1, got, err)
+		}
+	}
+	if got, err := WrapTypeErr(tftypes.Map{ElementType: tftypes.Bool}); err != nil || !got.Equals(cty.Map(cty.Bool)) {
+		t.Errorf("expected a map of bool, got %#v, %v", got, err)
+	}
+	if got, ok := UnwrapType(cty.NilType).(tftypes.Object); !ok || len(got.AttributeTypes) != 0 {
+		t.Errorf("expected cty.NilType to unwrap to an empty object, got %s", got)
+	}
+}
+
+func BenchmarkWrapType(b *testing.B) {
+	ty := deepType(6, 10)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		WrapType(ty)
+	}
+}
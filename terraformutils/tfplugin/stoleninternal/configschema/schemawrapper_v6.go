@@ -0,0 +1,65 @@
+package configschema
+
+import (
+	"github.com/hashicorp/terraform-plugin-go/tfprotov5"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov6"
+)
+
+// The protocol 6 schema and diagnostics are a superset of the protocol 5
+// ones, so they are downgraded to their protocol 5 equivalent and share the
+// wrappers above. Enum values are identical across both protocols.
+
+// WrapBlockV6 wraps a protocol 6 schema block. Nested attributes, which
+// protocol 5 can't express, become plain attributes of their value type.
+func WrapBlockV6(b *tfprotov6.SchemaBlock) *Block {
+	if b == nil {
+		return nil
+	}
+	return WrapBlock(downgradeBlock(b))
+}
+
+// WrapDiagnosticsV6 wraps protocol 6 diagnostics.
+func WrapDiagnosticsV6(ds []*tfprotov6.Diagnostic) *Diagnostics {
+	diags := make([]*tfprotov5.Diagnostic, 0, len(ds))
+	for _, d := range ds {
+		diags = append(diags, &tfprotov5.Diagnostic{
+			Severity:  tfprotov5.DiagnosticSeverity(d.Severity),
+			Summary:   d.Summary,
+			Detail:    d.Detail,
+			Attribute: d.Attribute,
+		})
+	}
+	return WrapDiagnostics(diags)
+}
+
+func downgradeBlock(b *tfprotov6.SchemaBlock) *tfprotov5.SchemaBlock {
+	block := &tfprotov5.SchemaBlock{
+		Version:         b.Version,
+		Description:     b.Description,
+		DescriptionKind: tfprotov5.StringKind(b.DescriptionKind),
+		Deprecated:      b.Deprecated,
+	}
+	for _, a := range b.Attributes {
+		block.Attributes = append(block.Attributes, &tfprotov5.SchemaAttribute{
+			Name:            a.Name,
+			Type:            a.ValueType(),
+			Description:     a.Description,
+			Required:        a.Required,
+			Optional:        a.Optional,
+			Computed:        a.Computed,
+			Sensitive:       a.Sensitive,
+			DescriptionKind: tfprotov5.StringKind(a.DescriptionKind),
+			Deprecated:      a.Deprecated,
+		})
+	}
+	for _, nb := range b.BlockTypes {
+		block.BlockTypes = append(block.BlockTypes, &tfprotov5.SchemaNestedBlock{
+			TypeName: nb.TypeName,
+			Block:    downgradeBlock(nb.Block),
+			Nesting:  tfprotov5.SchemaNestedBlockNestingMode(nb.Nesting),
+			MinItems: nb.MinItems,
+			MaxItems: nb.MaxItems,
+		})
+	}
+	return block
+}
@@ -0,0 +1,47 @@
+package configschema
+
+import (
+	"testing"
+
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov6"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+)
+
+func TestWrapBlockV6NestedAttributes(t *testing.T) {
+	block := WrapBlockV6(&tfprotov6.SchemaBlock{
+		Attributes: []*tfprotov6.SchemaAttribute{
+			{Name: "name", Type: tftypes.String, Required: true},
+			{Name: "rules", Optional: true, NestedType: &tfprotov6.SchemaObject{
+				Nesting:    tfprotov6.SchemaObjectNestingModeList,
+				Attributes: []*tfprotov6.SchemaAttribute{{Name: "port", Type: tftypes.Number, Optional: true}},
+			}},
+		},
+		BlockTypes: []*tfprotov6.SchemaNestedBlock{{
+			TypeName: "timeouts",
+			Nesting:  tfprotov6.SchemaNestedBlockNestingModeSingle,
+			Block:    &tfprotov6.SchemaBlock{Attributes: []*tfprotov6.SchemaAttribute{{Name: "create", Type: tftypes.String, Optional: true}}},
+		}},
+	})
+	want := cty.Object(map[string]cty.Type{
+		"name":     cty.String,
+		"rules":    cty.List(cty.Object(map[string]cty.Type{"port": cty.Number})),
+		"timeouts": cty.Object(map[string]cty.Type{"create": cty.String}),
+	})
+	if got := block.ImpliedType(); !got.Equals(want) {
+		t.Errorf("wrong implied type\ngot:  %#v\nwant: %#v", got, want)
+	}
+}
+
+func TestWrapDiagnosticsV6(t *testing.T) {
+	diags := WrapDiagnosticsV6([]*tfprotov6.Diagnostic{
+		{Severity: tfprotov6.DiagnosticSeverityWarning, Summary: "deprecated"},
+		{Severity: tfprotov6.DiagnosticSeverityError, Summary: "failed"},
+	})
+	if !diags.HasError() {
+		t.Error("expected diagnostics to have an error")
+	}
+	if len(diags.Warnings()) != 1 {
+		t.Errorf("expected one warning, got %q", diags.Warnings())
+	}
+}
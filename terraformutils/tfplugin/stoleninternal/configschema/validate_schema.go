@@ -0,0 +1,100 @@
+package configschema
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hashicorp/terraform-plugin-go/tfprotov5"
+)
+
+// ValidateSchema checks that the given block schema is consistent enough for
+// the rest of this package to work with, returning an error describing the
+// first problem found. Nested blocks are checked too, and problems inside
+// them are reported with the dotted path of the block.
+//
+// Providers occasionally ship schemas that ImpliedType and friends cannot
+// handle gracefully, so callers can use this to skip a bad resource type up
+// front instead of failing halfway through an import.
+func ValidateSchema(b *tfprotov5.SchemaBlock) error {
+	return validateSchema(b, "")
+}
+
+// WrapValidatedBlock is like WrapBlock, but returns an error instead of the
+// wrapped block if the schema doesn't pass ValidateSchema.
+func WrapValidatedBlock(b *tfprotov5.SchemaBlock) (*Block, error) {
+	if err := ValidateSchema(b); err != nil {
+		return nil, err
+	}
+	return WrapBlock(b), nil
+}
+
+func validateSchema(b *tfprotov5.SchemaBlock, path string) error {
+	if b == nil {
+		return nil
+	}
+
+	attrs := make(map[string]bool)
+	for _, attrS := range b.Attributes {
+		if attrS == nil {
+			return schemaErrorf(path, "nil attribute")
+		}
+		if attrs[attrS.Name] {
+			return schemaErrorf(path, "duplicate attribute %q", attrS.Name)
+		}
+		attrs[attrS.Name] = true
+	}
+
+	blocks := make(map[string]bool)
+	for _, blockS := range b.BlockTypes {
+		if blockS == nil {
+			return schemaErrorf(path, "nil block type")
+		}
+		name := blockS.TypeName
+		if attrs[name] {
+			return schemaErrorf(path, "%q is declared as both an attribute and a block", name)
+		}
+		if blocks[name] {
+			return schemaErrorf(path, "duplicate block type %q", name)
+		}
+		blocks[name] = true
+
+		if blockS.Block == nil {
+			return schemaErrorf(path, "block type %q has no schema", name)
+		}
+		if err := validateSchema(blockS.Block, joinSchemaPath(path, name)); err != nil {
+			return err
+		}
+
+		switch blockS.Nesting {
+		case tfprotov5.SchemaNestedBlockNestingModeSingle,
+			tfprotov5.SchemaNestedBlockNestingModeGroup,
+			tfprotov5.SchemaNestedBlockNestingModeList,
+			tfprotov5.SchemaNestedBlockNestingModeMap:
+		case tfprotov5.SchemaNestedBlockNestingModeSet:
+			if WrapBlock(blockS.Block).ImpliedType().HasDynamicTypes() {
+				return schemaErrorf(path, "set block type %q contains dynamically-typed attributes", name)
+			}
+		default:
+			return schemaErrorf(path, "block type %q has unsupported nesting mode %s", name, blockS.Nesting)
+		}
+	}
+
+	return nil
+}
+
+// schemaErrorf reports a problem found in the block at the dotted path,
+// which is empty for the top-level block.
+func schemaErrorf(path string, format string, args ...interface{}) error {
+	msg := fmt.Sprintf(format, args...)
+	if path == "" {
+		return errors.New(msg)
+	}
+	return fmt.Errorf("%s: %s", path, msg)
+}
+
+func joinSchemaPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
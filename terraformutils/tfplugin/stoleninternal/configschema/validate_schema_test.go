@@ -0,0 +1,129 @@
+package configschema
+
+import (
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-go/tfprotov5"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+)
+
+func TestValidateSchema(t *testing.T) {
+	attr := func(name string, ty tftypes.Type) *tfprotov5.SchemaAttribute {
+		return &tfprotov5.SchemaAttribute{Name: name, Type: ty, Optional: true}
+	}
+	block := func(name string, nesting tfprotov5.SchemaNestedBlockNestingMode, b *tfprotov5.SchemaBlock) *tfprotov5.SchemaNestedBlock {
+		return &tfprotov5.SchemaNestedBlock{TypeName: name, Nesting: nesting, Block: b}
+	}
+
+	tests := map[string]struct {
+		Schema  *tfprotov5.SchemaBlock
+		WantErr string
+	}{
+		"nil": {
+			nil,
+			``,
+		},
+		"clean": {
+			&tfprotov5.SchemaBlock{
+				Attributes: []*tfprotov5.SchemaAttribute{attr("name", tftypes.String)},
+				BlockTypes: []*tfprotov5.SchemaNestedBlock{
+					block("rule", tfprotov5.SchemaNestedBlockNestingModeList, &tfprotov5.SchemaBlock{
+						Attributes: []*tfprotov5.SchemaAttribute{attr("value", tftypes.DynamicPseudoType)},
+					}),
+					block("tag", tfprotov5.SchemaNestedBlockNestingModeSet, &tfprotov5.SchemaBlock{
+						Attributes: []*tfprotov5.SchemaAttribute{attr("key", tftypes.String)},
+					}),
+				},
+			},
+			``,
+		},
+		"attribute and block with the same name": {
+			&tfprotov5.SchemaBlock{
+				Attributes: []*tfprotov5.SchemaAttribute{attr("rule", tftypes.String)},
+				BlockTypes: []*tfprotov5.SchemaNestedBlock{
+					block("rule", tfprotov5.SchemaNestedBlockNestingModeList, &tfprotov5.SchemaBlock{}),
+				},
+			},
+			`"rule" is declared as both an attribute and a block`,
+		},
+		"duplicate attribute": {
+			&tfprotov5.SchemaBlock{
+				Attributes: []*tfprotov5.SchemaAttribute{attr("name", tftypes.String), attr("name", tftypes.Number)},
+			},
+			`duplicate attribute "name"`,
+		},
+		"duplicate block type": {
+			&tfprotov5.SchemaBlock{
+				BlockTypes: []*tfprotov5.SchemaNestedBlock{
+					block("rule", tfprotov5.SchemaNestedBlockNestingModeList, &tfprotov5.SchemaBlock{}),
+					block("rule", tfprotov5.SchemaNestedBlockNestingModeSet, &tfprotov5.SchemaBlock{}),
+				},
+			},
+			`duplicate block type "rule"`,
+		},
+		"unknown nesting mode": {
+			&tfprotov5.SchemaBlock{
+				BlockTypes: []*tfprotov5.SchemaNestedBlock{
+					block("rule", tfprotov5.SchemaNestedBlockNestingModeInvalid, &tfprotov5.SchemaBlock{}),
+				},
+			},
+			`block type "rule" has unsupported nesting mode INVALID`,
+		},
+		"dynamic attribute in set block": {
+			&tfprotov5.SchemaBlock{
+				BlockTypes: []*tfprotov5.SchemaNestedBlock{
+					block("rule", tfprotov5.SchemaNestedBlockNestingModeSet, &tfprotov5.SchemaBlock{
+						Attributes: []*tfprotov5.SchemaAttribute{attr("value", tftypes.DynamicPseudoType)},
+					}),
+				},
+			},
+			`set block type "rule" contains dynamically-typed attributes`,
+		},
+		"nested collision": {
+			&tfprotov5.SchemaBlock{
+				BlockTypes: []*tfprotov5.SchemaNestedBlock{
+					block("rule", tfprotov5.SchemaNestedBlockNestingModeList, &tfprotov5.SchemaBlock{
+						BlockTypes: []*tfprotov5.SchemaNestedBlock{
+							block("logging", tfprotov5.SchemaNestedBlockNestingModeSingle, &tfprotov5.SchemaBlock{
+								Attributes: []*tfprotov5.SchemaAttribute{attr("level", tftypes.String)},
+								BlockTypes: []*tfprotov5.SchemaNestedBlock{
+									block("level", tfprotov5.SchemaNestedBlockNestingModeSingle, &tfprotov5.SchemaBlock{}),
+								},
+							}),
+						},
+					}),
+				},
+			},
+			`rule.logging: "level" is declared as both an attribute and a block`,
+		},
+		"block without schema": {
+			&tfprotov5.SchemaBlock{
+				BlockTypes: []*tfprotov5.SchemaNestedBlock{
+					block("rule", tfprotov5.SchemaNestedBlockNestingModeList, nil),
+				},
+			},
+			`block type "rule" has no schema`,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := ValidateSchema(test.Schema)
+			if test.WantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("validation succeeded; want error: %q", test.WantErr)
+			}
+			if got := err.Error(); got != test.WantErr {
+				t.Errorf("wrong error\ngot:  %s\nwant: %s", got, test.WantErr)
+			}
+			if _, err := WrapValidatedBlock(test.Schema); err == nil {
+				t.Error("WrapValidatedBlock accepted an invalid schema")
+			}
+		})
+	}
+}
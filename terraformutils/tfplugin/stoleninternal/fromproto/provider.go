@@ -54,6 +54,7 @@ func GetProviderSchemaResponse(in *tfplugin5.GetProviderSchema_Response) (*tfpro
 		return &resp, err
 	}
 	resp.Diagnostics = diags
+	resp.ServerCapabilities = GetProviderSchema_ServerCapabilities(in.ServerCapabilities)
 	return &resp, nil
 }
 
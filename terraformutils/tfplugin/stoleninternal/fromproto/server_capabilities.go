@@ -0,0 +1,16 @@
+package fromproto
+
+import (
+	"github.com/GoogleCloudPlatform/terraformer/terraformutils/tfplugin/stoleninternal/tfplugin5"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov5"
+)
+
+func GetProviderSchema_ServerCapabilities(in *tfplugin5.GetProviderSchema_ServerCapabilities) *tfprotov5.ServerCapabilities {
+	if in == nil {
+		return nil
+	}
+
+	return &tfprotov5.ServerCapabilities{
+		PlanDestroy: in.PlanDestroy,
+	}
+}
@@ -0,0 +1,47 @@
+package fromproto
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/terraformer/terraformutils/tfplugin/stoleninternal/tfprotov6/toproto"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov6"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+)
+
+func TestDynamicValueRoundTrip(t *testing.T) {
+	typ := tftypes.Object{AttributeTypes: map[string]tftypes.Type{"name": tftypes.String}}
+	in, err := tfprotov6.NewDynamicValue(typ, tftypes.NewValue(typ, map[string]tftypes.Value{
+		"name": tftypes.NewValue(tftypes.String, "example"),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := DynamicValue(toproto.DynamicValue(&in))
+	if !reflect.DeepEqual(&in, out) {
+		t.Errorf("round trip changed the value\ngot:  %#v\nwant: %#v", out, &in)
+	}
+}
+
+func TestDiagnosticsRoundTrip(t *testing.T) {
+	in := []*tfprotov6.Diagnostic{
+		{
+			Severity:  tfprotov6.DiagnosticSeverityError,
+			Summary:   "failed",
+			Detail:    "boom",
+			Attribute: tftypes.NewAttributePath().WithAttributeName("rules").WithElementKeyInt(0),
+		},
+		{Severity: tfprotov6.DiagnosticSeverityWarning, Summary: "deprecated"},
+	}
+	proto, err := toproto.Diagnostics(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := Diagnostics(proto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("round trip changed the diagnostics\ngot:  %#v\nwant: %#v", out, in)
+	}
+}
@@ -0,0 +1,16 @@
+package fromproto
+
+import (
+	"github.com/GoogleCloudPlatform/terraformer/terraformutils/tfplugin/stoleninternal/tfplugin6"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov6"
+)
+
+func GetProviderSchema_ServerCapabilities(in *tfplugin6.GetProviderSchema_ServerCapabilities) *tfprotov6.ServerCapabilities {
+	if in == nil {
+		return nil
+	}
+
+	return &tfprotov6.ServerCapabilities{
+		PlanDestroy: in.PlanDestroy,
+	}
+}
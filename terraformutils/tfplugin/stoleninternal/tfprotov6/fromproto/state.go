@@ -0,0 +1,13 @@
+package fromproto
+
+import (
+	"github.com/GoogleCloudPlatform/terraformer/terraformutils/tfplugin/stoleninternal/tfplugin6"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov6"
+)
+
+func RawState(in *tfplugin6.RawState) *tfprotov6.RawState {
+	return &tfprotov6.RawState{
+		JSON:    in.Json,
+		Flatmap: in.Flatmap,
+	}
+}
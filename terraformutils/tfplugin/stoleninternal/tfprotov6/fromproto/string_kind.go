@@ -0,0 +1,10 @@
+package fromproto
+
+import (
+	"github.com/GoogleCloudPlatform/terraformer/terraformutils/tfplugin/stoleninternal/tfplugin6"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov6"
+)
+
+func StringKind(in tfplugin6.StringKind) tfprotov6.StringKind {
+	return tfprotov6.StringKind(in)
+}
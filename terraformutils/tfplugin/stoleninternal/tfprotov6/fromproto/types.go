@@ -0,0 +1,13 @@
+package fromproto
+
+import (
+	"github.com/GoogleCloudPlatform/terraformer/terraformutils/tfplugin/stoleninternal/tfplugin6"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov6"
+)
+
+func DynamicValue(in *tfplugin6.DynamicValue) *tfprotov6.DynamicValue {
+	return &tfprotov6.DynamicValue{
+		MsgPack: in.Msgpack,
+		JSON:    in.Json,
+	}
+}
@@ -0,0 +1,16 @@
+package toproto
+
+import (
+	"github.com/GoogleCloudPlatform/terraformer/terraformutils/tfplugin/stoleninternal/tfplugin6"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov6"
+)
+
+func GetProviderSchema_ServerCapabilities(in *tfprotov6.ServerCapabilities) *tfplugin6.GetProviderSchema_ServerCapabilities {
+	if in == nil {
+		return nil
+	}
+
+	return &tfplugin6.GetProviderSchema_ServerCapabilities{
+		PlanDestroy: in.PlanDestroy,
+	}
+}
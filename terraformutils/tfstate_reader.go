@@ -0,0 +1,213 @@
+// Copyright 2018 The Terraformer Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package terraformutils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+// stateV4 is the part of a version 4 state, written by terraform 0.12 and
+// later, that ReadTfState reads.
+type stateV4 struct {
+	Resources []struct {
+		Module    string `json:"module"`
+		Mode      string `json:"mode"`
+		Type      string `json:"type"`
+		Name      string `json:"name"`
+		Provider  string `json:"provider"`
+		Instances []struct {
+			IndexKey       interface{}            `json:"index_key"`
+			Status         string                 `json:"status"`
+			Deposed        string                 `json:"deposed"`
+			SchemaVersion  uint64                 `json:"schema_version"`
+			Attributes     map[string]interface{} `json:"attributes"`
+			AttributesFlat map[string]string      `json:"attributes_flat"`
+		} `json:"instances"`
+	} `json:"resources"`
+}
+
+// ReadTfState parses a state file, as written by PrintTfState or by
+// terraform, back into resources to refresh them again instead of
+// discovering them anew. Both the version 3 and 4 state formats are read.
+// Only the managed resources of the root module are kept, and outputs are
+// dropped. Version 4 attributes are flatmapped without their schema, so
+// nested objects are read as maps until the resources are refreshed.
+func ReadTfState(r io.Reader) ([]Resource, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var version struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(b, &version); err != nil {
+		return nil, err
+	}
+	switch version.Version {
+	case 3:
+		return readTfStateV3(b)
+	case 4:
+		return readTfStateV4(b)
+	}
+	return nil, fmt.Errorf("unsupported state version %d", version.Version)
+}
+
+func readTfStateV3(b []byte) ([]Resource, error) {
+	var state terraform.State
+	if err := json.Unmarshal(b, &state); err != nil {
+		return nil, err
+	}
+	root := state.RootModule()
+	if root == nil {
+		return nil, nil
+	}
+	keys := make([]string, 0, len(root.Resources))
+	for key := range root.Resources {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	resources := make([]Resource, 0, len(keys))
+	for _, key := range keys {
+		resourceState := root.Resources[key]
+		if resourceState == nil || resourceState.Primary == nil || strings.HasPrefix(key, "data.") {
+			continue
+		}
+		name := strings.TrimPrefix(key, resourceState.Type+".")
+		if i := strings.Index(name, "."); i >= 0 {
+			// resources created with count are keyed type.name.index
+			name = name[:i] + "-" + name[i+1:]
+		}
+		provider, alias := parseProviderAddress(resourceState.Provider)
+		resources = append(resources, Resource{
+			InstanceState: resourceState.Primary,
+			InstanceInfo: &terraform.InstanceInfo{
+				Type: resourceState.Type,
+				Id:   resourceState.Type + "." + name,
+			},
+			ResourceName:  name,
+			Provider:      provider,
+			ProviderAlias: alias,
+		})
+	}
+	return resources, nil
+}
+
+func readTfStateV4(b []byte) ([]Resource, error) {
+	var state stateV4
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	if err := decoder.Decode(&state); err != nil {
+		return nil, err
+	}
+	var resources []Resource
+	for _, resourceState := range state.Resources {
+		if resourceState.Module != "" || resourceState.Mode != "managed" {
+			continue
+		}
+		provider, alias := parseProviderAddress(resourceState.Provider)
+		for _, instance := range resourceState.Instances {
+			if instance.Deposed != "" {
+				continue
+			}
+			name := resourceState.Name
+			if instance.IndexKey != nil {
+				name += "-" + unsafeChars.ReplaceAllStringFunc(fmt.Sprint(instance.IndexKey), escapeRune)
+			}
+			attributes := instance.AttributesFlat
+			if attributes == nil {
+				attributes = map[string]string{}
+				for key, val := range instance.Attributes {
+					flattenJSONValue(attributes, key, val)
+				}
+			}
+			resources = append(resources, Resource{
+				InstanceState: &terraform.InstanceState{
+					ID:         attributes["id"],
+					Attributes: attributes,
+					Meta:       map[string]interface{}{"schema_version": strconv.FormatUint(instance.SchemaVersion, 10)},
+					Tainted:    instance.Status == "tainted",
+				},
+				InstanceInfo: &terraform.InstanceInfo{
+					Type: resourceState.Type,
+					Id:   resourceState.Type + "." + name,
+				},
+				ResourceName:  name,
+				Provider:      provider,
+				ProviderAlias: alias,
+			})
+		}
+	}
+	return resources, nil
+}
+
+// flattenJSONValue flatmaps the JSON value val under key, with lists counted
+// by key.# and objects, taken for maps, by key.%. Null values are left out.
+func flattenJSONValue(attributes map[string]string, key string, val interface{}) {
+	switch v := val.(type) {
+	case nil:
+	case string:
+		attributes[key] = v
+	case json.Number:
+		attributes[key] = v.String()
+	case bool:
+		attributes[key] = strconv.FormatBool(v)
+	case []interface{}:
+		attributes[key+".#"] = strconv.Itoa(len(v))
+		for i, elem := range v {
+			flattenJSONValue(attributes, key+"."+strconv.Itoa(i), elem)
+		}
+	case map[string]interface{}:
+		count := 0
+		for k, elem := range v {
+			if elem != nil {
+				count++
+				flattenJSONValue(attributes, key+"."+k, elem)
+			}
+		}
+		attributes[key+".%"] = strconv.Itoa(count)
+	}
+}
+
+// parseProviderAddress returns the provider name and alias of a legacy
+// provider.name.alias address or of a provider["host/namespace/name"].alias
+// one.
+func parseProviderAddress(address string) (string, string) {
+	if i := strings.Index(address, "provider"); i > 0 {
+		// provider of a module, module.name.provider...
+		address = address[i:]
+	}
+	if strings.HasPrefix(address, `provider["`) {
+		end := strings.Index(address, `"]`)
+		if end < 0 {
+			return "", ""
+		}
+		source := address[len(`provider["`):end]
+		name := source[strings.LastIndex(source, "/")+1:]
+		return name, strings.TrimPrefix(address[end+len(`"]`):], ".")
+	}
+	parts := strings.SplitN(strings.TrimPrefix(address, "provider."), ".", 2)
+	if len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return parts[0], ""
+}
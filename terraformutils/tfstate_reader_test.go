@@ -0,0 +1,138 @@
+package terraformutils
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestReadTfStateRoundTrip(t *testing.T) {
+	aliased := NewResource("vpc-2", "west", "aws_vpc", "aws", map[string]string{
+		"id":         "vpc-2",
+		"cidr_block": "10.1.0.0/16",
+	}, []string{}, map[string]interface{}{})
+	aliased.ProviderAlias = "us_west"
+	resources := []Resource{
+		NewResource("vpc-1", "main", "aws_vpc", "aws", map[string]string{
+			"id":         "vpc-1",
+			"cidr_block": "10.0.0.0/16",
+			"tags.%":     "1",
+			"tags.Name":  "main",
+		}, []string{}, map[string]interface{}{}),
+		aliased,
+		NewSimpleResource("subnet-1", "a", "aws_subnet", "aws", []string{}),
+	}
+	for _, options := range []TfStateOptions{{}, {RegistryHost: TerraformRegistryHost}} {
+		b, err := PrintTfStateWithOptions(resources, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		read, err := ReadTfState(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := resourceSummaries(read), resourceSummaries(resources); !reflect.DeepEqual(got, want) {
+			t.Errorf("expected the resources back\ngot  %v\nwant %v", got, want)
+		}
+	}
+}
+
+// resourceSummaries keys what a state keeps of resources by their address.
+func resourceSummaries(resources []Resource) map[string]string {
+	summaries := map[string]string{}
+	for _, r := range resources {
+		attributes := []string{}
+		for k, v := range r.InstanceState.Attributes {
+			attributes = append(attributes, k+"="+v)
+		}
+		sort.Strings(attributes)
+		summaries[r.InstanceInfo.Id] = strings.Join([]string{
+			r.InstanceInfo.Type, r.ResourceName, r.Provider, r.ProviderAlias, r.InstanceState.ID, strings.Join(attributes, ","),
+		}, " ")
+	}
+	return summaries
+}
+
+func TestReadTfStateV4(t *testing.T) {
+	state := `{
+  "version": 4,
+  "terraform_version": "1.5.7",
+  "serial": 3,
+  "resources": [
+    {
+      "mode": "managed",
+      "type": "google_compute_network",
+      "name": "main",
+      "provider": "provider[\"registry.terraform.io/hashicorp/google\"].europe",
+      "instances": [
+        {
+          "schema_version": 1,
+          "attributes": {
+            "id": "projects/p/global/networks/main",
+            "mtu": 1460,
+            "auto_create_subnetworks": false,
+            "description": null,
+            "labels": {"env": "prod"},
+            "log_config": [{"enable": true}]
+          }
+        }
+      ]
+    },
+    {
+      "mode": "managed",
+      "type": "google_compute_address",
+      "name": "ip",
+      "provider": "provider[\"registry.terraform.io/hashicorp/google\"]",
+      "instances": [
+        {"index_key": 0, "status": "tainted", "attributes_flat": {"id": "ip-0"}},
+        {"index_key": 1, "attributes_flat": {"id": "ip-1"}},
+        {"index_key": 1, "deposed": "00000001", "attributes_flat": {"id": "ip-old"}}
+      ]
+    },
+    {
+      "mode": "data",
+      "type": "google_project",
+      "name": "current",
+      "provider": "provider[\"registry.terraform.io/hashicorp/google\"]",
+      "instances": [{"attributes": {"id": "p"}}]
+    },
+    {
+      "module": "module.child",
+      "mode": "managed",
+      "type": "google_compute_network",
+      "name": "child",
+      "provider": "provider[\"registry.terraform.io/hashicorp/google\"]",
+      "instances": [{"attributes": {"id": "child"}}]
+    }
+  ]
+}`
+	resources, err := ReadTfState(strings.NewReader(state))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"google_compute_network.main": "google_compute_network main google europe projects/p/global/networks/main " +
+			"auto_create_subnetworks=false,id=projects/p/global/networks/main,labels.%=1,labels.env=prod,log_config.#=1,log_config.0.%=1,log_config.0.enable=true,mtu=1460",
+		"google_compute_address.ip-0": "google_compute_address ip-0 google  ip-0 id=ip-0",
+		"google_compute_address.ip-1": "google_compute_address ip-1 google  ip-1 id=ip-1",
+	}
+	if got := resourceSummaries(resources); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected resources\ngot  %v\nwant %v", got, want)
+	}
+	for _, r := range resources {
+		if tainted := r.InstanceInfo.Id == "google_compute_address.ip-0"; r.InstanceState.Tainted != tainted {
+			t.Errorf("expected %s tainted to be %v", r.InstanceInfo.Id, tainted)
+		}
+	}
+	if got := resources[0].InstanceState.Meta["schema_version"]; got != "1" {
+		t.Errorf("expected the schema version to be kept, got %v", got)
+	}
+}
+
+func TestReadTfStateUnsupportedVersion(t *testing.T) {
+	if _, err := ReadTfState(strings.NewReader(`{"version": 2}`)); err == nil || !strings.Contains(err.Error(), "unsupported state version 2") {
+		t.Errorf("expected the version to be rejected, got %v", err)
+	}
+}
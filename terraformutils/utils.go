@@ -21,9 +21,16 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"reflect"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/GoogleCloudPlatform/terraformer/terraformutils/providerwrapper"
+	"github.com/GoogleCloudPlatform/terraformer/terraformutils/tfplugin/stoleninternal/configschema"
+	"github.com/hashicorp/go-cty/cty"
+	ctyjson "github.com/hashicorp/go-cty/cty/json"
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
 )
@@ -32,7 +39,21 @@ type BaseResource struct {
 	Tags map[string]string `json:"tags,omitempty"`
 }
 
+// TfStateOptions controls how NewTfStateWithOptions addresses providers.
+type TfStateOptions struct {
+	// RegistryHost, when set, records fully qualified provider addresses on
+	// that registry, e.g. OpenTofuRegistryHost, instead of legacy ones.
+	RegistryHost string
+	// ProviderSource is the "namespace/type" source of the resources'
+	// provider, defaulting to the hashicorp namespace.
+	ProviderSource string
+}
+
 func NewTfState(resources []Resource) *terraform.State {
+	return NewTfStateWithOptions(resources, TfStateOptions{})
+}
+
+func NewTfStateWithOptions(resources []Resource, options TfStateOptions) *terraform.State {
 	tfstate := &terraform.State{
 		Version:   3, //internal/legacy/terraform/state.go
 		TFVersion: "v1.0.0",
@@ -41,7 +62,7 @@ func NewTfState(resources []Resource) *terraform.State {
 	outputs := map[string]*terraform.OutputState{}
 	for _, r := range resources {
 		for k, v := range r.Outputs {
-			outputs[k] = v
+			outputs[k] = typedOutput(v)
 		}
 	}
 	tfstate.Modules = []*terraform.ModuleState{
@@ -52,44 +73,333 @@ func NewTfState(resources []Resource) *terraform.State {
 		},
 	}
 	for _, resource := range resources {
-		resourceState := &terraform.ResourceState{
-			Type:     resource.InstanceInfo.Type,
-			Primary:  resource.InstanceState,
-			Provider: "provider." + resource.Provider,
-		}
-		tfstate.Modules[0].Resources[resource.InstanceInfo.Type+"."+resource.ResourceName] = resourceState
+		tfstate.Modules[0].Resources[resource.InstanceInfo.Type+"."+resource.ResourceName] = newResourceState(resource, options)
 	}
 	return tfstate
 }
 
+// NewTfStateChecked is NewTfStateWithOptions failing when several resources
+// share a type and name, instead of keeping only the last one of them.
+func NewTfStateChecked(resources []Resource, options TfStateOptions) (*terraform.State, error) {
+	seen := map[string]bool{}
+	var duplicates []string
+	for _, resource := range resources {
+		address := resource.InstanceInfo.Type + "." + resource.ResourceName
+		if seen[address] {
+			duplicates = append(duplicates, address)
+		}
+		seen[address] = true
+	}
+	if len(duplicates) > 0 {
+		return nil, fmt.Errorf("duplicate resource addresses: %s", strings.Join(duplicates, ", "))
+	}
+	return NewTfStateWithOptions(resources, options), nil
+}
+
+func newResourceState(resource Resource, options TfStateOptions) *terraform.ResourceState {
+	resourceState := &terraform.ResourceState{
+		Type:     resource.InstanceInfo.Type,
+		Primary:  resource.InstanceState,
+		Provider: "provider." + resource.Provider,
+	}
+	if options.RegistryHost != "" {
+		resourceState.Provider = "provider[\"" + ProviderSource(options.RegistryHost, resource.Provider, options.ProviderSource) + "\"]"
+	}
+	if resource.ProviderAlias != "" {
+		resourceState.Provider += "." + resource.ProviderAlias
+	}
+	return resourceState
+}
+
+// StreamedResource is one line written by StreamResources.
+type StreamedResource struct {
+	Address string                   `json:"address"`
+	State   *terraform.ResourceState `json:"state"`
+}
+
+// StreamResources writes the state of each resource as a JSON object on its
+// own line, so downstream tools can process resources as they come instead
+// of waiting for the whole state file. Resources without state are skipped.
+func StreamResources(resources []*Resource, w io.Writer) error {
+	return StreamResourcesWithOptions(resources, w, TfStateOptions{})
+}
+
+// StreamResourcesWithOptions is StreamResources addressing the provider of
+// each streamed state as NewTfStateWithOptions does.
+func StreamResourcesWithOptions(resources []*Resource, w io.Writer, options TfStateOptions) error {
+	encoder := json.NewEncoder(w)
+	for _, resource := range resources {
+		if resource.InstanceState == nil {
+			continue
+		}
+		line := StreamedResource{
+			Address: resource.InstanceInfo.Type + "." + resource.ResourceName,
+			State:   newResourceState(*resource, options),
+		}
+		if err := encoder.Encode(line); err != nil {
+			return fmt.Errorf("failed to stream %s: %w", line.Address, err)
+		}
+	}
+	return nil
+}
+
 func PrintTfState(resources []Resource) ([]byte, error) {
-	state := NewTfState(resources)
+	return PrintTfStateWithOptions(resources, TfStateOptions{})
+}
+
+func PrintTfStateWithOptions(resources []Resource, options TfStateOptions) ([]byte, error) {
+	state := NewTfStateWithOptions(resources, options)
 	var buf bytes.Buffer
 	err := writeState(state, &buf)
 	return buf.Bytes(), err
 }
 
+// PrintTfStateValidated is PrintTfStateWithOptions reading the written state
+// back to check that it holds every resource, each with a primary instance,
+// so a malformed state is reported here instead of by terraform.
+func PrintTfStateValidated(resources []Resource, options TfStateOptions) ([]byte, error) {
+	b, err := PrintTfStateWithOptions(resources, options)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateWrittenState(b, len(resources)); err != nil {
+		return nil, fmt.Errorf("invalid state: %w", err)
+	}
+	return b, nil
+}
+
+func validateWrittenState(b []byte, resourceCount int) error {
+	var state terraform.State
+	if err := json.Unmarshal(b, &state); err != nil {
+		return err
+	}
+	if err := state.Validate(); err != nil {
+		return err
+	}
+	if len(state.Modules) != 1 {
+		return fmt.Errorf("expected the root module only, got %d modules", len(state.Modules))
+	}
+	resourceStates := state.Modules[0].Resources
+	if len(resourceStates) != resourceCount {
+		return fmt.Errorf("expected %d resources, got %d", resourceCount, len(resourceStates))
+	}
+	for address, resourceState := range resourceStates {
+		if resourceState == nil || resourceState.Primary == nil || resourceState.Primary.ID == "" {
+			return fmt.Errorf("resource %s has no primary instance", address)
+		}
+	}
+	return nil
+}
+
+// PrintResourceState serializes a state holding only r and its own outputs,
+// e.g. to cache or inspect resources one by one.
+func PrintResourceState(r Resource) ([]byte, error) {
+	return PrintTfState([]Resource{r})
+}
+
+// DefaultSlowQueryTimeout bounds the refresh of a single slow-query resource,
+// so one hung resource doesn't stall the rest of its group.
+const DefaultSlowQueryTimeout = 5 * time.Minute
+
+// defaultRefreshConcurrency is how many regular resources are refreshed at
+// once when RefreshOptions.Concurrency isn't set.
+const defaultRefreshConcurrency = 16
+
+type RefreshOptions struct {
+	// SlowQueryTimeout limits each resource of a slow-query group,
+	// 0 means no limit.
+	SlowQueryTimeout time.Duration
+	// ShuffleSeed makes the order resources are refreshed in reproducible,
+	// 0 means a random order.
+	ShuffleSeed int64
+	// TypePriorities schedules resources of higher priority types first,
+	// types that aren't listed have priority 0.
+	TypePriorities map[string]int
+	// PostRefreshHook, when set, runs after each successful refresh.
+	PostRefreshHook PostRefreshHook
+	// Budget, when set, bounds the refreshes in flight across every call
+	// sharing it, e.g. when the resources of several providers are
+	// refreshed at once.
+	Budget *RefreshBudget
+	// Progress, when set, is called each time a resource is done
+	// refreshing. Calls don't overlap.
+	Progress func(RefreshProgress)
+	// NoShuffle refreshes resources in no particular order, partitioning
+	// them as they are read instead of from a shuffled copy, to save memory
+	// on very large imports. ShuffleSeed is ignored.
+	NoShuffle bool
+	// Concurrency is how many regular resources are refreshed at once,
+	// 0 means 16.
+	Concurrency int
+	// SlowQueryBatchSize splits the slow-query groups into batches of at
+	// most that many resources, each refreshed one resource at a time,
+	// 0 keeps each group in a single batch.
+	SlowQueryBatchSize int
+	// SlowQueryConcurrency is how many slow-query batches are refreshed at
+	// once, 0 means all of them.
+	SlowQueryConcurrency int
+}
+
+// RefreshProgress counts the resources of a refresh.
+type RefreshProgress struct {
+	// Done is the number of resources done refreshing, including failed ones.
+	Done int
+	// Total is the number of resources to refresh.
+	Total int
+	// Failed is the number of resources that couldn't be refreshed.
+	Failed int
+}
+
+// RefreshFailure tells why a resource couldn't be refreshed.
+type RefreshFailure struct {
+	// ResourceName is the name of the resource, as in its address.
+	ResourceName string
+	// Reason is the error the refresh failed with.
+	Reason string
+}
+
+// refreshTracker reports the progress of a refresh to its callback, and
+// records why resources failed.
+type refreshTracker struct {
+	mu       sync.Mutex
+	progress RefreshProgress
+	callback func(RefreshProgress)
+	reasons  map[*Resource]string
+}
+
+func newRefreshTracker(total int, callback func(RefreshProgress)) *refreshTracker {
+	return &refreshTracker{progress: RefreshProgress{Total: total}, callback: callback, reasons: map[*Resource]string{}}
+}
+
+// fail records err as the reason r failed, when it isn't nil.
+func (t *refreshTracker) fail(r *Resource, err error) {
+	if err == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.reasons[r] = err.Error()
+}
+
+// failure returns the failure of r, a resource without state.
+func (t *refreshTracker) failure(r *Resource) RefreshFailure {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	reason, ok := t.reasons[r]
+	if !ok {
+		reason = "the provider returned no state"
+	}
+	return RefreshFailure{ResourceName: r.ResourceName, Reason: reason}
+}
+
+// done records that r is done refreshing.
+func (t *refreshTracker) done(r *Resource) {
+	if t.callback == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.progress.Done++
+	if r.InstanceState == nil || r.InstanceState.ID == "" {
+		t.progress.Failed++
+	}
+	t.callback(t.progress)
+}
+
+// RefreshBudget is a worker budget shared by concurrent refreshes, so that
+// refreshing N providers doesn't run N times as many refreshes at once.
+type RefreshBudget struct {
+	slots chan struct{}
+}
+
+// NewRefreshBudget returns a budget of workers concurrent refreshes.
+func NewRefreshBudget(workers int) *RefreshBudget {
+	if workers < 1 {
+		workers = 1
+	}
+	return &RefreshBudget{slots: make(chan struct{}, workers)}
+}
+
+// run calls refresh once a worker is free; a nil budget doesn't wait.
+func (b *RefreshBudget) run(refresh func()) {
+	if b != nil {
+		b.slots <- struct{}{}
+		defer func() { <-b.slots }()
+	}
+	refresh()
+}
+
+// PostRefreshHook enriches a freshly refreshed resource, e.g. with tags or
+// cross-references. Returning an error fails the resource. Hooks run
+// concurrently for different resources.
+type PostRefreshHook func(ctx context.Context, r *Resource) error
+
+func DefaultRefreshOptions() RefreshOptions {
+	return RefreshOptions{
+		SlowQueryTimeout: DefaultSlowQueryTimeout,
+	}
+}
+
 func RefreshResources(resources []*Resource, provider *providerwrapper.ProviderWrapper, slowProcessingResources [][]*Resource) ([]*Resource, error) {
+	return RefreshResourcesWithOptions(resources, provider, slowProcessingResources, DefaultRefreshOptions())
+}
 
-	DoWorkPooled(resources, 16, func(resource *Resource) (**Resource, error) {
-		RefreshResource(resource, provider)
-		return nil, nil //continue regardless
-	})
+func RefreshResourcesWithOptions(resources []*Resource, provider *providerwrapper.ProviderWrapper, slowProcessingResources [][]*Resource, options RefreshOptions) ([]*Resource, error) {
+	return RefreshResourcesContext(context.Background(), resources, provider, slowProcessingResources, options)
+}
+
+// RefreshResourcesContext is RefreshResourcesWithOptions stopping once ctx is
+// done: the resources being refreshed then are waited for, and the rest are
+// left without state.
+func RefreshResourcesContext(ctx context.Context, resources []*Resource, provider *providerwrapper.ProviderWrapper, slowProcessingResources [][]*Resource, options RefreshOptions) ([]*Resource, error) {
+	refreshedResources, _, err := RefreshResourcesWithFailures(ctx, resources, provider, slowProcessingResources, options)
+	return refreshedResources, err
+}
 
-	DoWorkPooled(slowProcessingResources, len(slowProcessingResources), func(resources []*Resource) (*[]*Resource, error) {
-		for _, resource := range resources {
-			RefreshResource(resource, provider)
+// RefreshResourcesWithFailures is RefreshResourcesContext also returning the
+// resources that couldn't be refreshed with the reason why, in the order
+// they were given.
+func RefreshResourcesWithFailures(ctx context.Context, resources []*Resource, provider *providerwrapper.ProviderWrapper, slowProcessingResources [][]*Resource, options RefreshOptions) ([]*Resource, []RefreshFailure, error) {
+	total := len(resources)
+	for _, resourceGroup := range slowProcessingResources {
+		total += len(resourceGroup)
+	}
+	tracker := newRefreshTracker(total, options.Progress)
+
+	refreshByType(ctx, resources, provider, options, tracker)
+
+	if len(slowProcessingResources) > 0 {
+		ensureProviderAlive(ctx, provider)
+	}
+	slowBatches := batchResources(slowProcessingResources, options.SlowQueryBatchSize)
+	slowConcurrency := options.SlowQueryConcurrency
+	if slowConcurrency <= 0 {
+		slowConcurrency = len(slowBatches)
+	}
+	DoWorkPooled(slowBatches, slowConcurrency, func(resources []*Resource) (*[]*Resource, error) {
+		for _, resource := range prioritizeResources(resources, options.TypePriorities) {
+			if ctx.Err() != nil {
+				resource.InstanceState = nil
+				tracker.fail(resource, ctx.Err())
+				continue
+			}
+			options.Budget.run(func() {
+				tracker.fail(resource, refreshSlowResource(ctx, resource, provider, options.SlowQueryTimeout, options.PostRefreshHook))
+			})
+			tracker.done(resource)
 		}
 		return nil, nil //continue regardless
 	})
 
 	refreshedResources := []*Resource{}
+	var failures []RefreshFailure
 
 	for _, r := range resources {
 		if r.InstanceState != nil && r.InstanceState.ID != "" {
 			refreshedResources = append(refreshedResources, r)
 		} else {
 			log.Printf("ERROR: Unable to refresh resource %s", r.ResourceName)
+			failures = append(failures, tracker.failure(r))
 		}
 	}
 
@@ -99,23 +409,151 @@ func RefreshResources(resources []*Resource, provider *providerwrapper.ProviderW
 				refreshedResources = append(refreshedResources, r)
 			} else {
 				log.Printf("ERROR: Unable to refresh resource %s", r.ResourceName)
+				failures = append(failures, tracker.failure(r))
 			}
 		}
 	}
-	return refreshedResources, nil
+	return refreshedResources, failures, nil
+}
+
+// batchResources splits each group into batches of at most size resources,
+// keeping their order. A size of 0 leaves the groups whole.
+func batchResources(groups [][]*Resource, size int) [][]*Resource {
+	if size <= 0 {
+		return groups
+	}
+	var batches [][]*Resource
+	for _, group := range groups {
+		for len(group) > size {
+			batches = append(batches, group[:size])
+			group = group[size:]
+		}
+		if len(group) > 0 {
+			batches = append(batches, group)
+		}
+	}
+	return batches
+}
+
+// ensureProviderAlive relaunches the provider if it died since the last batch
+// of refreshes. The batch is attempted anyway when it can't be, so its
+// resources are reported as failed.
+func ensureProviderAlive(ctx context.Context, provider *providerwrapper.ProviderWrapper) {
+	if err := provider.EnsureAlive(ctx); err != nil {
+		log.Println(err)
+	}
+}
+
+// RefreshByType refreshes resources concurrently, looking up the schema of
+// each resource type and computing its implied type once for all the
+// resources of that type rather than once per resource. Resources that fail
+// to refresh are left without state.
+func RefreshByType(resources []*Resource, provider *providerwrapper.ProviderWrapper, options RefreshOptions) {
+	refreshByType(context.Background(), resources, provider, options, newRefreshTracker(len(resources), options.Progress))
+}
+
+func refreshByType(ctx context.Context, resources []*Resource, provider *providerwrapper.ProviderWrapper, options RefreshOptions, tracker *refreshTracker) {
+	if len(resources) > 0 {
+		ensureProviderAlive(ctx, provider)
+	}
+	refreshers := map[string]*providerwrapper.TypeRefresher{}
+	for _, r := range resources {
+		if _, ok := refreshers[r.InstanceInfo.Type]; ok {
+			continue
+		}
+		refresher, err := provider.NewTypeRefresher(r.InstanceInfo.Type)
+		if err != nil {
+			log.Println(err)
+		}
+		refreshers[r.InstanceInfo.Type] = refresher
+	}
+
+	concurrency := options.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultRefreshConcurrency
+	}
+	DoWorkPooled(prioritizeResources(resources, options.TypePriorities), concurrency, func(resource *Resource) (**Resource, error) {
+		if ctx.Err() != nil {
+			resource.InstanceState = nil
+			tracker.fail(resource, ctx.Err())
+			return nil, nil
+		}
+		options.Budget.run(func() {
+			err := refreshResourceByType(ctx, resource, provider, refreshers[resource.InstanceInfo.Type])
+			if err == nil {
+				err = runPostRefreshHook(ctx, resource, options.PostRefreshHook)
+			}
+			tracker.fail(resource, err)
+		})
+		tracker.done(resource)
+		return nil, nil //continue regardless
+	})
+}
+
+// refreshResourceByType refreshes r with the refresher of its type, or one
+// by one when there is none, and returns the error it failed with.
+func refreshResourceByType(ctx context.Context, r *Resource, provider *providerwrapper.ProviderWrapper, refresher *providerwrapper.TypeRefresher) error {
+	log.Println("Refreshing state...", r.InstanceInfo.Id)
+	var err error
+	if refresher == nil {
+		err = r.refresh(ctx, provider)
+	} else {
+		r.InstanceState, err = refresher.Refresh(ctx, r.InstanceInfo, r.InstanceState, r.ImportID)
+	}
+	if err != nil {
+		log.Println(err)
+	}
+	return err
+}
+
+// prioritizeResources returns a copy of resources ordered by descending type
+// priority, keeping the original order within a priority.
+func prioritizeResources(resources []*Resource, priorities map[string]int) []*Resource {
+	if len(priorities) == 0 {
+		return resources
+	}
+	prioritized := make([]*Resource, len(resources))
+	copy(prioritized, resources)
+	sort.SliceStable(prioritized, func(i, j int) bool {
+		return priorities[prioritized[i].InstanceInfo.Type] > priorities[prioritized[j].InstanceInfo.Type]
+	})
+	return prioritized
 }
 
 func RefreshResourcesByProvider(providersMapping *ProvidersMapping, providerWrapper *providerwrapper.ProviderWrapper) error {
-	allResources := providersMapping.ShuffleResources()
+	return RefreshResourcesByProviderWithOptions(providersMapping, providerWrapper, DefaultRefreshOptions())
+}
+
+func RefreshResourcesByProviderWithOptions(providersMapping *ProvidersMapping, providerWrapper *providerwrapper.ProviderWrapper, options RefreshOptions) error {
+	var regularResources []*Resource
+	var spResourcesList [][]*Resource
+	switch {
+	case options.NoShuffle:
+		regularResources, spResourcesList = providersMapping.PartitionResources()
+	case options.ShuffleSeed != 0:
+		regularResources, spResourcesList = partitionResources(providersMapping, providersMapping.ShuffleResourcesSeeded(options.ShuffleSeed))
+	default:
+		regularResources, spResourcesList = partitionResources(providersMapping, providersMapping.ShuffleResources())
+	}
+
+	refreshedResources, err := RefreshResourcesWithOptions(regularResources, providerWrapper, spResourcesList, options)
+	if err != nil {
+		return err
+	}
+
+	providersMapping.SetResources(refreshedResources)
+	return nil
+}
+
+// partitionResources splits resources into the regular ones and the slow
+// ones, grouped by the provider generating them, keeping their order.
+func partitionResources(providersMapping *ProvidersMapping, resources []*Resource) ([]*Resource, [][]*Resource) {
 	slowProcessingResources := make(map[ProviderGenerator][]*Resource)
 	regularResources := []*Resource{}
-	for i := range allResources {
-		resource := allResources[i]
+	for i := range resources {
+		resource := resources[i]
 		if resource.SlowQueryRequired {
 			provider := providersMapping.MatchProvider(resource)
-			if slowProcessingResources[provider] == nil {
-				slowProcessingResources[provider] = []*Resource{}
-			}
 			slowProcessingResources[provider] = append(slowProcessingResources[provider], resource)
 		} else {
 			regularResources = append(regularResources, resource)
@@ -126,19 +564,116 @@ func RefreshResourcesByProvider(providersMapping *ProvidersMapping, providerWrap
 	for p := range slowProcessingResources {
 		spResourcesList = append(spResourcesList, slowProcessingResources[p])
 	}
+	return regularResources, spResourcesList
+}
+
+func RefreshResource(r *Resource, provider *providerwrapper.ProviderWrapper) {
+	log.Println("Refreshing state...", r.InstanceInfo.Id)
+	r.Refresh(provider)
+}
+
+func RefreshResourceWithContext(ctx context.Context, r *Resource, provider *providerwrapper.ProviderWrapper) {
+	log.Println("Refreshing state...", r.InstanceInfo.Id)
+	r.RefreshWithContext(ctx, provider)
+}
 
-	refreshedResources, err := RefreshResources(regularResources, providerWrapper, spResourcesList)
+// RefreshResourceDryRun reads r from the provider and reports whether its
+// top-level attributes differ from r's current state, with one line per
+// differing attribute in detail. r is left untouched.
+func RefreshResourceDryRun(r *Resource, provider *providerwrapper.ProviderWrapper) (bool, string, error) {
+	if r.InstanceState == nil {
+		return false, "", fmt.Errorf("resource %s has no state to compare against", r.InstanceInfo.Id)
+	}
+	schema, err := provider.GetSchema()
 	if err != nil {
-		return err
+		return false, "", err
+	}
+	resourceSchema, ok := schema.ResourceSchemas[r.InstanceInfo.Type]
+	if !ok {
+		return false, "", fmt.Errorf("unknown resource type %s", r.InstanceInfo.Type)
+	}
+	impliedType := configschema.WrapBlock(resourceSchema.Block).ImpliedType()
+	current, err := r.InstanceState.AttrsAsObjectValue(impliedType)
+	if err != nil {
+		return false, "", err
+	}
+	refreshedState, err := provider.Refresh(r.InstanceInfo, r.InstanceState)
+	if err != nil {
+		return false, "", err
+	}
+	refreshed, err := refreshedState.AttrsAsObjectValue(impliedType)
+	if err != nil {
+		return false, "", err
 	}
 
-	providersMapping.SetResources(refreshedResources)
-	return nil
+	names := make([]string, 0, len(impliedType.AttributeTypes()))
+	for name := range impliedType.AttributeTypes() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var detail strings.Builder
+	for _, name := range names {
+		before, after := current.GetAttr(name), refreshed.GetAttr(name)
+		if before.RawEquals(after) {
+			continue
+		}
+		fmt.Fprintf(&detail, "%s: %s => %s\n", name, renderValue(before), renderValue(after))
+	}
+	return detail.Len() > 0, detail.String(), nil
 }
 
-func RefreshResource(r *Resource, provider *providerwrapper.ProviderWrapper) {
+func renderValue(val cty.Value) string {
+	b, err := ctyjson.Marshal(val, val.Type())
+	if err != nil {
+		return val.GoString()
+	}
+	return string(b)
+}
+
+func refreshSlowResource(parent context.Context, r *Resource, provider *providerwrapper.ProviderWrapper, timeout time.Duration, hook PostRefreshHook) error {
 	log.Println("Refreshing state...", r.InstanceInfo.Id)
-	r.Refresh(provider)
+	ctx := parent
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	err := r.refresh(ctx, provider)
+	if parent.Err() != nil {
+		return parent.Err()
+	}
+	if ctx.Err() != nil {
+		log.Printf("ERROR: Refresh of resource %s timed out after %s", r.InstanceInfo.Id, timeout)
+		return fmt.Errorf("refresh timed out after %s", timeout)
+	}
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return runPostRefreshHook(ctx, r, hook)
+}
+
+// runPostRefreshHook runs hook on r if it was refreshed successfully. When
+// the hook fails or panics, r's state is dropped so it counts as not
+// refreshed.
+func runPostRefreshHook(ctx context.Context, r *Resource, hook PostRefreshHook) error {
+	if hook == nil || r.InstanceState == nil || r.InstanceState.ID == "" {
+		return nil
+	}
+	err := func() (err error) {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				err = fmt.Errorf("panic: %v", recovered)
+			}
+		}()
+		return hook(ctx, r)
+	}()
+	if err != nil {
+		log.Printf("ERROR: Post-refresh hook failed for resource %s: %v", r.InstanceInfo.Id, err)
+		r.InstanceState = nil
+		return fmt.Errorf("post-refresh hook failed: %w", err)
+	}
+	return nil
 }
 
 func IgnoreKeys(resourcesTypes []string, p *providerwrapper.ProviderWrapper) map[string][]string {
@@ -208,6 +743,56 @@ func ContainsResource(s []Resource, e Resource) bool {
 	return false
 }
 
+// ResourceDiff lists the resources that differ between two imports, by
+// InstanceInfo.Id, in sorted order.
+type ResourceDiff struct {
+	Added   []string
+	Removed []string
+	Changed []string
+}
+
+// DiffResources compares a previous import against the current one, matching
+// resources by InstanceInfo.Id like ContainsResource does. A resource that is
+// in both is changed when its state attributes differ.
+func DiffResources(previous, current []Resource) ResourceDiff {
+	diff := ResourceDiff{}
+	before := map[string]Resource{}
+	for _, r := range previous {
+		before[r.InstanceInfo.Id] = r
+	}
+	after := map[string]Resource{}
+	for _, r := range current {
+		after[r.InstanceInfo.Id] = r
+	}
+	for id, r := range after {
+		old, ok := before[id]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, id)
+		case !reflect.DeepEqual(stateAttributes(old), stateAttributes(r)):
+			diff.Changed = append(diff.Changed, id)
+		}
+	}
+	for id := range before {
+		if _, ok := after[id]; !ok {
+			diff.Removed = append(diff.Removed, id)
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Changed)
+	return diff
+}
+
+// stateAttributes returns the attributes of r, treating a missing state like
+// one without attributes.
+func stateAttributes(r Resource) map[string]string {
+	if r.InstanceState == nil || len(r.InstanceState.Attributes) == 0 {
+		return map[string]string{}
+	}
+	return r.InstanceState.Attributes
+}
+
 // WriteState writes a state somewhere in a binary format.
 // from internal\legacy\terraform\state.go
 func writeState(d *terraform.State, dst io.Writer) error {
@@ -219,9 +804,6 @@ func writeState(d *terraform.State, dst io.Writer) error {
 	// make sure we have no uninitialized fields
 	// d.init()
 
-	// Make sure it is sorted
-	// d.sort()
-
 	// Ensure the version is set
 	d.Version = 3 //internal/legacy/terraform/state.go
 
@@ -239,7 +821,9 @@ func writeState(d *terraform.State, dst io.Writer) error {
 		// }
 	}
 
-	// Encode the data in a human-friendly way
+	// Resources, outputs and attributes are all maps, which encoding/json
+	// writes with sorted keys, so the output is stable across runs and
+	// diffs cleanly when the state is committed to version control.
 	data, err := json.MarshalIndent(d, "", "    ")
 	if err != nil {
 		return fmt.Errorf("Failed to encode state: %s", err)
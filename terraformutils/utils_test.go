@@ -0,0 +1,658 @@
+package terraformutils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/terraformer/terraformutils/providerwrapper"
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov5"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+// fakeProviderServer serves a single "fake_resource" type whose ReadResource
+// echoes the prior state back unless the test overrides it.
+type fakeProviderServer struct {
+	tfprotov5.ProviderServer
+	readResource      func(ctx context.Context, req *tfprotov5.ReadResourceRequest) (*tfprotov5.ReadResourceResponse, error)
+	configureProvider func(ctx context.Context, req *tfprotov5.ConfigureProviderRequest) (*tfprotov5.ConfigureProviderResponse, error)
+	// resourceSchema, when set, replaces the id and name schema of
+	// fake_resource.
+	resourceSchema *tfprotov5.SchemaBlock
+}
+
+func (f *fakeProviderServer) GetProviderSchema(ctx context.Context, req *tfprotov5.GetProviderSchemaRequest) (*tfprotov5.GetProviderSchemaResponse, error) {
+	if f.resourceSchema != nil {
+		return &tfprotov5.GetProviderSchemaResponse{
+			Provider:        &tfprotov5.Schema{Block: &tfprotov5.SchemaBlock{}},
+			ResourceSchemas: map[string]*tfprotov5.Schema{"fake_resource": {Block: f.resourceSchema}},
+		}, nil
+	}
+	return &tfprotov5.GetProviderSchemaResponse{
+		Provider: &tfprotov5.Schema{Block: &tfprotov5.SchemaBlock{}},
+		ResourceSchemas: map[string]*tfprotov5.Schema{
+			"fake_resource": {
+				Block: &tfprotov5.SchemaBlock{
+					Attributes: []*tfprotov5.SchemaAttribute{
+						{Name: "id", Type: tftypes.String, Optional: true, Computed: true},
+						{Name: "name", Type: tftypes.String, Optional: true},
+					},
+				},
+			},
+		},
+	}, nil
+}
+
+func (f *fakeProviderServer) ConfigureProvider(ctx context.Context, req *tfprotov5.ConfigureProviderRequest) (*tfprotov5.ConfigureProviderResponse, error) {
+	if f.configureProvider != nil {
+		return f.configureProvider(ctx, req)
+	}
+	return &tfprotov5.ConfigureProviderResponse{}, nil
+}
+
+func (f *fakeProviderServer) ReadResource(ctx context.Context, req *tfprotov5.ReadResourceRequest) (*tfprotov5.ReadResourceResponse, error) {
+	if f.readResource != nil {
+		return f.readResource(ctx, req)
+	}
+	return &tfprotov5.ReadResourceResponse{NewState: req.CurrentState}, nil
+}
+
+func (f *fakeProviderServer) ImportResourceState(ctx context.Context, req *tfprotov5.ImportResourceStateRequest) (*tfprotov5.ImportResourceStateResponse, error) {
+	return &tfprotov5.ImportResourceStateResponse{}, nil
+}
+
+func newFakeProviderWrapper(t *testing.T, server *fakeProviderServer) *providerwrapper.ProviderWrapper {
+	t.Helper()
+	provider, err := providerwrapper.NewProviderWrapperFromServer("fake", server, cty.EmptyObjectVal, providerwrapper.WithRetries(1, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+func newFakeResources(ids ...string) []*Resource {
+	resources := []*Resource{}
+	for _, id := range ids {
+		r := NewSimpleResource(id, id, "fake_resource", "fake", []string{})
+		resources = append(resources, &r)
+	}
+	return resources
+}
+
+func TestRefreshResourcesSlowQueryTimeout(t *testing.T) {
+	provider := newFakeProviderWrapper(t, &fakeProviderServer{
+		readResource: func(ctx context.Context, req *tfprotov5.ReadResourceRequest) (*tfprotov5.ReadResourceResponse, error) {
+			state, err := providerwrapper.UnmarshallDynamicValue(req.CurrentState, cty.Object(map[string]cty.Type{
+				"id":   cty.String,
+				"name": cty.String,
+			}))
+			if err != nil {
+				return nil, err
+			}
+			if state.GetAttr("id").AsString() == "hung" {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}
+			return &tfprotov5.ReadResourceResponse{NewState: req.CurrentState}, nil
+		},
+	})
+	group := newFakeResources("first", "hung", "last")
+	for _, r := range group {
+		r.SlowQueryRequired = true
+	}
+
+	done := make(chan []*Resource)
+	go func() {
+		refreshed, _ := RefreshResourcesWithOptions(nil, provider, [][]*Resource{group}, RefreshOptions{SlowQueryTimeout: time.Second})
+		done <- refreshed
+	}()
+
+	select {
+	case refreshed := <-done:
+		if len(refreshed) != 2 || refreshed[0].InstanceState.ID != "first" || refreshed[1].InstanceState.ID != "last" {
+			t.Errorf("expected first and last to be refreshed, got %v", refreshed)
+		}
+		if group[1].InstanceState != nil {
+			t.Errorf("expected hung resource to have no state, got %v", group[1].InstanceState)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("hung resource blocked its slow-query group")
+	}
+}
+
+func TestPrioritizeResources(t *testing.T) {
+	resources := []*Resource{}
+	for _, r := range []struct{ id, resourceType string }{
+		{"vm-1", "fake_instance"},
+		{"net-1", "fake_network"},
+		{"role-1", "fake_iam_role"},
+		{"vm-2", "fake_instance"},
+		{"net-2", "fake_network"},
+	} {
+		resource := NewSimpleResource(r.id, r.id, r.resourceType, "fake", []string{})
+		resources = append(resources, &resource)
+	}
+
+	prioritized := prioritizeResources(resources, map[string]int{"fake_network": 2, "fake_iam_role": 1})
+	got := []string{}
+	for _, r := range prioritized {
+		got = append(got, r.InstanceState.ID)
+	}
+	want := []string{"net-1", "net-2", "role-1", "vm-1", "vm-2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	if resources[0].InstanceState.ID != "vm-1" {
+		t.Error("expected the input order to be left alone")
+	}
+}
+
+func TestRefreshResourceDryRun(t *testing.T) {
+	provider := newFakeProviderWrapper(t, &fakeProviderServer{})
+	r := newFakeResources("same")[0]
+	r.InstanceState.Attributes["name"] = "before"
+
+	changed, detail, err := RefreshResourceDryRun(r, provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed || detail != "" {
+		t.Errorf("expected no changes, got %q", detail)
+	}
+
+	provider = newFakeProviderWrapper(t, &fakeProviderServer{
+		readResource: func(ctx context.Context, req *tfprotov5.ReadResourceRequest) (*tfprotov5.ReadResourceResponse, error) {
+			return &tfprotov5.ReadResourceResponse{NewState: providerwrapper.NewDynamicValue(cty.ObjectVal(map[string]cty.Value{
+				"id":   cty.StringVal("same"),
+				"name": cty.StringVal("after"),
+			}))}, nil
+		},
+	})
+	changed, detail, err = RefreshResourceDryRun(r, provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed || detail != "name: \"before\" => \"after\"\n" {
+		t.Errorf("expected name to differ, got %q", detail)
+	}
+	if r.InstanceState.Attributes["name"] != "before" {
+		t.Errorf("expected resource state to be left alone, got %v", r.InstanceState.Attributes)
+	}
+}
+
+func TestRefreshResourcesPostRefreshHook(t *testing.T) {
+	provider := newFakeProviderWrapper(t, &fakeProviderServer{})
+	resources := newFakeResources("enriched", "failed", "panicked")
+	slow := newFakeResources("slow")
+	slow[0].SlowQueryRequired = true
+
+	refreshed, err := RefreshResourcesWithOptions(resources, provider, [][]*Resource{slow}, RefreshOptions{
+		PostRefreshHook: func(ctx context.Context, r *Resource) error {
+			switch r.InstanceState.ID {
+			case "failed":
+				return errors.New("lookup failed")
+			case "panicked":
+				panic("boom")
+			}
+			r.InstanceState.Attributes["name"] = "enriched-" + r.InstanceState.ID
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, r := range refreshed {
+		got[r.InstanceState.ID] = r.InstanceState.Attributes["name"]
+	}
+	want := map[string]string{"enriched": "enriched-enriched", "slow": "enriched-slow"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestPrintTfStateIsStable(t *testing.T) {
+	build := func(ids ...string) []Resource {
+		var resources []Resource
+		for _, id := range ids {
+			r := NewResource(id, id, "fake_resource", "fake", map[string]string{
+				"name": id, "zone": "z1", "tags.%": "1", "tags.env": "prod",
+			}, []string{}, map[string]interface{}{})
+			r.Outputs = map[string]*terraform.OutputState{
+				id + "_id":   {Type: "string", Value: id},
+				id + "_name": {Type: "string", Value: id},
+			}
+			resources = append(resources, r)
+		}
+		return resources
+	}
+
+	first, err := PrintTfState(build("c", "a", "d", "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		again, err := PrintTfState(build("b", "d", "a", "c"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(first, again) {
+			t.Fatalf("serialized state differs between runs\nfirst:\n%s\nagain:\n%s", first, again)
+		}
+	}
+}
+
+func TestNewTfStateCheckedDuplicateNames(t *testing.T) {
+	resource := func(id, name string) Resource {
+		return NewResource(id, name, "fake_resource", "fake", map[string]string{"name": name}, []string{}, map[string]interface{}{})
+	}
+	state, err := NewTfStateChecked([]Resource{resource("a", "web"), resource("b", "db")}, TfStateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Modules[0].Resources) != 2 {
+		t.Errorf("expected 2 resources, got %v", state.Modules[0].Resources)
+	}
+
+	_, err = NewTfStateChecked([]Resource{resource("a", "web"), resource("b", "web"), resource("c", "db")}, TfStateOptions{})
+	if err == nil || err.Error() != "duplicate resource addresses: fake_resource.tfer--web" {
+		t.Errorf("expected a duplicate address error, got %v", err)
+	}
+}
+
+func TestDiffResources(t *testing.T) {
+	resource := func(id string, attributes map[string]string) Resource {
+		return NewResource(id, id, "fake_resource", "fake", attributes, []string{}, map[string]interface{}{})
+	}
+	previous := []Resource{
+		resource("kept", map[string]string{"name": "kept"}),
+		resource("changed", map[string]string{"name": "before"}),
+		resource("removed", map[string]string{"name": "removed"}),
+		resource("emptied", map[string]string{}),
+	}
+	current := []Resource{
+		resource("added", map[string]string{"name": "added"}),
+		resource("changed", map[string]string{"name": "after"}),
+		resource("emptied", nil),
+		resource("kept", map[string]string{"name": "kept"}),
+	}
+
+	diff := DiffResources(previous, current)
+	want := ResourceDiff{
+		Added:   []string{"fake_resource.tfer--added"},
+		Removed: []string{"fake_resource.tfer--removed"},
+		Changed: []string{"fake_resource.tfer--changed"},
+	}
+	if !reflect.DeepEqual(diff, want) {
+		t.Errorf("expected %+v, got %+v", want, diff)
+	}
+	if diff := DiffResources(current, current); len(diff.Added)+len(diff.Removed)+len(diff.Changed) != 0 {
+		t.Errorf("expected no differences against itself, got %+v", diff)
+	}
+}
+
+func TestStreamResources(t *testing.T) {
+	resources := newFakeResources("web", "db")
+	resources[0].InstanceState.Attributes["name"] = "web"
+	resources[1].InstanceState.Attributes["name"] = "db"
+	var buf bytes.Buffer
+	if err := StreamResources(resources, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != len(resources) {
+		t.Fatalf("expected one line per resource, got %q", buf.String())
+	}
+	for i, line := range lines {
+		var got StreamedResource
+		if err := json.Unmarshal([]byte(line), &got); err != nil {
+			t.Fatalf("line %d isn't valid JSON: %v", i, err)
+		}
+		r := resources[i]
+		if got.Address != r.InstanceInfo.Type+"."+r.ResourceName || got.State.Type != "fake_resource" || got.State.Provider != "provider.fake" {
+			t.Errorf("line %d doesn't match the resource: %s", i, line)
+		}
+		if !reflect.DeepEqual(got.State.Primary.Attributes, r.InstanceState.Attributes) || got.State.Primary.ID != r.InstanceState.ID {
+			t.Errorf("line %d has state %#v, expected %#v", i, got.State.Primary, r.InstanceState)
+		}
+	}
+}
+
+func TestRefreshBudgetBoundsAllProviders(t *testing.T) {
+	var inFlight, maxInFlight int32
+	var mutex sync.Mutex
+	read := func(ctx context.Context, req *tfprotov5.ReadResourceRequest) (*tfprotov5.ReadResourceResponse, error) {
+		mutex.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mutex.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mutex.Lock()
+		inFlight--
+		mutex.Unlock()
+		return &tfprotov5.ReadResourceResponse{NewState: req.CurrentState}, nil
+	}
+	options := DefaultRefreshOptions()
+	options.Budget = NewRefreshBudget(3)
+
+	var wg sync.WaitGroup
+	for _, name := range []string{"first", "second"} {
+		provider := newFakeProviderWrapper(t, &fakeProviderServer{readResource: read})
+		var ids []string
+		for i := 0; i < 10; i++ {
+			ids = append(ids, fmt.Sprintf("%s-%d", name, i))
+		}
+		resources := newFakeResources(ids...)
+		slow := newFakeResources(name + "-slow")
+		slow[0].SlowQueryRequired = true
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			refreshed, err := RefreshResourcesWithOptions(resources, provider, [][]*Resource{slow}, options)
+			if err != nil || len(refreshed) != 11 {
+				t.Errorf("expected 11 refreshed resources, got %d, %v", len(refreshed), err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if maxInFlight > 3 {
+		t.Errorf("expected at most 3 refreshes in flight, got %d", maxInFlight)
+	}
+	if maxInFlight < 2 {
+		t.Errorf("expected refreshes to run concurrently, got at most %d in flight", maxInFlight)
+	}
+}
+
+func TestRefreshResourcesProgress(t *testing.T) {
+	provider := newFakeProviderWrapper(t, &fakeProviderServer{
+		readResource: func(ctx context.Context, req *tfprotov5.ReadResourceRequest) (*tfprotov5.ReadResourceResponse, error) {
+			state, err := providerwrapper.UnmarshallDynamicValue(req.CurrentState, cty.Object(map[string]cty.Type{
+				"id":   cty.String,
+				"name": cty.String,
+			}))
+			if err != nil {
+				return nil, err
+			}
+			if state.GetAttr("id").AsString() == "gone" {
+				return nil, fmt.Errorf("not found")
+			}
+			return &tfprotov5.ReadResourceResponse{NewState: req.CurrentState}, nil
+		},
+	})
+	slow := newFakeResources("slow")
+	slow[0].SlowQueryRequired = true
+	var progress []RefreshProgress
+	options := DefaultRefreshOptions()
+	options.Progress = func(p RefreshProgress) { progress = append(progress, p) }
+
+	refreshed, err := RefreshResourcesWithOptions(newFakeResources("a", "gone", "b"), provider, [][]*Resource{slow}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refreshed) != 3 {
+		t.Errorf("expected 3 refreshed resources, got %d", len(refreshed))
+	}
+	if len(progress) != 4 {
+		t.Fatalf("expected one progress report per resource, got %v", progress)
+	}
+	for i, p := range progress {
+		if p.Done != i+1 || p.Total != 4 {
+			t.Errorf("expected report %d to count %d done of 4, got %+v", i, i+1, p)
+		}
+	}
+	if last := progress[len(progress)-1]; last.Failed != 1 {
+		t.Errorf("expected one failed resource, got %+v", last)
+	}
+}
+
+func TestRefreshResourcesWithFailures(t *testing.T) {
+	provider := newFakeProviderWrapper(t, &fakeProviderServer{
+		readResource: func(ctx context.Context, req *tfprotov5.ReadResourceRequest) (*tfprotov5.ReadResourceResponse, error) {
+			state, err := providerwrapper.UnmarshallDynamicValue(req.CurrentState, cty.Object(map[string]cty.Type{
+				"id":   cty.String,
+				"name": cty.String,
+			}))
+			if err != nil {
+				return nil, err
+			}
+			if state.GetAttr("id").AsString() == "gone" {
+				return nil, fmt.Errorf("not found")
+			}
+			return &tfprotov5.ReadResourceResponse{NewState: req.CurrentState}, nil
+		},
+	})
+	slow := newFakeResources("slow", "rejected")
+	for _, r := range slow {
+		r.SlowQueryRequired = true
+	}
+	options := DefaultRefreshOptions()
+	options.PostRefreshHook = func(ctx context.Context, r *Resource) error {
+		if r.InstanceState.ID == "rejected" {
+			return errors.New("missing tags")
+		}
+		return nil
+	}
+
+	refreshed, failures, err := RefreshResourcesWithFailures(context.Background(), newFakeResources("a", "gone", "b"), provider, [][]*Resource{slow}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refreshed) != 3 {
+		t.Errorf("expected 3 refreshed resources, got %d", len(refreshed))
+	}
+	if len(failures) != 2 {
+		t.Fatalf("expected 2 failures, got %+v", failures)
+	}
+	if failures[0].ResourceName != "tfer--gone" || !strings.Contains(failures[0].Reason, "import") {
+		t.Errorf("expected the failed import of gone, got %+v", failures[0])
+	}
+	if failures[1] != (RefreshFailure{ResourceName: "tfer--rejected", Reason: "post-refresh hook failed: missing tags"}) {
+		t.Errorf("expected the rejection of the hook, got %+v", failures[1])
+	}
+}
+
+func TestRefreshResourcesConcurrency(t *testing.T) {
+	var inFlight, peak int32
+	provider := newFakeProviderWrapper(t, &fakeProviderServer{
+		readResource: func(ctx context.Context, req *tfprotov5.ReadResourceRequest) (*tfprotov5.ReadResourceResponse, error) {
+			n := atomic.AddInt32(&inFlight, 1)
+			defer atomic.AddInt32(&inFlight, -1)
+			for {
+				max := atomic.LoadInt32(&peak)
+				if n <= max || atomic.CompareAndSwapInt32(&peak, max, n) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+			return &tfprotov5.ReadResourceResponse{NewState: req.CurrentState}, nil
+		},
+	})
+	ids := []string{"a", "b", "c", "d"}
+	slowResources := func() [][]*Resource {
+		slow := newFakeResources(ids...)
+		for _, r := range slow {
+			r.SlowQueryRequired = true
+		}
+		return [][]*Resource{slow}
+	}
+
+	for _, test := range []struct {
+		name     string
+		options  RefreshOptions
+		regular  []*Resource
+		slow     [][]*Resource
+		wantPeak int32
+	}{
+		{"regular", RefreshOptions{Concurrency: 2}, newFakeResources(ids...), nil, 2},
+		{"slow group", RefreshOptions{Concurrency: 4}, nil, slowResources(), 1},
+		{"slow batches", RefreshOptions{Concurrency: 1, SlowQueryBatchSize: 2}, nil, slowResources(), 2},
+		{"slow concurrency", RefreshOptions{Concurrency: 1, SlowQueryBatchSize: 1, SlowQueryConcurrency: 3}, nil, slowResources(), 3},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			atomic.StoreInt32(&peak, 0)
+			refreshed, err := RefreshResourcesWithOptions(test.regular, provider, test.slow, test.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(refreshed) != len(ids) {
+				t.Errorf("expected %d refreshed resources, got %d", len(ids), len(refreshed))
+			}
+			if got := atomic.LoadInt32(&peak); got != test.wantPeak {
+				t.Errorf("expected %d refreshes at once, got %d", test.wantPeak, got)
+			}
+		})
+	}
+}
+
+func TestBatchResources(t *testing.T) {
+	groups := [][]*Resource{newFakeResources("a", "b", "c"), newFakeResources("d")}
+	if got := batchResources(groups, 0); !reflect.DeepEqual(got, groups) {
+		t.Errorf("expected the groups to be left whole, got %v", got)
+	}
+	var sizes []int
+	for _, batch := range batchResources(groups, 2) {
+		sizes = append(sizes, len(batch))
+	}
+	if !reflect.DeepEqual(sizes, []int{2, 1, 1}) {
+		t.Errorf("expected batches of 2, 1 and 1 resources, got %v", sizes)
+	}
+}
+
+func TestRefreshResourcesContextStopsDispatch(t *testing.T) {
+	var reads int32
+	provider := newFakeProviderWrapper(t, &fakeProviderServer{
+		readResource: func(ctx context.Context, req *tfprotov5.ReadResourceRequest) (*tfprotov5.ReadResourceResponse, error) {
+			atomic.AddInt32(&reads, 1)
+			return &tfprotov5.ReadResourceResponse{NewState: req.CurrentState}, nil
+		},
+	})
+	var ids []string
+	for i := 0; i < 100; i++ {
+		ids = append(ids, fmt.Sprintf("r-%d", i))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	options := DefaultRefreshOptions()
+	options.Budget = NewRefreshBudget(1)
+	options.Progress = func(RefreshProgress) { cancel() }
+
+	refreshed, err := RefreshResourcesContext(ctx, newFakeResources(ids...), provider, nil, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the 16 workers may each have started a refresh before the cancellation
+	if got := atomic.LoadInt32(&reads); got == 0 || got > 16 {
+		t.Errorf("expected dispatch to stop after the cancellation, got %d reads", got)
+	}
+	// reads in flight when the context is cancelled may reach the provider
+	// and still fail on the client side
+	if got := atomic.LoadInt32(&reads); int32(len(refreshed)) > got {
+		t.Errorf("expected at most the %d read resources to be refreshed, got %d", got, len(refreshed))
+	}
+}
+
+func TestRefreshResourcesContextAbortsInFlightReads(t *testing.T) {
+	provider := newFakeProviderWrapper(t, &fakeProviderServer{
+		readResource: func(ctx context.Context, req *tfprotov5.ReadResourceRequest) (*tfprotov5.ReadResourceResponse, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	})
+	slow := newFakeResources("slow")
+	slow[0].SlowQueryRequired = true
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	done := make(chan []RefreshFailure)
+	go func() {
+		_, failures, _ := RefreshResourcesWithFailures(ctx, newFakeResources("hung"), provider, [][]*Resource{slow}, DefaultRefreshOptions())
+		done <- failures
+	}()
+
+	select {
+	case failures := <-done:
+		if len(failures) != 2 {
+			t.Fatalf("expected both resources to fail, got %v", failures)
+		}
+		for _, failure := range failures {
+			if failure.Reason != context.DeadlineExceeded.Error() {
+				t.Errorf("expected %s to fail with the deadline, got %q", failure.ResourceName, failure.Reason)
+			}
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("cancelling the context didn't abort the in-flight reads")
+	}
+}
+
+func TestPrintTfStateValidated(t *testing.T) {
+	build := func() []Resource {
+		return []Resource{
+			NewResource("vpc-1", "main", "fake_resource", "fake", map[string]string{"name": "main"}, []string{}, map[string]interface{}{}),
+			NewResource("vpc-2", "other", "fake_resource", "fake", map[string]string{"name": "other"}, []string{}, map[string]interface{}{}),
+		}
+	}
+	b, err := PrintTfStateValidated(build(), TfStateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain, _ := PrintTfState(build()); !bytes.Equal(b, plain) {
+		t.Errorf("expected the validated state to be the printed state, got %s", b)
+	}
+
+	missingState := build()
+	missingState[1].InstanceState = nil
+	if _, err := PrintTfStateValidated(missingState, TfStateOptions{}); err == nil || !strings.Contains(err.Error(), "fake_resource.tfer--other has no primary instance") {
+		t.Errorf("expected the resource without state to be caught, got %v", err)
+	}
+
+	overwritten := build()
+	overwritten[1].ResourceName = overwritten[0].ResourceName
+	if _, err := PrintTfStateValidated(overwritten, TfStateOptions{}); err == nil || !strings.Contains(err.Error(), "expected 2 resources, got 1") {
+		t.Errorf("expected the overwritten resource to be caught, got %v", err)
+	}
+}
+
+func TestPrintResourceState(t *testing.T) {
+	r := NewResource("vpc-1", "main", "fake_resource", "fake", map[string]string{"name": "main"}, []string{}, map[string]interface{}{})
+	r.Outputs = map[string]*terraform.OutputState{"main_id": {Type: "string", Value: "vpc-1"}}
+
+	b, err := PrintResourceState(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state terraform.State
+	if err := json.Unmarshal(b, &state); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.Validate(); err != nil {
+		t.Errorf("expected a valid state, got %v", err)
+	}
+	if state.Version != 3 || len(state.Modules) != 1 {
+		t.Fatalf("expected a version 3 state with the root module, got %s", b)
+	}
+	module := state.Modules[0]
+	if len(module.Resources) != 1 || len(module.Outputs) != 1 {
+		t.Fatalf("expected only the resource and its output, got %s", b)
+	}
+	resourceState := module.Resources["fake_resource.tfer--main"]
+	if resourceState == nil || resourceState.Primary.ID != "vpc-1" || resourceState.Primary.Attributes["name"] != "main" {
+		t.Errorf("expected the resource state to round-trip, got %s", b)
+	}
+	if module.Outputs["main_id"].Value != "vpc-1" {
+		t.Errorf("expected the output to round-trip, got %s", b)
+	}
+}